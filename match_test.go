@@ -0,0 +1,43 @@
+package libOpenflow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"antrea.io/libOpenflow/openflow13"
+	"antrea.io/libOpenflow/openflow15"
+)
+
+func TestMatchFingerprint(t *testing.T) {
+	srcIP := net.ParseIP("10.0.0.1")
+	dstIP := net.ParseIP("10.0.0.2")
+	dstMask := net.ParseIP("255.255.255.0")
+
+	of13Match := openflow13.NewMatch()
+	of13Match.AddField(*openflow13.NewEthTypeField(0x0800))
+	of13Match.AddField(*openflow13.NewIpv4SrcField(srcIP, nil))
+	of13Match.AddField(*openflow13.NewIpv4DstField(dstIP, &dstMask))
+	of13Match.AddField(*openflow13.NewIpProtoField(6))
+	of13Match.AddField(*openflow13.NewTcpSrcField(12345))
+	of13Match.AddField(*openflow13.NewTcpDstField(80))
+
+	// Use NXM encodings for the IP addresses and a different field order in the OpenFlow 1.5 Match.
+	of15Match := openflow15.NewMatch()
+	of15Match.AddField(*openflow15.NewTcpDstField(80))
+	of15Match.AddField(*openflow15.NewTcpSrcField(12345))
+	ipSrcField, _ := openflow15.FindFieldHeaderByName("NXM_OF_IP_SRC", false)
+	ipSrcField.Value = &openflow15.Ipv4SrcField{Ipv4Src: srcIP}
+	of15Match.AddField(*ipSrcField)
+	ipDstField, _ := openflow15.FindFieldHeaderByName("NXM_OF_IP_DST", true)
+	ipDstField.Value = &openflow15.Ipv4DstField{Ipv4Dst: dstIP}
+	ipDstField.Mask = &openflow15.Ipv4DstField{Ipv4Dst: dstMask}
+	of15Match.AddField(*ipDstField)
+	of15Match.AddField(*openflow15.NewIpProtoField(6))
+	of15Match.AddField(*openflow15.NewEthTypeField(0x0800))
+
+	expected := "eth_type=0800,ip_proto=06,ipv4_dst=0a000002/ffffff00,ipv4_src=0a000001,tcp_dst=0050,tcp_src=3039"
+	assert.Equal(t, expected, of13Match.Fingerprint())
+	assert.Equal(t, expected, of15Match.Fingerprint())
+}
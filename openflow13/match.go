@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 
@@ -12,7 +13,10 @@ import (
 
 // ofp_match 1.3
 type Match struct {
-	Type   uint16
+	Type uint16
+	// Length is the length of the Match without the padding, which is the length in the header on
+	// the wire. It's maintained by AddField and UnmarshalBinary, and RecomputeLength should be
+	// called after Fields is modified in other ways. Len returns the length with the padding.
 	Length uint16
 	Fields []MatchField
 }
@@ -80,21 +84,32 @@ func (m *Match) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *Match) UnmarshalBinary(data []byte) error {
-
+	if len(data) < 4 {
+		return fmt.Errorf("%w: %d bytes are too short for a Match header", io.ErrShortBuffer, len(data))
+	}
 	n := 0
 	m.Type = binary.BigEndian.Uint16(data[n:])
 	n += 2
 	m.Length = binary.BigEndian.Uint16(data[n:])
 	n += 2
+	if int(m.Length) < 4 || len(data) < int(m.Length) {
+		err := fmt.Errorf("%w: Match has length %d, but there are %d bytes", io.ErrShortBuffer, m.Length, len(data))
+		m.RecomputeLength()
+		return err
+	}
+	// The fields must not overrun the Match.
+	data = data[:m.Length]
 
 	for n < int(m.Length) {
 		field := new(MatchField)
 		if err := field.UnmarshalBinary(data[n:]); err != nil {
+			m.RecomputeLength()
 			return err
 		}
 		m.Fields = append(m.Fields, *field)
 		n += int(field.Len())
 	}
+	m.RecomputeLength()
 	return nil
 }
 
@@ -103,6 +118,31 @@ func (m *Match) AddField(f MatchField) {
 	m.Length += f.Len()
 }
 
+// RecomputeLength sets Length to the length of the Match header and the fields without the padding.
+func (m *Match) RecomputeLength() {
+	m.Length = 4
+	for i := range m.Fields {
+		m.Length += m.Fields[i].Len()
+	}
+}
+
+// Fingerprint returns a representation of the Match which is stable across OpenFlow versions, so
+// that it could be used to correlate the same logical flow in logs. Each field is rendered as
+// "name=value[/mask]" with a name which doesn't depend on the field encoding, and the fields are
+// sorted so that the result doesn't depend on their order either.
+func (m *Match) Fingerprint() string {
+	fields := make([]string, 0, len(m.Fields))
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		var mask util.Message
+		if f.HasMask {
+			mask = f.Mask
+		}
+		fields = append(fields, util.FieldFingerprint(util.SemanticFieldName(oxxFieldNameMap, f.Class, f.Field), f.Value, mask))
+	}
+	return util.Fingerprint(fields)
+}
+
 func (m *MatchField) Len() (n uint16) {
 	n = 4
 	if m.ExperimenterID != 0 {
@@ -116,7 +156,12 @@ func (m *MatchField) Len() (n uint16) {
 	return
 }
 
+// MarshalBinary returns an error if the Length in the header doesn't equal the length of the value,
+// mask and experimenter ID, rather than a malformed OXM which would be rejected by the switch.
 func (m *MatchField) MarshalBinary() (data []byte, err error) {
+	if expected := m.Len() - 4; uint16(m.Length) != expected {
+		return nil, fmt.Errorf("MatchField (class: %d, field: %d) has Length %d, but its payload is %d bytes", m.Class, m.Field, m.Length, expected)
+	}
 	data = make([]byte, int(m.Len()))
 
 	n := 0
@@ -135,6 +180,11 @@ func (m *MatchField) MarshalBinary() (data []byte, err error) {
 	data[n] = m.Length
 	n += 1
 
+	if m.ExperimenterID != 0 {
+		binary.BigEndian.PutUint32(data[n:], m.ExperimenterID)
+		n += 4
+	}
+
 	b, err := m.Value.MarshalBinary()
 	copy(data[n:], b)
 	n += len(b)
@@ -148,6 +198,9 @@ func (m *MatchField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *MatchField) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("%w: %d bytes left for the MatchField header", io.ErrShortBuffer, len(data))
+	}
 	var n uint16
 	var err error
 	m.Class = binary.BigEndian.Uint16(data[n:])
@@ -166,6 +219,9 @@ func (m *MatchField) UnmarshalBinary(data []byte) error {
 	n += 1
 
 	if m.Class == OXM_CLASS_EXPERIMENTER {
+		if len(data) < int(n)+4 {
+			return fmt.Errorf("%w: %d bytes left for the experimenter ID", io.ErrShortBuffer, len(data)-int(n))
+		}
 		experimenterID := binary.BigEndian.Uint32(data[n:])
 		if experimenterID == ONF_EXPERIMENTER_ID {
 			n += 4
@@ -178,9 +234,16 @@ func (m *MatchField) UnmarshalBinary(data []byte) error {
 	if m.Value, err = DecodeMatchField(m.Class, m.Field, m.Length, m.HasMask, data[n:]); err != nil {
 		return err
 	}
+	if err = m.checkLength(); err != nil {
+		return err
+	}
 	n += m.Value.Len()
 
 	if m.HasMask {
+		// The mask has the same length as the value.
+		if len(data) < int(n+m.Value.Len()) {
+			return fmt.Errorf("%w: %d bytes left for the mask of %d bytes", io.ErrShortBuffer, len(data)-int(n), m.Value.Len())
+		}
 		if m.Mask, err = DecodeMatchField(m.Class, m.Field, m.Length, m.HasMask, data[n:]); err != nil {
 			return err
 		}
@@ -189,6 +252,29 @@ func (m *MatchField) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// checkLength returns an error if the Length of the MatchField decoded from the wire doesn't equal
+// the length of its value, mask and experimenter ID, so that a masked field is split into a value
+// and a mask of the same width, e.g. 8 bytes of ct_mark are 4 bytes of value and 4 bytes of mask.
+// It is not checked for the variable-width values, i.e. tun_metadata and xxreg, the width of which
+// is derived from the declared length.
+func (m *MatchField) checkLength() error {
+	switch m.Value.(type) {
+	case *ByteArrayField:
+		return nil
+	}
+	expected := m.Value.Len()
+	if m.HasMask {
+		expected *= 2
+	}
+	if m.ExperimenterID != 0 {
+		expected += 4
+	}
+	if uint16(m.Length) != expected {
+		return fmt.Errorf("invalid length %d of MatchField with class %d and field %d, expected %d", m.Length, m.Class, m.Field, expected)
+	}
+	return nil
+}
+
 func (m *MatchField) MarshalHeader() uint32 {
 	var maskData uint32
 	if m.HasMask {
@@ -347,6 +433,7 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case NXM_NX_REG15:
 			val = new(Uint32Message)
 		case NXM_NX_TUN_ID:
+			val = new(TunnelIdField)
 		case NXM_NX_ARP_SHA:
 			val = new(ArpXHaField)
 		case NXM_NX_ARP_THA:
@@ -366,11 +453,15 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case NXM_NX_ND_TLL:
 			val = new(EthSrcField)
 		case NXM_NX_IP_FRAG:
+			val = new(Uint8Message)
 		case NXM_NX_IPV6_LABEL:
+			val = new(Uint32Message)
 		case NXM_NX_IP_ECN:
+			val = new(Uint8Message)
 		case NXM_NX_IP_TTL:
 			val = new(TtlField)
 		case NXM_NX_MPLS_TTL:
+			val = new(Uint8Message)
 		case NXM_NX_TUN_IPV4_SRC:
 			val = new(TunnelIpv4SrcField)
 		case NXM_NX_TUN_IPV4_DST:
@@ -378,12 +469,17 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case NXM_NX_PKT_MARK:
 			val = new(Uint32Message)
 		case NXM_NX_TCP_FLAGS:
+			val = new(TcpFlagsField)
 		case NXM_NX_DP_HASH:
+			val = new(Uint32Message)
 		case NXM_NX_RECIRC_ID:
+			val = new(Uint32Message)
 		case NXM_NX_CONJ_ID:
 			val = new(Uint32Message)
 		case NXM_NX_TUN_GBP_ID:
+			val = new(Uint16Message)
 		case NXM_NX_TUN_GBP_FLAGS:
+			val = new(Uint8Message)
 		case NXM_NX_TUN_METADATA0:
 			fallthrough
 		case NXM_NX_TUN_METADATA1:
@@ -407,6 +503,7 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 			}
 			val = msg
 		case NXM_NX_TUN_FLAGS:
+			val = new(Uint16Message)
 		case NXM_NX_CT_STATE:
 			val = new(Uint32Message)
 		case NXM_NX_CT_ZONE:
@@ -464,17 +561,16 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 			val = new(TcpFlagsField)
 		case OXM_FIELD_ACTSET_OUTPUT:
 			val = new(ActsetOutputField)
+		default:
+			return nil, fmt.Errorf("unknown field for experimenter: %v", field)
 		}
 		err := val.UnmarshalBinary(data)
 		if err != nil {
 			return nil, err
 		}
 		return val, nil
-	} else {
-		log.Panicf("Unsupported match field: %d in class: %d", field, class)
 	}
-
-	return nil, nil
+	return nil, fmt.Errorf("unsupported match field: %d in class: %d", field, class)
 }
 
 // ofp_match_type 1.3
@@ -623,6 +719,9 @@ func (m *InPortField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *InPortField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal InPortField message")
+	}
 	m.InPort = binary.BigEndian.Uint32(data)
 	return nil
 }
@@ -657,6 +756,9 @@ func (m *EthDstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *EthDstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal EthDstField message")
+	}
 	m.EthDst = make([]byte, 6)
 	copy(m.EthDst, data)
 	return nil
@@ -701,6 +803,9 @@ func (m *EthSrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *EthSrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal EthSrcField message")
+	}
 	m.EthSrc = make([]byte, 6)
 	copy(m.EthSrc, data)
 	return nil
@@ -745,6 +850,9 @@ func (m *EthTypeField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *EthTypeField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal EthTypeField message")
+	}
 	m.EthType = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -782,19 +890,31 @@ func (m *VlanIdField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *VlanIdField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal VlanIdField message")
+	}
 	m.VlanId = binary.BigEndian.Uint16(data)
 	return nil
 }
 
 // Return a MatchField for vlan id matching
 func NewVlanIdField(vlanId uint16, vlanMask *uint16) *MatchField {
+	return NewVlanIdFieldWithPresent(vlanId, vlanMask, true)
+}
+
+// NewVlanIdFieldWithPresent returns a MatchField for vlan id matching, the OFPVID_PRESENT bit is set
+// in the value only if present is true. NewVlanIdField always sets the bit.
+func NewVlanIdFieldWithPresent(vlanId uint16, vlanMask *uint16, present bool) *MatchField {
 	f := new(MatchField)
 	f.Class = OXM_CLASS_OPENFLOW_BASIC
 	f.Field = OXM_FIELD_VLAN_VID
 	f.HasMask = false
 
 	vlanIdField := new(VlanIdField)
-	vlanIdField.VlanId = vlanId | OFPVID_PRESENT
+	vlanIdField.VlanId = vlanId
+	if present {
+		vlanIdField.VlanId |= OFPVID_PRESENT
+	}
 	f.Value = vlanIdField
 	f.Length = uint8(vlanIdField.Len())
 
@@ -808,6 +928,20 @@ func NewVlanIdField(vlanId uint16, vlanMask *uint16) *MatchField {
 	return f
 }
 
+// NewVlanPresentField returns a MatchField matching the packets with any VLAN tag, i.e.
+// vlan_vid=0x1000/0x1000.
+func NewVlanPresentField() *MatchField {
+	mask := uint16(OFPVID_PRESENT)
+	return NewVlanIdFieldWithPresent(OFPVID_PRESENT, &mask, false)
+}
+
+// NewVlanNoneField returns a MatchField matching the packets without VLAN tag, i.e.
+// vlan_vid=0x0000/0x1000.
+func NewVlanNoneField() *MatchField {
+	mask := uint16(OFPVID_PRESENT)
+	return NewVlanIdFieldWithPresent(OFPVID_NONE, &mask, false)
+}
+
 // MplsLabel field
 type MplsLabelField struct {
 	MplsLabel uint32
@@ -824,6 +958,9 @@ func (m *MplsLabelField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MplsLabelField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MplsLabelField message")
+	}
 	m.MplsLabel = binary.BigEndian.Uint32(data)
 	return nil
 }
@@ -858,6 +995,9 @@ func (m *MplsBosField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MplsBosField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MplsBosField message")
+	}
 	m.MplsBos = data[0]
 	return nil
 }
@@ -929,6 +1069,9 @@ func (m *Ipv4SrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *Ipv4SrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv4SrcField message")
+	}
 	m.Ipv4Src = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -972,6 +1115,9 @@ func (m *Ipv4DstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *Ipv4DstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv4DstField message")
+	}
 	m.Ipv4Dst = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1009,17 +1155,33 @@ func (m *Ipv6SrcField) Len() uint16 {
 	return 16
 }
 func (m *Ipv6SrcField) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, 16)
-	copy(data, m.Ipv6Src)
-	return
+	return marshalIpv6(m.Ipv6Src)
 }
 
 func (m *Ipv6SrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv6SrcField message")
+	}
 	m.Ipv6Src = make([]byte, 16)
 	copy(m.Ipv6Src, data)
 	return nil
 }
 
+// marshalIpv6 returns the 16-byte representation of ip. An IPv4 address is encoded as an
+// IPv4-mapped IPv6 address, and an empty ip is encoded as the unspecified address.
+func marshalIpv6(ip net.IP) ([]byte, error) {
+	data := make([]byte, 16)
+	if len(ip) == 0 {
+		return data, nil
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil, fmt.Errorf("invalid IP address with length %d", len(ip))
+	}
+	copy(data, ip16)
+	return data, nil
+}
+
 // Return a MatchField for ipv6 src addr
 func NewIpv6SrcField(ipSrc net.IP, ipSrcMask *net.IP) *MatchField {
 	f := new(MatchField)
@@ -1053,12 +1215,13 @@ func (m *Ipv6DstField) Len() uint16 {
 	return 16
 }
 func (m *Ipv6DstField) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, 16)
-	copy(data, m.Ipv6Dst)
-	return
+	return marshalIpv6(m.Ipv6Dst)
 }
 
 func (m *Ipv6DstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv6DstField message")
+	}
 	m.Ipv6Dst = make([]byte, 16)
 	copy(m.Ipv6Dst, data)
 	return nil
@@ -1103,6 +1266,9 @@ func (m *IpProtoField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *IpProtoField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal IpProtoField message")
+	}
 	m.protocol = data[0]
 	return nil
 }
@@ -1137,6 +1303,9 @@ func (m *IpDscpField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *IpDscpField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal IpDscpField message")
+	}
 	m.dscp = data[0]
 	return nil
 }
@@ -1171,6 +1340,9 @@ func (m *TunnelIdField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *TunnelIdField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TunnelIdField message")
+	}
 	m.TunnelId = binary.BigEndian.Uint64(data)
 	return nil
 }
@@ -1205,6 +1377,9 @@ func (m *MetadataField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MetadataField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MetadataField message")
+	}
 	m.Metadata = binary.BigEndian.Uint64(data)
 	return nil
 }
@@ -1248,6 +1423,9 @@ func (m *PortField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *PortField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal PortField message")
+	}
 	m.port = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -1328,6 +1506,9 @@ func (m *TcpFlagsField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *TcpFlagsField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TcpFlagsField message")
+	}
 	m.TcpFlags = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -1371,6 +1552,9 @@ func (m *ArpOperField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *ArpOperField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal ArpOperField message")
+	}
 	m.ArpOper = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -1405,6 +1589,9 @@ func (m *TunnelIpv4SrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *TunnelIpv4SrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TunnelIpv4SrcField message")
+	}
 	m.TunnelIpv4Src = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1448,6 +1635,9 @@ func (m *TunnelIpv4DstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *TunnelIpv4DstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TunnelIpv4DstField message")
+	}
 	m.TunnelIpv4Dst = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1609,6 +1799,7 @@ func (m *ArpXHaField) UnmarshalBinary(data []byte) error {
 	if len(data) < int(m.Len()) {
 		return errors.New("The byte array has wrong size to unmarshal ArpXHaField message")
 	}
+	m.ArpHa = make([]byte, 6)
 	copy(m.ArpHa, data[:6])
 	return nil
 }
@@ -1703,6 +1894,9 @@ func (m *ActsetOutputField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *ActsetOutputField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal ActsetOutputField message")
+	}
 	m.OutputPort = binary.BigEndian.Uint32(data)
 	return nil
 }
@@ -0,0 +1,96 @@
+package openflow13
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+func TestMatchUnmarshalTruncated(t *testing.T) {
+	mask := net.IP{255, 255, 255, 0}
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	ofMatch.AddField(*NewIpProtoField(6))
+	ofMatch.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &mask))
+	ofMatch.AddField(*NewTcpDstField(80))
+	ofMatch.AddField(*NewCTLabelMatchField([16]byte{15: 1}, nil))
+	ofMatch.AddField(*NewTunMetadataField(2, []byte{1, 2, 3, 4}, nil))
+	ofMatch.AddField(*newONFTcpFlagsField(0x12, nil))
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+
+	for name, hexString := range testutil.MalformedMatchHexes {
+		if err := testutil.UnmarshalNoPanic(t, new(Match), testutil.DecodeHex(t, hexString)); err == nil {
+			t.Errorf("Expected an error when unmarshaling the match with %s", name)
+		}
+	}
+	for _, truncated := range testutil.Truncations(data, 2) {
+		// The truncations whose declared length exceeds the buffer must be rejected.
+		if err := testutil.UnmarshalNoPanic(t, new(Match), truncated); err == nil && (len(truncated) < 4 || int(binary.BigEndian.Uint16(truncated[2:])) > len(truncated)) {
+			t.Errorf("Expected an error when unmarshaling %d bytes of the match", len(truncated))
+		}
+	}
+	for _, corrupted := range testutil.Corruptions(data, 1000, 1) {
+		testutil.UnmarshalNoPanic(t, new(Match), corrupted)
+	}
+}
+
+func TestMatchLength(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		hexString string
+		fields    int
+		length    uint16
+		wantErr   bool
+	}{
+		{name: "complete", hexString: testutil.MatchHex, fields: 3, length: 21},
+		// Only the fields decoded before the cut field are counted in Length.
+		{name: "field value cut", hexString: testutil.TruncatedMatchHex, fields: 2, length: 15, wantErr: true},
+		{name: "length beyond data", hexString: testutil.MalformedMatchHexes["length beyond data"], length: 4, wantErr: true},
+	} {
+		decoded := new(Match)
+		err := decoded.UnmarshalBinary(testutil.DecodeHex(t, tc.hexString))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if len(decoded.Fields) != tc.fields || decoded.Length != tc.length {
+			t.Errorf("%s: expected %d fields and Length %d, got %d fields and Length %d", tc.name, tc.fields, tc.length, len(decoded.Fields), decoded.Length)
+		}
+	}
+
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	ofMatch.AddField(*NewIpProtoField(6))
+	ofMatch.AddField(*NewTcpDstField(80))
+	if ofMatch.Length != 21 || ofMatch.Len() != 24 {
+		t.Fatalf("Unexpected Length %d and Len %d of the match", ofMatch.Length, ofMatch.Len())
+	}
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	if !bytes.Equal(data, testutil.DecodeHex(t, testutil.MatchHex)) {
+		t.Errorf("Unexpected marshaled match %x", data)
+	}
+	ofMatch.Fields = ofMatch.Fields[:2]
+	ofMatch.RecomputeLength()
+	if ofMatch.Length != 15 || ofMatch.Len() != 16 {
+		t.Errorf("Unexpected Length %d and Len %d after removing a field", ofMatch.Length, ofMatch.Len())
+	}
+}
+
+// newONFTcpFlagsField returns a tcp_flags field in the ONF experimenter class, which is how
+// OpenFlow 1.3 switches encode it.
+func newONFTcpFlagsField(flags uint16, mask *uint16) *MatchField {
+	f := NewTcpFlagsField(flags, mask)
+	f.Class = OXM_CLASS_EXPERIMENTER
+	f.ExperimenterID = ONF_EXPERIMENTER_ID
+	// The experimenter ID is counted in Length.
+	f.Length += 4
+	return f
+}
@@ -4,9 +4,31 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 )
 
+type Uint8Message struct {
+	Data uint8
+}
+
+func (m *Uint8Message) Len() uint16 {
+	return 1
+}
+
+func (m *Uint8Message) MarshalBinary() (data []byte, err error) {
+	data = []byte{m.Data}
+	return
+}
+
+func (m *Uint8Message) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errors.New("the []byte is too short to unmarshal a full Uint8Message")
+	}
+	m.Data = data[0]
+	return nil
+}
+
 type Uint16Message struct {
 	Data uint16
 }
@@ -69,16 +91,19 @@ func (m *ByteArrayField) Len() uint16 {
 	return uint16(m.Length)
 }
 
+// MarshalBinary returns exactly Length bytes, Data is zero-padded or truncated to fit.
 func (m *ByteArrayField) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, m.Len())
 	copy(data, m.Data)
 	return
 }
 
+// UnmarshalBinary reads Length bytes from data, Length must be set before calling it, e.g. by
+// DecodeMatchField. An error wrapping io.ErrShortBuffer is returned if data is shorter than Length.
 func (m *ByteArrayField) UnmarshalBinary(data []byte) error {
 	expectLength := m.Len()
 	if len(data) < int(expectLength) {
-		return errors.New("The byte array has wrong size to unmarshal ByteArrayField message")
+		return fmt.Errorf("%w: %d bytes left for ByteArrayField of %d bytes", io.ErrShortBuffer, len(data), expectLength)
 	}
 	m.Data = make([]byte, expectLength)
 	copy(m.Data, data[:expectLength])
@@ -233,6 +258,11 @@ func newNXTunMetadataHeader(idx int, hasMask bool) *MatchField {
 	return header
 }
 
+// NewTunMetadataField returns a MatchField of NXM_NX_TUN_METADATA<idx> matching the Geneve option
+// mapped to the index, the Length of which is doubled if mask is set. The arguments are not
+// validated: idx must be in [0, 7], data must be 1 to 124 bytes, and mask, if set, must have the
+// same length as data. NewTunMetadataFieldWithValidation could be used for arguments which are not
+// known to be valid.
 func NewTunMetadataField(idx int, data []byte, mask []byte) *MatchField {
 	field := newNXTunMetadataHeader(idx, len(mask) > 0)
 
@@ -251,6 +281,21 @@ func NewTunMetadataField(idx int, data []byte, mask []byte) *MatchField {
 	return field
 }
 
+// NewTunMetadataFieldWithValidation is like NewTunMetadataField, but it returns an error if idx is
+// not in [0, 7], data is not 1 to 124 bytes, or mask is set but has a different length from data.
+func NewTunMetadataFieldWithValidation(idx int, data []byte, mask []byte) (*MatchField, error) {
+	if idx < 0 || idx > 7 {
+		return nil, fmt.Errorf("invalid tun_metadata index %d, it should be in [0, 7]", idx)
+	}
+	if len(data) == 0 || len(data) > 124 {
+		return nil, fmt.Errorf("invalid tun_metadata length %d, it should be in [1, 124]", len(data))
+	}
+	if len(mask) > 0 && len(mask) != len(data) {
+		return nil, fmt.Errorf("tun_metadata mask has %d bytes, but the data has %d bytes", len(mask), len(data))
+	}
+	return NewTunMetadataField(idx, data, mask), nil
+}
+
 func NewCTStateMatchField(states *CTStates) *MatchField {
 	field, _ := FindFieldHeaderByName("NXM_NX_CT_STATE", true)
 	field.Value = newUint32Message(states.data)
@@ -930,3 +930,76 @@ func testMatchFieldHeaderMarshalUnMarshal(tgtField *MatchField, t *testing.T) {
 		t.Errorf("Unmarshalled header has incorrect 'Length' field, expect: %d, actual: %d", testMFHeader.Length, tgtField.Length)
 	}
 }
+
+func TestDecodeNXMFields(t *testing.T) {
+	for _, name := range []string{
+		"NXM_NX_TUN_ID",
+		"NXM_NX_IP_FRAG",
+		"NXM_NX_IPV6_LABEL",
+		"NXM_NX_IP_ECN",
+		"NXM_NX_MPLS_TTL",
+		"NXM_NX_TCP_FLAGS",
+		"NXM_NX_TUN_GBP_ID",
+		"NXM_NX_TUN_GBP_FLAGS",
+		"NXM_NX_TUN_FLAGS",
+	} {
+		header, err := FindFieldHeaderByName(name, false)
+		if err != nil {
+			t.Fatalf("Failed to find header of %s: %v", name, err)
+		}
+		data := make([]byte, header.Length)
+		for i := range data {
+			data[i] = byte(i + 1)
+		}
+		value, err := DecodeMatchField(header.Class, header.Field, header.Length, false, data)
+		if err != nil {
+			t.Errorf("Failed to decode %s: %v", name, err)
+			continue
+		}
+		if value.Len() != uint16(header.Length) {
+			t.Errorf("Unexpected length of %s, expected %d, got %d", name, header.Length, value.Len())
+		}
+		encoded, _ := value.MarshalBinary()
+		if !bytes.Equal(encoded, data) {
+			t.Errorf("Unexpected value of %s, expected %x, got %x", name, data, encoded)
+		}
+	}
+	// The fields missing in the name map.
+	for _, field := range []uint8{NXM_NX_DP_HASH, NXM_NX_RECIRC_ID} {
+		data := []byte{1, 2, 3, 4}
+		value, err := DecodeMatchField(OXM_CLASS_NXM_1, field, 4, false, data)
+		if err != nil {
+			t.Errorf("Failed to decode NXM_NX field %d: %v", field, err)
+			continue
+		}
+		if encoded, _ := value.MarshalBinary(); !bytes.Equal(encoded, data) {
+			t.Errorf("Unexpected value of NXM_NX field %d, expected %x, got %x", field, data, encoded)
+		}
+	}
+}
+
+func TestMatchFieldWrongLength(t *testing.T) {
+	// eth_type declaring 8 bytes, followed by another TLV.
+	data := []byte{0x80, 0x00, 0x0a, 0x08, 0x08, 0x00, 0x80, 0x00, 0x14, 0x01, 0x06, 0x00}
+	if err := new(MatchField).UnmarshalBinary(data); err == nil {
+		t.Errorf("Expected an error for eth_type with length 8")
+	}
+	data = []byte{0x80, 0x00, 0x0a, 0x02, 0x08, 0x00}
+	if err := new(MatchField).UnmarshalBinary(data); err != nil {
+		t.Errorf("Failed to unmarshal eth_type with length 2: %v", err)
+	}
+
+	// A field with a wrong Length fails to marshal, rather than being truncated or padded.
+	for _, length := range []uint8{1, 4} {
+		field := NewEthTypeField(0x0800)
+		field.Length = length
+		if _, err := field.MarshalBinary(); err == nil {
+			t.Errorf("Expected an error when marshaling eth_type with length %d", length)
+		}
+		match := NewMatch()
+		match.AddField(*field)
+		if _, err := match.MarshalBinary(); err == nil {
+			t.Errorf("Expected an error when marshaling a match with eth_type of length %d", length)
+		}
+	}
+}
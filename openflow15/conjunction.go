@@ -0,0 +1,63 @@
+package openflow15
+
+import "fmt"
+
+// OVS implements conjunctive match flows for a match like "(a1 or a2) and (b1 or b2)", in which
+// each parenthesized set is a dimension, i.e. a clause, of the conjunction. Instead of a flow per
+// combination, there is a flow per field in a dimension with the action conjunction(id, k/n), in
+// which k is the 1-based clause number, encoded as k-1 on the wire, and n is the number of clauses.
+// A flow matching conj_id=id has the actions to execute once a packet matches at least one flow of
+// every clause. The flows of the clauses must have the same priority and no other actions.
+
+// ConjunctionClauseFlow is a flow of a clause of a conjunctive match, made of a Match and a
+// conjunction action.
+type ConjunctionClauseFlow struct {
+	Match  *Match
+	Action *NXActionConjunction
+}
+
+// ConjunctionBuilder builds the flows of a conjunctive match from the fields of its dimensions.
+type ConjunctionBuilder struct {
+	id         uint32
+	dimensions [][]MatchField
+}
+
+// NewConjunctionBuilder returns a ConjunctionBuilder for the conjunction id.
+func NewConjunctionBuilder(id uint32) *ConjunctionBuilder {
+	return &ConjunctionBuilder{id: id}
+}
+
+// AddDimension adds a dimension matching any of fields, the 0-based clause of which on the wire is
+// the number of the dimensions added before it.
+func (b *ConjunctionBuilder) AddDimension(fields ...MatchField) *ConjunctionBuilder {
+	b.dimensions = append(b.dimensions, fields)
+	return b
+}
+
+// Build returns the Match of the flow matching conj_id, and the flows of the clauses, one for each
+// field of each dimension in the order they were added. The Match of a clause flow includes the
+// prerequisites of its field, e.g. eth_type and ip_proto for tcp_dst. An error is returned if there
+// are less than 2 or more than 64 dimensions, which OVS doesn't support, or if a dimension has no
+// field.
+func (b *ConjunctionBuilder) Build() (*Match, []ConjunctionClauseFlow, error) {
+	nClause := len(b.dimensions)
+	if nClause < 2 || nClause > 64 {
+		return nil, nil, fmt.Errorf("conjunction %d has %d dimensions, it should have 2 to 64", b.id, nClause)
+	}
+	var flows []ConjunctionClauseFlow
+	for i, fields := range b.dimensions {
+		if len(fields) == 0 {
+			return nil, nil, fmt.Errorf("dimension %d of conjunction %d has no field", i+1, b.id)
+		}
+		for j := range fields {
+			match := NewMatchBuilder().AddField(*fields[j].Clone()).WithImplicitPrerequisites().Build()
+			flows = append(flows, ConjunctionClauseFlow{
+				Match:  match,
+				Action: NewNXActionConjunction(uint8(i), uint8(nClause), b.id),
+			})
+		}
+	}
+	conjMatch := NewMatch()
+	conjMatch.AddField(*NewConjIDMatchField(b.id))
+	return conjMatch, flows, nil
+}
@@ -0,0 +1,60 @@
+package openflow15
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestConjunctionBuilder(t *testing.T) {
+	conjMatch, flows, err := NewConjunctionBuilder(10).
+		AddDimension(*NewIpv4SrcField(net.IP{10, 0, 0, 1}, nil), *NewIpv4SrcField(net.IP{10, 0, 0, 2}, nil)).
+		AddDimension(*NewTcpDstField(80), *NewTcpDstField(443)).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to build conjunction: %v", err)
+	}
+	if len(conjMatch.Fields) != 1 || conjMatch.Fields[0].Class != OXM_CLASS_NXM_1 || conjMatch.Fields[0].Field != NXM_NX_CONJ_ID {
+		t.Fatalf("Expected a conj_id match, got %s", conjMatch)
+	}
+	if id := conjMatch.Fields[0].Value.(*Uint32Message).Data; id != 10 {
+		t.Errorf("Expected conj_id 10, got %d", id)
+	}
+
+	// The clause is 0-based on the wire, i.e. conjunction(10, 1/2) and conjunction(10, 2/2).
+	expected := []struct {
+		fields []uint8
+		clause uint8
+	}{
+		{[]uint8{OXM_FIELD_ETH_TYPE, OXM_FIELD_IPV4_SRC}, 0},
+		{[]uint8{OXM_FIELD_ETH_TYPE, OXM_FIELD_IPV4_SRC}, 0},
+		{[]uint8{OXM_FIELD_ETH_TYPE, OXM_FIELD_IP_PROTO, OXM_FIELD_TCP_DST}, 1},
+		{[]uint8{OXM_FIELD_ETH_TYPE, OXM_FIELD_IP_PROTO, OXM_FIELD_TCP_DST}, 1},
+	}
+	if len(flows) != len(expected) {
+		t.Fatalf("Expected %d clause flows, got %d", len(expected), len(flows))
+	}
+	for i, e := range expected {
+		flow := flows[i]
+		var fields []uint8
+		for _, f := range flow.Match.Fields {
+			fields = append(fields, f.Field)
+		}
+		if !reflect.DeepEqual(fields, e.fields) {
+			t.Errorf("Unexpected match of clause flow %d: %s", i, flow.Match)
+		}
+		if err := flow.Match.Validate(); err != nil {
+			t.Errorf("Invalid match of clause flow %d: %v", i, err)
+		}
+		if flow.Action.ID != 10 || flow.Action.Clause != e.clause || flow.Action.NClause != 2 {
+			t.Errorf("Unexpected action of clause flow %d: conjunction(%d,%d/%d)", i, flow.Action.ID, flow.Action.Clause, flow.Action.NClause)
+		}
+	}
+
+	if _, _, err := NewConjunctionBuilder(1).AddDimension(*NewTcpDstField(80)).Build(); err == nil {
+		t.Errorf("Expected an error for a conjunction with 1 dimension")
+	}
+	if _, _, err := NewConjunctionBuilder(1).AddDimension(*NewTcpDstField(80)).AddDimension().Build(); err == nil {
+		t.Errorf("Expected an error for an empty dimension")
+	}
+}
@@ -1,19 +1,32 @@
 package openflow15
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
 	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"k8s.io/klog/v2"
 
+	"antrea.io/libOpenflow/protocol"
 	"antrea.io/libOpenflow/util"
 )
 
 // ofp_match 1.5
 type Match struct {
-	Type   uint16
+	Type uint16
+	// Length is the length of the Match without the padding, which is the length in the header on
+	// the wire. It's maintained by AddField and UnmarshalBinary, and RecomputeLength should be
+	// called after Fields is modified in other ways. Len returns the length with the padding.
 	Length uint16
 	Fields []MatchField
 }
@@ -61,58 +74,1276 @@ func (m *Match) Len() (n uint16) {
 }
 
 func (m *Match) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, int(m.Len()))
-
-	n := 0
-	binary.BigEndian.PutUint16(data[n:], m.Type)
-	n += 2
-	binary.BigEndian.PutUint16(data[n:], m.Length)
-	n += 2
-
-	for _, a := range m.Fields {
-		b, err := a.MarshalBinary()
-		if err != nil {
+	length := int(m.Len())
+	buf := util.GetBuffer(length)
+	defer util.PutBuffer(buf)
+
+	var header [4]byte
+	binary.BigEndian.PutUint16(header[0:], m.Type)
+	binary.BigEndian.PutUint16(header[2:], m.Length)
+	buf.Write(header[:])
+
+	// The fields are written to buf directly, rather than being marshaled and copied one by one.
+	for i := range m.Fields {
+		if err := m.Fields[i].writeTo(buf); err != nil {
 			return nil, err
 		}
-		copy(data[n:], b)
-		n += len(b)
 	}
-
-	return
+	fitBytes(buf, 0, length)
+	return bytes.Clone(buf.Bytes()), nil
 }
 
+// UnmarshalBinary decodes a Match in strict mode: if any MatchField fails to decode, no
+// field decoded from data is kept in m.Fields.
 func (m *Match) UnmarshalBinary(data []byte) error {
+	return m.unmarshalBinary(data, false)
+}
 
+// UnmarshalBinaryLenient decodes a Match in lenient mode: if a MatchField fails to decode,
+// the fields decoded before it are kept in m.Fields and the error is returned, so that the
+// caller could inspect the part of the Match which was understood.
+func (m *Match) UnmarshalBinaryLenient(data []byte) error {
+	return m.unmarshalBinary(data, true)
+}
+
+func (m *Match) unmarshalBinary(data []byte, lenient bool) error {
+	if len(data) < 4 {
+		return fmt.Errorf("%w: %d bytes are too short for a Match header", io.ErrShortBuffer, len(data))
+	}
 	n := 0
 	m.Type = binary.BigEndian.Uint16(data[n:])
 	n += 2
 	m.Length = binary.BigEndian.Uint16(data[n:])
 	n += 2
+	if int(m.Length) < 4 || len(data) < int(m.Length) {
+		err := fmt.Errorf("%w: Match has length %d, but there are %d bytes", io.ErrShortBuffer, m.Length, len(data))
+		m.RecomputeLength()
+		return err
+	}
+	// The fields must not overrun the Match.
+	data = data[:m.Length]
 
+	fieldCount := len(m.Fields)
 	for n < int(m.Length) {
 		field := new(MatchField)
 		if err := field.UnmarshalBinary(data[n:]); err != nil {
 			klog.ErrorS(err, "Failed to unmarshal MatchField", "data", data[n:])
+			if !lenient {
+				m.Fields = m.Fields[:fieldCount]
+			}
+			m.RecomputeLength()
 			return err
 		}
 		m.Fields = append(m.Fields, *field)
 		n += int(field.Len())
 	}
+	m.RecomputeLength()
 	return nil
 }
 
+// Release returns the values of the fields in the Match to the pools used by DecodeMatchField, so
+// that they are reused when decoding other matches, and removes the fields from the Match. It could
+// be called when a decoded Match is no longer needed, e.g. after processing a flow of a large flow
+// dump. The Match, its fields and their values must not be used after it is released.
+// The Match must exclusively own the values of its fields: a value shared with another Match or
+// MatchField, e.g. by copying a MatchField without Clone, is reset and reused when it is released.
+// Only the values of the types decoded by DecodeMatchField are pooled, and a value referenced by
+// multiple fields of the Match is pooled once.
+func (m *Match) Release() {
+	// isReleased returns whether msg is referenced by a field before the i-th one, or is the Value
+	// of the i-th field when checking its Mask. The matches are small, so the fields are scanned
+	// rather than allocating a set. Only the pointers are pooled, and compared, as comparing the
+	// values of an uncomparable type panics.
+	isReleased := func(msg util.Message, i int, isMask bool) bool {
+		if msg == nil || reflect.ValueOf(msg).Kind() != reflect.Pointer {
+			return true
+		}
+		if isMask && m.Fields[i].Value == msg {
+			return true
+		}
+		for j := 0; j < i; j++ {
+			if m.Fields[j].Value == msg || m.Fields[j].Mask == msg {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range m.Fields {
+		if f := &m.Fields[i]; !isReleased(f.Value, i, false) {
+			putFieldValue(f.Value)
+		}
+		if f := &m.Fields[i]; !isReleased(f.Mask, i, true) {
+			putFieldValue(f.Mask)
+		}
+	}
+	m.Fields = nil
+	m.Length = 4
+}
+
+const (
+	// FlowModMatchOffset is the offset of the Match in a FlowMod message.
+	FlowModMatchOffset = 48
+	// FlowStatsMatchOffset is the offset of the Match in a FlowStats entry of a flow stats reply.
+	FlowStatsMatchOffset = 8
+)
+
+// UnmarshalMatchAt decodes only the Match at offset in data, e.g. FlowModMatchOffset in a FlowMod
+// message, without decoding the rest of the message. An error is returned if the Match header or
+// the declared Match length is out of data.
+func UnmarshalMatchAt(data []byte, offset int) (*Match, error) {
+	if offset < 0 || len(data) < offset+4 {
+		return nil, fmt.Errorf("%w: no Match header at offset %d of %d bytes", io.ErrShortBuffer, offset, len(data))
+	}
+	length := int(binary.BigEndian.Uint16(data[offset+2:]))
+	if length < 4 || len(data) < offset+length {
+		return nil, fmt.Errorf("%w: Match at offset %d has length %d, but %d bytes are left", io.ErrShortBuffer, offset, length, len(data)-offset)
+	}
+	m := new(Match)
+	if err := m.UnmarshalBinary(data[offset : offset+length]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SummarizeMatchBytes decodes a serialized Match and returns its String representation. An error
+// is returned if data is too short for the Match header or for the declared Match length.
+func SummarizeMatchBytes(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", fmt.Errorf("the []byte is too short to unmarshal a Match header: %d bytes", len(data))
+	}
+	if length := int(binary.BigEndian.Uint16(data[2:])); len(data) < length {
+		return "", fmt.Errorf("the []byte is too short to unmarshal a Match with length %d: %d bytes", length, len(data))
+	}
+	m := new(Match)
+	if err := m.UnmarshalBinary(data); err != nil {
+		return "", err
+	}
+	return m.String(), nil
+}
+
+// BuildExactMatch returns a Match which exactly matches the packet decoded into layers, which
+// are the protocol headers ordered from the outermost, e.g. Ethernet, IPv4 and TCP. The Match
+// includes eth_src, eth_dst, eth_type, vlan_vid, ip_proto, the IP addresses and the TCP or UDP
+// ports, in the order of the OpenFlow prerequisites. The Data of each layer is ignored.
+func BuildExactMatch(layers []util.Message) (*Match, error) {
+	var ethFields, ipFields, l4Fields []*MatchField
+	var ipProto *uint8
+	for _, layer := range layers {
+		switch l := layer.(type) {
+		case *protocol.Ethernet:
+			ethFields = append(ethFields, NewEthSrcField(l.HWSrc, nil), NewEthDstField(l.HWDst, nil), NewEthTypeField(l.Ethertype))
+			if l.VLANID.VID != 0 {
+				ethFields = append(ethFields, NewVlanIdField(l.VLANID.VID, nil))
+			}
+		case *protocol.IPv4:
+			ipFields = append(ipFields, NewIpv4SrcField(l.NWSrc, nil), NewIpv4DstField(l.NWDst, nil))
+			ipProto = &l.Protocol
+		case *protocol.IPv6:
+			ipFields = append(ipFields, NewIpv6SrcField(l.NWSrc, nil), NewIpv6DstField(l.NWDst, nil))
+			ipProto = &l.NextHeader
+		case *protocol.TCP:
+			if ipProto == nil {
+				return nil, errors.New("TCP layer without IP layer")
+			}
+			// The L4 protocol is taken from the layer, since the IPv6 NextHeader could be an extension header.
+			proto := uint8(protocol.Type_TCP)
+			ipProto = &proto
+			l4Fields = append(l4Fields, NewTcpSrcField(l.PortSrc), NewTcpDstField(l.PortDst))
+		case *protocol.UDP:
+			if ipProto == nil {
+				return nil, errors.New("UDP layer without IP layer")
+			}
+			proto := uint8(protocol.Type_UDP)
+			ipProto = &proto
+			l4Fields = append(l4Fields, NewUdpSrcField(l.PortSrc), NewUdpDstField(l.PortDst))
+		default:
+			return nil, fmt.Errorf("unsupported layer type %T", layer)
+		}
+	}
+
+	m := NewMatch()
+	for _, f := range ethFields {
+		m.AddField(*f)
+	}
+	if ipProto != nil {
+		m.AddField(*NewIpProtoField(*ipProto))
+	}
+	for _, f := range ipFields {
+		m.AddField(*f)
+	}
+	for _, f := range l4Fields {
+		m.AddField(*f)
+	}
+	return m, nil
+}
+
+// NewFiveTupleMatch returns a Match which exactly matches the packets of the 5-tuple, with the
+// eth_type and ip_proto prerequisites. The IPv4 or IPv6 fields are used according to the family of
+// the addresses. The ports are only matched if proto is TCP, UDP or SCTP. An error is returned if an
+// address is nil or invalid, or srcIP and dstIP are in different families.
+func NewFiveTupleMatch(srcIP, dstIP net.IP, proto uint8, srcPort, dstPort uint16) (*Match, error) {
+	if srcIP.To16() == nil || dstIP.To16() == nil {
+		return nil, fmt.Errorf("invalid source IP %v or destination IP %v", srcIP, dstIP)
+	}
+	isIPv4 := srcIP.To4() != nil
+	if isIPv4 != (dstIP.To4() != nil) {
+		return nil, fmt.Errorf("source IP %s and destination IP %s are in different families", srcIP, dstIP)
+	}
+	builder := NewMatchBuilder()
+	if isIPv4 {
+		builder.AddField(*NewEthTypeField(protocol.IPv4_MSG)).
+			AddField(*NewIpProtoField(proto)).
+			AddField(*NewIpv4SrcField(srcIP.To4(), nil)).
+			AddField(*NewIpv4DstField(dstIP.To4(), nil))
+	} else {
+		builder.AddField(*NewEthTypeField(protocol.IPv6_MSG)).
+			AddField(*NewIpProtoField(proto)).
+			AddField(*NewIpv6SrcField(srcIP, nil)).
+			AddField(*NewIpv6DstField(dstIP, nil))
+	}
+	if srcField, err := newTransportPortField(true, srcPort, proto); err == nil {
+		dstField, _ := newTransportPortField(false, dstPort, proto)
+		builder.AddField(*srcField).AddField(*dstField)
+	}
+	return builder.Build(), nil
+}
+
+// DistinguishingFields returns the sorted names of the fields, e.g. "tcp_dst", in which the packets
+// decoded into layersA and layersB differ, including the fields only one of the packets has. The
+// fields are the ones of the Matches built by BuildExactMatch from the layers. It's a diagnostic aid
+// to find why two packets take different flow paths.
+func DistinguishingFields(layersA, layersB []util.Message) ([]string, error) {
+	matchA, err := BuildExactMatch(layersA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build match of packet A: %w", err)
+	}
+	matchB, err := BuildExactMatch(layersB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build match of packet B: %w", err)
+	}
+	added, removed, changed := matchA.Diff(matchB)
+	var names []string
+	for _, fields := range [][]MatchField{added, removed, changed} {
+		for i := range fields {
+			names = append(names, semanticFieldName(fields[i].Class, fields[i].Field))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func (m *Match) AddField(f MatchField) {
+	checkFieldSanity(&f)
 	m.Fields = append(m.Fields, f)
 	m.Length += f.Len()
 }
 
+// RecomputeLength sets Length to the length of the Match header and the fields without the padding.
+func (m *Match) RecomputeLength() {
+	m.Length = 4
+	for i := range m.Fields {
+		m.Length += m.Fields[i].Len()
+	}
+}
+
+// MatchBuilder builds a Match from the fields added to it.
+type MatchBuilder struct {
+	fields                []MatchField
+	implicitPrerequisites bool
+}
+
+// NewMatchBuilder returns an empty MatchBuilder.
+func NewMatchBuilder() *MatchBuilder {
+	return &MatchBuilder{}
+}
+
+// AddField adds f to the Match to build.
+func (b *MatchBuilder) AddField(f MatchField) *MatchBuilder {
+	b.fields = append(b.fields, f)
+	return b
+}
+
+// WithImplicitPrerequisites makes Build inject the eth_type and ip_proto fields required by the
+// OpenFlow prerequisites of the added fields if they are not added explicitly, e.g. a tcp_dst
+// match gets eth_type=0x0800 and ip_proto=6.
+func (b *MatchBuilder) WithImplicitPrerequisites() *MatchBuilder {
+	b.implicitPrerequisites = true
+	return b
+}
+
+// Build returns the Match with the added fields. The injected prerequisites are placed before the
+// added fields.
+func (b *MatchBuilder) Build() *Match {
+	m := NewMatch()
+	if b.implicitPrerequisites {
+		for _, f := range b.prerequisites() {
+			m.AddField(*f)
+		}
+	}
+	for _, f := range b.fields {
+		m.AddField(f)
+	}
+	return m
+}
+
+// oxmFieldIPProtos maps the OXM basic L4 fields to the ip_proto they require.
+var oxmFieldIPProtos = map[uint8]uint8{
+	OXM_FIELD_TCP_SRC:     protocol.Type_TCP,
+	OXM_FIELD_TCP_DST:     protocol.Type_TCP,
+	OXM_FIELD_TCP_FLAGS:   protocol.Type_TCP,
+	OXM_FIELD_UDP_SRC:     protocol.Type_UDP,
+	OXM_FIELD_UDP_DST:     protocol.Type_UDP,
+	OXM_FIELD_SCTP_SRC:    protocol.Type_SCTP,
+	OXM_FIELD_SCTP_DST:    protocol.Type_SCTP,
+	OXM_FIELD_ICMPV4_TYPE: protocol.Type_ICMP,
+	OXM_FIELD_ICMPV4_CODE: protocol.Type_ICMP,
+	OXM_FIELD_ICMPV6_TYPE: protocol.Type_IPv6ICMP,
+	OXM_FIELD_ICMPV6_CODE: protocol.Type_IPv6ICMP,
+}
+
+// prerequisites returns the eth_type and ip_proto fields required by the added fields but not
+// added explicitly. The IP version defaults to IPv4 if no added field determines it.
+func (b *MatchBuilder) prerequisites() []*MatchField {
+	ethType, ipProto, needIP := impliedPrerequisites(b.fields)
+	if needIP && ethType == 0 {
+		ethType = protocol.IPv4_MSG
+	}
+	var hasEthType, hasIPProto bool
+	for i := range b.fields {
+		f := &b.fields[i]
+		if f.Class != OXM_CLASS_OPENFLOW_BASIC {
+			continue
+		}
+		switch f.Field {
+		case OXM_FIELD_ETH_TYPE:
+			hasEthType = true
+		case OXM_FIELD_IP_PROTO:
+			hasIPProto = true
+		}
+	}
+
+	var fields []*MatchField
+	if !hasEthType && ethType != 0 {
+		fields = append(fields, NewEthTypeField(ethType))
+	}
+	if !hasIPProto && ipProto != nil {
+		fields = append(fields, NewIpProtoField(*ipProto))
+	}
+	return fields
+}
+
+// impliedPrerequisites returns the eth_type and ip_proto determined by the OpenFlow prerequisites
+// of fields, ethType is 0 and ipProto is nil if they are not determined. needIP is set if some field
+// requires an IP eth_type without determining the IP version.
+func impliedPrerequisites(fields []MatchField) (ethType uint16, ipProto *uint8, needIP bool) {
+	for i := range fields {
+		f := &fields[i]
+		if f.Class != OXM_CLASS_OPENFLOW_BASIC {
+			continue
+		}
+		switch f.Field {
+		case OXM_FIELD_IP_PROTO, OXM_FIELD_IP_DSCP, OXM_FIELD_IP_ECN:
+			needIP = true
+		case OXM_FIELD_IPV4_SRC, OXM_FIELD_IPV4_DST:
+			ethType = protocol.IPv4_MSG
+		case OXM_FIELD_IPV6_SRC, OXM_FIELD_IPV6_DST, OXM_FIELD_IPV6_FLABEL, OXM_FIELD_IPV6_EXTHDR:
+			ethType = protocol.IPv6_MSG
+		case OXM_FIELD_ARP_OP, OXM_FIELD_ARP_SPA, OXM_FIELD_ARP_TPA, OXM_FIELD_ARP_SHA, OXM_FIELD_ARP_THA:
+			ethType = protocol.ARP_MSG
+		}
+		if proto, ok := oxmFieldIPProtos[f.Field]; ok {
+			ipProto = &proto
+			needIP = true
+			if proto == protocol.Type_IPv6ICMP {
+				ethType = protocol.IPv6_MSG
+			}
+		}
+	}
+	return ethType, ipProto, needIP
+}
+
+// StripImpliedPrerequisites returns a copy of the Match without the eth_type and ip_proto fields
+// which are strictly implied by the other fields, e.g. eth_type=0x0800 is removed if there is an
+// ipv4_src field, and ip_proto=6 if there is a tcp_dst field. It's the inverse of
+// MatchBuilder.WithImplicitPrerequisites, except that an eth_type which is only implied by the IPv4
+// default, e.g. with a lone tcp_dst, is kept.
+// The returned Match is meant for canonical comparison. It violates the OpenFlow prerequisites, so
+// it's rejected by the switch if it is sent as it is.
+func (m *Match) StripImpliedPrerequisites() *Match {
+	isPrerequisite := func(f *MatchField) bool {
+		return f.Class == OXM_CLASS_OPENFLOW_BASIC && !f.HasMask &&
+			(f.Field == OXM_FIELD_ETH_TYPE || f.Field == OXM_FIELD_IP_PROTO)
+	}
+	var others []MatchField
+	for i := range m.Fields {
+		if !isPrerequisite(&m.Fields[i]) {
+			others = append(others, m.Fields[i])
+		}
+	}
+	ethType, ipProto, _ := impliedPrerequisites(others)
+
+	match := NewMatch()
+	match.Type = m.Type
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if isPrerequisite(f) {
+			switch v := f.Value.(type) {
+			case *EthTypeField:
+				if ethType != 0 && v.EthType == ethType {
+					continue
+				}
+			case *IpProtoField:
+				if ipProto != nil && v.Protocol == *ipProto {
+					continue
+				}
+			}
+		}
+		match.AddField(*f.Clone())
+	}
+	return match
+}
+
+// semanticFieldName returns a name of the field which doesn't depend on the class used to encode it.
+func semanticFieldName(class uint16, field uint8) string {
+	return util.SemanticFieldName(oxxFieldNameMap, class, field)
+}
+
+// Fingerprint returns a representation of the Match which is stable across OpenFlow versions, so
+// that it could be used to correlate the same logical flow in logs. Each field is rendered as
+// "name=value[/mask]" with a name which doesn't depend on the field encoding, and the fields are
+// sorted so that the result doesn't depend on their order either.
+func (m *Match) Fingerprint() string {
+	fields := make([]string, 0, len(m.Fields))
+	for i := range m.Fields {
+		fields = append(fields, fieldFingerprint(&m.Fields[i]))
+	}
+	return util.Fingerprint(fields)
+}
+
+// fieldFingerprint returns the field as "name=value[/mask]" in the form used by Match.Fingerprint.
+func fieldFingerprint(f *MatchField) string {
+	var mask util.Message
+	if f.HasMask {
+		mask = f.Mask
+	}
+	return util.FieldFingerprint(semanticFieldName(f.Class, f.Field), f.Value, mask)
+}
+
+// SortCanonical sorts the fields in the Match by ascending (class, field), which is the canonical
+// order used by OVS. It could be called before MarshalBinary, otherwise the fields are marshaled in
+// the order they were added.
+func (m *Match) SortCanonical() {
+	sort.SliceStable(m.Fields, func(i, j int) bool {
+		if m.Fields[i].Class != m.Fields[j].Class {
+			return m.Fields[i].Class < m.Fields[j].Class
+		}
+		return m.Fields[i].Field < m.Fields[j].Field
+	})
+}
+
+// oxmEquivalentHeaders and nxmEquivalentHeaders map the key of an NXM and an OXM field header
+// respectively to the header of the field with the same semantic and length in the other encoding,
+// e.g. NXM_OF_ETH_SRC and OXM_OF_ETH_SRC.
+var oxmEquivalentHeaders, nxmEquivalentHeaders = func() (map[uint32]*MatchField, map[uint32]*MatchField) {
+	oxmHeaders := make(map[string]*MatchField)
+	for _, header := range oxxFieldHeaderMap {
+		if header.Class == OXM_CLASS_OPENFLOW_BASIC {
+			oxmHeaders[semanticFieldName(header.Class, header.Field)] = header
+		}
+	}
+	toOXM := make(map[uint32]*MatchField)
+	toNXM := make(map[uint32]*MatchField)
+	for _, header := range oxxFieldHeaderMap {
+		if header.Class != OXM_CLASS_NXM_0 && header.Class != OXM_CLASS_NXM_1 {
+			continue
+		}
+		oxmHeader, ok := oxmHeaders[semanticFieldName(header.Class, header.Field)]
+		if !ok || oxmHeader.Length != header.Length {
+			continue
+		}
+		toOXM[util.OXXFieldKey(header.Class, header.Field)] = oxmHeader
+		toNXM[util.OXXFieldKey(oxmHeader.Class, oxmHeader.Field)] = header
+	}
+	return toOXM, toNXM
+}()
+
+// ToOXM returns a copy of the Match in which the NXM fields with an OXM equivalent, e.g.
+// NXM_OF_ETH_SRC and NXM_NX_ARP_SHA, are encoded as the OXM fields. The other fields are copied
+// as they are.
+func (m *Match) ToOXM() *Match {
+	return m.translate(oxmEquivalentHeaders)
+}
+
+// ToNXM returns a copy of the Match in which the OXM fields with an NXM equivalent, e.g.
+// OXM_OF_ETH_SRC and OXM_OF_ARP_SHA, are encoded as the NXM fields. The other fields are copied
+// as they are.
+func (m *Match) ToNXM() *Match {
+	return m.translate(nxmEquivalentHeaders)
+}
+
+func (m *Match) translate(headers map[uint32]*MatchField) *Match {
+	match := NewMatch()
+	match.Type = m.Type
+	for i := range m.Fields {
+		field := m.Fields[i].Clone()
+		if header, ok := headers[util.OXXFieldKey(field.Class, field.Field)]; ok {
+			field.Class = header.Class
+			field.Field = header.Field
+		}
+		match.AddField(*field)
+	}
+	return match
+}
+
+// RewriteIPFields replaces the value of each IP address field in the Match, including ipv4_src,
+// ipv4_dst, ipv6_src, ipv6_dst and their conntrack and NXM counterparts, with the result of fn.
+// fn is called with the field number and the current value; the value is not changed if fn returns
+// nil. Masks are left intact.
+func (m *Match) RewriteIPFields(fn func(field uint8, ip net.IP) net.IP) {
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		switch v := f.Value.(type) {
+		case *Ipv4SrcField:
+			if ip := fn(f.Field, v.Ipv4Src); ip != nil {
+				v.Ipv4Src = ip
+			}
+		case *Ipv4DstField:
+			if ip := fn(f.Field, v.Ipv4Dst); ip != nil {
+				v.Ipv4Dst = ip
+			}
+		case *Ipv6SrcField:
+			if ip := fn(f.Field, v.Ipv6Src); ip != nil {
+				v.Ipv6Src = ip
+			}
+		case *Ipv6DstField:
+			if ip := fn(f.Field, v.Ipv6Dst); ip != nil {
+				v.Ipv6Dst = ip
+			}
+		}
+	}
+}
+
+// IPs returns the IP addresses in the value of each IP address field in the Match, in the order of
+// m.Fields. It includes the IPv4 and IPv6 addresses, the tunnel addresses, the conntrack addresses
+// and the ARP protocol addresses, and the masks are ignored.
+func (m *Match) IPs() []net.IP {
+	var ips []net.IP
+	for i := range m.Fields {
+		if ip := matchFieldIP(m.Fields[i].Value); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// ConntrackFields returns the names of the conntrack fields in the Match, e.g. "ct_state" and
+// "ct_mark", in the order of m.Fields. A field is only listed once even if it appears multiple times.
+func (m *Match) ConntrackFields() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		name, found := FindFieldNameByHeader(f.Class, f.Field)
+		if !found || !strings.HasPrefix(name, "NXM_NX_CT_") {
+			continue
+		}
+		name = semanticFieldName(f.Class, f.Field)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// matchFieldIP returns the IP address in msg if it is the value or mask of an IP address field,
+// otherwise nil.
+func matchFieldIP(msg util.Message) net.IP {
+	switch v := msg.(type) {
+	case *Ipv4SrcField:
+		return v.Ipv4Src
+	case *Ipv4DstField:
+		return v.Ipv4Dst
+	case *Ipv6SrcField:
+		return v.Ipv6Src
+	case *Ipv6DstField:
+		return v.Ipv6Dst
+	case *TunnelIpv4SrcField:
+		return v.TunnelIpv4Src
+	case *TunnelIpv4DstField:
+		return v.TunnelIpv4Dst
+	case *ArpXPaField:
+		return v.ArpPa
+	}
+	return nil
+}
+
+// matchRule checks the relationship between the fields in a Match, and returns an error if the
+// Match violates the rule.
+type matchRule func(m *Match) error
+
+// matchRules are the rules checked by Match.Validate.
+var matchRules = []matchRule{
+	checkInPhyPort,
+	checkPacketType,
+}
+
+// Validate checks the relationship between the fields in the Match which is enforced by OpenFlow,
+// e.g. in_phy_port is only allowed alongside in_port. The first violation is returned.
+func (m *Match) Validate() error {
+	for _, rule := range matchRules {
+		if err := rule(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMatchField returns the first field in the Match with the given class and field, and whether
+// it is found. The class is compared as it is, so an experimenter field, e.g. the ONF tcp_flags in
+// OXM_CLASS_EXPERIMENTER, is only found with OXM_CLASS_EXPERIMENTER but not the basic class.
+func (m *Match) GetMatchField(class uint16, field uint8) (*MatchField, bool) {
+	for i := range m.Fields {
+		if m.Fields[i].Class == class && m.Fields[i].Field == field {
+			return &m.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// HasField returns whether the Match has a field with the given class and field.
+func (m *Match) HasField(class uint16, field uint8) bool {
+	_, found := m.GetMatchField(class, field)
+	return found
+}
+
+// checkInPhyPort checks in_phy_port only appears alongside in_port.
+func checkInPhyPort(m *Match) error {
+	if m.HasField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IN_PHY_PORT) && !m.HasField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IN_PORT) {
+		return errors.New("in_phy_port is only allowed alongside in_port")
+	}
+	return nil
+}
+
+// ethernetHeaderFields are the names of the fields in the Ethernet header, which could only be
+// matched on Ethernet packets.
+var ethernetHeaderFields = map[string]bool{
+	"eth_dst":  true,
+	"eth_src":  true,
+	"vlan_vid": true,
+	"vlan_pcp": true,
+	"vlan_tci": true,
+}
+
+// checkPacketType checks the fields in the Ethernet header, e.g. eth_dst, only appear if
+// packet_type is absent or Ethernet.
+func checkPacketType(m *Match) error {
+	f, found := m.GetMatchField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_PACKET_TYPE)
+	if !found {
+		return nil
+	}
+	packetType, ok := f.Value.(*PacketTypeField)
+	if !ok || (packetType.Namespace == OFPHTN_ONF && packetType.NsType == OFPHTO_ETHERNET) {
+		return nil
+	}
+	for i := range m.Fields {
+		if name := semanticFieldName(m.Fields[i].Class, m.Fields[i].Field); ethernetHeaderFields[name] {
+			return fmt.Errorf("%s is not allowed with non-Ethernet packet_type (%d,0x%x)", name, packetType.Namespace, packetType.NsType)
+		}
+	}
+	return nil
+}
+
+// The OpenFlow versions in which OXM fields are introduced.
+const (
+	ofVersion12 = 3
+	ofVersion13 = 4
+	ofVersion14 = 5
+)
+
+// oxmFieldVersions is map to find the OpenFlow version in which an OXM basic field is introduced.
+// The fields not in the map are introduced along with OXM in OpenFlow 1.2.
+var oxmFieldVersions = map[uint8]uint8{
+	OXM_FIELD_MPLS_BOS:      ofVersion13,
+	OXM_FIELD_PBB_ISID:      ofVersion13,
+	OXM_FIELD_TUNNEL_ID:     ofVersion13,
+	OXM_FIELD_IPV6_EXTHDR:   ofVersion13,
+	OXM_FIELD_PBB_UCA:       ofVersion14,
+	OXM_FIELD_TCP_FLAGS:     VERSION,
+	OXM_FIELD_ACTSET_OUTPUT: VERSION,
+	OXM_FIELD_PACKET_TYPE:   VERSION,
+}
+
+// SupportedIn returns whether all the fields in the Match could be represented in the OpenFlow
+// version, and the fields introduced after the version if not. Only the fields in the OpenFlow
+// basic class are checked, the Nicira extension fields are regarded as supported in all versions.
+func (m *Match) SupportedIn(version uint8) (bool, []MatchField) {
+	var unsupported []MatchField
+	for _, f := range m.Fields {
+		if f.Class != OXM_CLASS_OPENFLOW_BASIC {
+			continue
+		}
+		fieldVersion, ok := oxmFieldVersions[f.Field]
+		if !ok {
+			fieldVersion = ofVersion12
+		}
+		if fieldVersion > version {
+			unsupported = append(unsupported, f)
+		}
+	}
+	return len(unsupported) == 0, unsupported
+}
+
+// MinVersion returns the lowest OpenFlow version in which all the fields in the Match could be
+// represented, e.g. 1.5 if it has tcp_flags. It's 1.2, in which OXM is introduced, if no field
+// requires a later version. As in SupportedIn, only the fields in the OpenFlow basic class are
+// checked.
+func (m *Match) MinVersion() uint8 {
+	version := uint8(ofVersion12)
+	for i := range m.Fields {
+		if m.Fields[i].Class != OXM_CLASS_OPENFLOW_BASIC {
+			continue
+		}
+		if fieldVersion, ok := oxmFieldVersions[m.Fields[i].Field]; ok && fieldVersion > version {
+			version = fieldVersion
+		}
+	}
+	return version
+}
+
+// SpecificityScore returns the number of bits matched by the Match, as a rough measure of how
+// specific it is: the set bits of the mask are counted for a masked field, and all the bits of the
+// value for an exact field, e.g. 8 for ipv4_dst=10.0.0.0/8 and 32 for ipv4_dst=10.0.0.1. The width of
+// a field is its encoded size, which may be larger than the meaningful bits, e.g. 16 for vlan_vid.
+// A field which fails to marshal adds nothing to the score.
+func (m *Match) SpecificityScore() int {
+	score := 0
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if f.HasMask && f.Mask != nil {
+			mask, err := f.Mask.MarshalBinary()
+			if err != nil {
+				continue
+			}
+			for _, b := range mask {
+				score += bits.OnesCount8(b)
+			}
+		} else if f.Value != nil {
+			score += 8 * int(f.Value.Len())
+		}
+	}
+	return score
+}
+
+// ErrNegatedMatch is returned when a constraint requires negation, which can't be expressed by an
+// OpenFlow match: a mask only selects the bits to compare, so a masked field still matches the
+// packets with the given bits rather than excluding them. An exclusion should be implemented with
+// a higher priority flow matching the excluded values instead.
+var ErrNegatedMatch = errors.New("OpenFlow match can't express negation")
+
+// MatchConstraint is a constraint on a field of the packets. It is satisfied by the packets whose
+// field matches Field, or doesn't match Field if Negated is true.
+type MatchConstraint struct {
+	Field   *MatchField
+	Negated bool
+}
+
+// CanExpress checks if the constraint could be expressed by adding its field to the Match. An
+// error is returned if not, e.g. ErrNegatedMatch for a negated constraint, or if the Match already
+// has the field with a different value or mask.
+func (m *Match) CanExpress(c MatchConstraint) error {
+	if c.Negated {
+		return fmt.Errorf("%w: %s", ErrNegatedMatch, c.Field.String())
+	}
+	if f, found := m.GetMatchField(c.Field.Class, c.Field.Field); found && fieldFingerprint(f) != fieldFingerprint(c.Field) {
+		return fmt.Errorf("field is already matched as %s, which conflicts with %s", f.String(), c.Field.String())
+	}
+	return nil
+}
+
+// Diff compares the Match with other, which is regarded as the newer version, by the identity
+// (class, field) of the fields. added are the fields only in other, removed are the fields only in
+// m, and changed are the fields in other whose value or mask is different from the field with the
+// same identity in m.
+func (m *Match) Diff(other *Match) (added, removed, changed []MatchField) {
+	oldFields := make(map[uint32]*MatchField, len(m.Fields))
+	for i := range m.Fields {
+		oldFields[util.OXXFieldKey(m.Fields[i].Class, m.Fields[i].Field)] = &m.Fields[i]
+	}
+	newFields := make(map[uint32]bool, len(other.Fields))
+	for i := range other.Fields {
+		f := &other.Fields[i]
+		key := util.OXXFieldKey(f.Class, f.Field)
+		newFields[key] = true
+		oldField, ok := oldFields[key]
+		if !ok {
+			added = append(added, *f)
+			continue
+		}
+		if oldField.HasMask != f.HasMask || util.MessageHex(oldField.Value) != util.MessageHex(f.Value) ||
+			(f.HasMask && util.MessageHex(oldField.Mask) != util.MessageHex(f.Mask)) {
+			changed = append(changed, *f)
+		}
+	}
+	for i := range m.Fields {
+		if !newFields[util.OXXFieldKey(m.Fields[i].Class, m.Fields[i].Field)] {
+			removed = append(removed, m.Fields[i])
+		}
+	}
+	return
+}
+
+// Equal returns whether the Match has the same fields as other regardless of their order. The
+// fields are compared like in Fingerprint, so the same field in different classes is equal, and a
+// field with an all-ones mask is equal to the same field without mask. A nil Match is only equal to
+// another nil Match.
+func (m *Match) Equal(other *Match) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	if len(m.Fields) != len(other.Fields) {
+		return false
+	}
+	fields := make(map[string]int, len(m.Fields))
+	for i := range m.Fields {
+		fields[equalityKey(&m.Fields[i])]++
+	}
+	for i := range other.Fields {
+		key := equalityKey(&other.Fields[i])
+		if fields[key] == 0 {
+			return false
+		}
+		fields[key]--
+	}
+	return true
+}
+
+// Overlaps returns whether a packet could match both m and other, i.e. every field in both of them
+// matches a common value under the masks of both. A field in only one of them doesn't prevent the
+// overlap. Fields with the same semantic in different classes, e.g. NXM_OF_IP_SRC and
+// OXM_OF_IPV4_SRC, are compared with each other, and masked IP addresses are compared as subnets,
+// e.g. 10.0.0.0/8 overlaps 10.1.0.0/16.
+func (m *Match) Overlaps(other *Match) bool {
+	fields := make(map[string][]*MatchField, len(m.Fields))
+	for i := range m.Fields {
+		name := semanticFieldName(m.Fields[i].Class, m.Fields[i].Field)
+		fields[name] = append(fields[name], &m.Fields[i])
+	}
+	for i := range other.Fields {
+		f2 := &other.Fields[i]
+		for _, f1 := range fields[semanticFieldName(f2.Class, f2.Field)] {
+			if !fieldsOverlap(f1, f2) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fieldsOverlap returns whether f1 and f2, which are the same field, match a common value.
+func fieldsOverlap(f1, f2 *MatchField) bool {
+	var mask1, mask2 util.Message
+	if f1.HasMask {
+		mask1 = f1.Mask
+	}
+	if f2.HasMask {
+		mask2 = f2.Mask
+	}
+	if ip1, ip2 := matchFieldIP(f1.Value), matchFieldIP(f2.Value); ip1 != nil && ip2 != nil {
+		return ipRangesOverlap(ip1, matchFieldIP(mask1), ip2, matchFieldIP(mask2))
+	}
+	v1, v2 := messageBytes(f1.Value), messageBytes(f2.Value)
+	if len(v1) != len(v2) {
+		return false
+	}
+	return maskedBytesOverlap(v1, messageBytes(mask1), v2, messageBytes(mask2))
+}
+
+// ipRangesOverlap returns whether the IP ranges ip1/mask1 and ip2/mask2 have an address in common,
+// which is the case if one of them is in the other as the ranges are subnets. A nil mask matches
+// the whole address. An IPv4 address stored in 16 bytes is the same as the one in 4 bytes, and it
+// never overlaps an IPv6 range.
+func ipRangesOverlap(ip1, mask1, ip2, mask2 net.IP) bool {
+	if (ip1.To4() != nil) != (ip2.To4() != nil) {
+		return false
+	}
+	normalize := func(ip net.IP) net.IP {
+		if ip == nil {
+			return nil
+		}
+		if ip4 := ip.To4(); ip4 != nil && ip1.To4() != nil {
+			return ip4
+		}
+		return ip.To16()
+	}
+	return maskedBytesOverlap(normalize(ip1), normalize(mask1), normalize(ip2), normalize(mask2))
+}
+
+// maskedBytesOverlap returns whether the values v1 and v2 of the same length are equal in the bits
+// set in both mask1 and mask2. A nil mask has all bits set.
+func maskedBytesOverlap(v1, mask1, v2, mask2 []byte) bool {
+	if len(v1) != len(v2) {
+		return false
+	}
+	for i := range v1 {
+		m := byte(0xff)
+		if mask1 != nil && i < len(mask1) {
+			m &= mask1[i]
+		}
+		if mask2 != nil && i < len(mask2) {
+			m &= mask2[i]
+		}
+		if (v1[i]^v2[i])&m != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func messageBytes(msg util.Message) []byte {
+	if msg == nil {
+		return nil
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// equalityKey returns the key of the field used by Match.Equal, which is its fingerprint without
+// an all-ones mask.
+func equalityKey(f *MatchField) string {
+	if f.HasMask {
+		if mask := util.MessageHex(f.Mask); mask != "" && strings.Trim(mask, "f") == "" {
+			unmasked := *f
+			unmasked.HasMask = false
+			return fieldFingerprint(&unmasked)
+		}
+	}
+	return fieldFingerprint(f)
+}
+
+// String returns the Match as a comma-separated list of "name=value[/mask]" fields, in the
+// order of m.Fields. IP and MAC addresses are rendered in their canonical text form regardless of
+// how they are stored, so the result is deterministic and could be used in golden tests.
+func (m *Match) String() string {
+	fields := make([]string, 0, len(m.Fields))
+	for i := range m.Fields {
+		fields = append(fields, m.Fields[i].String())
+	}
+	return strings.Join(fields, ",")
+}
+
+// String returns the MatchField as "name=value[/mask]".
+func (m *MatchField) String() string {
+	s := semanticFieldName(m.Class, m.Field) + "=" + matchFieldValueString(m.Value)
+	if m.HasMask {
+		s += "/" + matchFieldValueString(m.Mask)
+	}
+	return s
+}
+
+// MarshalText implements encoding.TextMarshaler. The MatchField is encoded as "name=value[/mask]"
+// like String, except that a contiguous mask of an IP address is encoded as the prefix length, e.g.
+// "ipv4_src=10.0.0.0/24". If the short name would be decoded as a field in another class, e.g.
+// "ipv4_src" for NXM_OF_IP_SRC, the full field name is used instead, so that UnmarshalText returns
+// a field in the same class.
+func (m *MatchField) MarshalText() ([]byte, error) {
+	if m.Value == nil {
+		return nil, errors.New("MatchField has no value")
+	}
+	name := semanticFieldName(m.Class, m.Field)
+	if fullName, found := FindFieldNameByHeader(m.Class, m.Field); found {
+		if header := findTextFieldHeader(name, m.HasMask); header == nil || header.Class != m.Class || header.Field != m.Field {
+			name = fullName
+		}
+	}
+	s := name + "=" + matchFieldValueString(m.Value)
+	if m.HasMask {
+		if m.Mask == nil {
+			return nil, errors.New("masked MatchField has no mask")
+		}
+		mask := matchFieldValueString(m.Mask)
+		if isIPMatchFieldValue(m.Value) {
+			maskBytes, _ := m.Mask.MarshalBinary()
+			if ones, bits := net.IPMask(maskBytes).Size(); bits != 0 {
+				mask = strconv.Itoa(ones)
+			}
+		}
+		s += "/" + mask
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it decodes a MatchField encoded by
+// MarshalText. The name is either a full field name, e.g. "NXM_OF_IP_SRC", or a short name which
+// is looked up as an OXM_OF, NXM_NX or NXM_OF field in order. The mask of an IP address may be a
+// prefix length or an address.
+func (m *MatchField) UnmarshalText(text []byte) error {
+	name, valueMask, found := strings.Cut(string(text), "=")
+	if !found {
+		return fmt.Errorf("invalid MatchField %q, expected name=value[/mask]", text)
+	}
+	valueStr, maskStr, hasMask := strings.Cut(valueMask, "/")
+	header := findTextFieldHeader(name, hasMask)
+	if header == nil {
+		return fmt.Errorf("unknown MatchField name %q", name)
+	}
+	value, err := parseMatchFieldValue(header, valueStr, false)
+	if err != nil {
+		return fmt.Errorf("invalid value of %s: %w", name, err)
+	}
+	var mask util.Message
+	if hasMask {
+		if mask, err = parseMatchFieldValue(header, maskStr, isIPMatchFieldValue(value)); err != nil {
+			return fmt.Errorf("invalid mask of %s: %w", name, err)
+		}
+	}
+	*m = *header
+	m.Value = value
+	m.Mask = mask
+	return nil
+}
+
+// findTextFieldHeader returns the header of the field named name in the text form of a MatchField,
+// or nil if the name is unknown.
+func findTextFieldHeader(name string, hasMask bool) *MatchField {
+	if header, err := FindFieldHeaderByName(name, hasMask); err == nil {
+		return header
+	}
+	for _, prefix := range []string{"OXM_OF_", "NXM_NX_", "NXM_OF_"} {
+		if header, err := FindFieldHeaderByName(prefix+name, hasMask); err == nil {
+			return header
+		}
+	}
+	return nil
+}
+
+// isIPMatchFieldValue returns whether msg is an IPv4 or IPv6 address value.
+func isIPMatchFieldValue(msg util.Message) bool {
+	switch msg.(type) {
+	case *Ipv4SrcField, *Ipv4DstField, *Ipv6SrcField, *Ipv6DstField, *TunnelIpv4SrcField, *TunnelIpv4DstField, *ArpXPaField:
+		return true
+	}
+	return false
+}
+
+// parseMatchFieldValue parses s into the value of the field described by header. s is an IP
+// address, a MAC address, a symbolic name accepted by the field, or an integer which is encoded in
+// big-endian into the value bytes. If allowPrefixLen is true, s may be an IP prefix length.
+func parseMatchFieldValue(header *MatchField, s string, allowPrefixLen bool) (util.Message, error) {
+	// Decode an all-zero value to find the type and length of the value.
+	value, err := DecodeMatchField(header.Class, header.Field, header.Length, header.HasMask, make([]byte, header.Length))
+	if err != nil {
+		return nil, err
+	}
+	n := int(value.Len())
+	if v, ok := matchFieldValueByName(value, s); ok {
+		s = strconv.FormatUint(uint64(v), 10)
+	}
+	var data []byte
+	if ip := net.ParseIP(s); ip != nil && (n == net.IPv4len || n == net.IPv6len) {
+		if n == net.IPv4len {
+			ip = ip.To4()
+		}
+		data = ip
+	} else if mac, err := net.ParseMAC(s); err == nil && n == len(mac) {
+		data = mac
+	} else if ones, err := strconv.Atoi(s); err == nil && allowPrefixLen {
+		if ones < 0 || ones > n*8 {
+			return nil, fmt.Errorf("invalid prefix length %d", ones)
+		}
+		data = net.CIDRMask(ones, n*8)
+	} else {
+		i, ok := new(big.Int).SetString(s, 0)
+		if !ok || i.Sign() < 0 || i.BitLen() > n*8 {
+			return nil, fmt.Errorf("invalid value %q for %d bytes", s, n)
+		}
+		data = i.FillBytes(make([]byte, n))
+	}
+	if len(data) != n {
+		return nil, fmt.Errorf("invalid value %q for %d bytes", s, n)
+	}
+	if err := value.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// matchFieldValueByName returns the value of the symbolic name s for the fields which are printed
+// with names, i.e. ip_proto and actset_output.
+func matchFieldValueByName(value util.Message, s string) (uint32, bool) {
+	switch value.(type) {
+	case *IpProtoField:
+		for proto := 0; proto <= 0xff; proto++ {
+			if IpProtoString(uint8(proto)) == s {
+				return uint32(proto), true
+			}
+		}
+	case *ActsetOutputField:
+		for port, name := range reservedPortNames {
+			if name == s {
+				return port, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func matchFieldValueString(msg util.Message) string {
+	switch v := msg.(type) {
+	case nil:
+		return ""
+	case fmt.Stringer:
+		return v.String()
+	case *InPortField:
+		return strconv.FormatUint(uint64(v.InPort), 10)
+	case *EthTypeField:
+		return fmt.Sprintf("0x%04x", v.EthType)
+	case *PortField:
+		return strconv.FormatUint(uint64(v.Port), 10)
+	case *Ipv4SrcField:
+		return v.Ipv4Src.String()
+	case *Ipv4DstField:
+		return v.Ipv4Dst.String()
+	case *Ipv6SrcField:
+		return v.Ipv6Src.String()
+	case *Ipv6DstField:
+		return v.Ipv6Dst.String()
+	case *TunnelIpv4SrcField:
+		return v.TunnelIpv4Src.String()
+	case *TunnelIpv4DstField:
+		return v.TunnelIpv4Dst.String()
+	case *ArpXPaField:
+		return v.ArpPa.String()
+	case *EthSrcField:
+		return v.EthSrc.String()
+	case *EthDstField:
+		return v.EthDst.String()
+	case *ArpXHaField:
+		return v.ArpHa.String()
+	case *Uint8Message:
+		return fmt.Sprintf("0x%x", v.Data)
+	case *Uint16Message:
+		return fmt.Sprintf("0x%x", v.Data)
+	case *Uint32Message:
+		return fmt.Sprintf("0x%x", v.Data)
+	case *Uint64Message:
+		return fmt.Sprintf("0x%x", v.Data)
+	}
+	return "0x" + util.MessageHex(msg)
+}
+
+// experimenterNames is map to find the name of the experimenter by the experimenter ID.
+var experimenterNames = map[uint32]string{
+	ONF_EXPERIMENTER_ID: "ONF",
+	NxExperimenterID:    "Nicira",
+}
+
+// IsExperimenter returns whether the MatchField is in the experimenter class, which carries an
+// ExperimenterID.
+func (m *MatchField) IsExperimenter() bool {
+	return m.Class == OXM_CLASS_EXPERIMENTER
+}
+
+// ExperimenterName returns the name of the experimenter of the MatchField, e.g. "ONF", or the
+// ExperimenterID in hex if it is unknown. An empty string is returned if the MatchField is not in
+// the experimenter class.
+func (m *MatchField) ExperimenterName() string {
+	if !m.IsExperimenter() {
+		return ""
+	}
+	if name, ok := experimenterNames[m.ExperimenterID]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", m.ExperimenterID)
+}
+
+// ToOxmId returns the header-only OxmId of the MatchField, e.g. to advertise the supported fields in
+// TableFeatures.
+func (m *MatchField) ToOxmId() *OxmId {
+	return NewOxmId(m.Class, m.Field, m.HasMask, m.Length, m.ExperimenterID)
+}
+
+// Clone returns a deep copy of the Match, the fields of which don't share any memory with the
+// original ones, so that the copy could be modified without affecting the Match.
+func (m *Match) Clone() *Match {
+	match := &Match{
+		Type:   m.Type,
+		Length: m.Length,
+		Fields: make([]MatchField, 0, len(m.Fields)),
+	}
+	for i := range m.Fields {
+		match.Fields = append(match.Fields, *m.Fields[i].Clone())
+	}
+	return match
+}
+
+// Clone returns a deep copy of the MatchField, the Value and Mask of which don't share any memory
+// with the original ones, so that the copy could be modified or moved to another Match freely.
+func (m *MatchField) Clone() *MatchField {
+	field := *m
+	field.Value = cloneMessage(m.Value)
+	field.Mask = cloneMessage(m.Mask)
+	return &field
+}
+
+// cloneMessage returns a copy of msg, which is a value or mask of a MatchField. The backing arrays
+// of the addresses and byte arrays are copied, so that the copy doesn't share any memory with msg.
+// The struct pointed to by a value of another type is copied as it is, and a value which is not a
+// pointer is returned as it is, since it's copied with the interface.
+func cloneMessage(msg util.Message) util.Message {
+	switch v := msg.(type) {
+	case nil:
+		return nil
+	case *EthDstField:
+		return &EthDstField{EthDst: cloneBytes(v.EthDst)}
+	case *EthSrcField:
+		return &EthSrcField{EthSrc: cloneBytes(v.EthSrc)}
+	case *Ipv4SrcField:
+		return &Ipv4SrcField{Ipv4Src: cloneBytes(v.Ipv4Src)}
+	case *Ipv4DstField:
+		return &Ipv4DstField{Ipv4Dst: cloneBytes(v.Ipv4Dst)}
+	case *Ipv6SrcField:
+		return &Ipv6SrcField{Ipv6Src: cloneBytes(v.Ipv6Src)}
+	case *Ipv6DstField:
+		return &Ipv6DstField{Ipv6Dst: cloneBytes(v.Ipv6Dst)}
+	case *TunnelIpv4SrcField:
+		return &TunnelIpv4SrcField{TunnelIpv4Src: cloneBytes(v.TunnelIpv4Src)}
+	case *TunnelIpv4DstField:
+		return &TunnelIpv4DstField{TunnelIpv4Dst: cloneBytes(v.TunnelIpv4Dst)}
+	case *ArpXHaField:
+		return &ArpXHaField{ArpHa: cloneBytes(v.ArpHa)}
+	case *ArpXPaField:
+		return &ArpXPaField{ArpPa: cloneBytes(v.ArpPa)}
+	case *ByteArrayField:
+		return &ByteArrayField{Data: cloneBytes(v.Data), Length: v.Length}
+	}
+	value := reflect.ValueOf(msg)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return msg
+	}
+	clone := reflect.New(value.Elem().Type())
+	clone.Elem().Set(value.Elem())
+	return clone.Interface().(util.Message)
+}
+
+// cloneBytes returns a copy of b with its own backing array, or nil if b is nil.
+func cloneBytes[S ~[]byte](b S) S {
+	if b == nil {
+		return nil
+	}
+	return append(S{}, b...)
+}
+
 func (m *MatchField) Len() (n uint16) {
 	n = 4
 	if m.ExperimenterID != 0 {
 		n += 4
 	}
 	n += m.Value.Len()
-	if m.HasMask {
+	if m.HasMask && m.Mask != nil {
 		n += m.Mask.Len()
 	}
 
@@ -120,43 +1351,119 @@ func (m *MatchField) Len() (n uint16) {
 }
 
 func (m *MatchField) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, int(m.Len()))
+	buf := util.GetBuffer(int(m.Len()))
+	defer util.PutBuffer(buf)
+	if err := m.writeTo(buf); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
 
-	n := 0
-	binary.BigEndian.PutUint16(data[n:], m.Class)
-	n += 2
+// writeTo writes the MatchField to buf in exactly m.Len() bytes. An error is returned if the Length
+// in the header doesn't equal the length of the value, mask and experimenter ID, so that a
+// misconstructed field fails here rather than being rejected by the switch.
+func (m *MatchField) writeTo(buf *bytes.Buffer) error {
+	if m.HasMask && m.Mask == nil {
+		return fmt.Errorf("MatchField (class: %d, field: %d) has HasMask set but no Mask", m.Class, m.Field)
+	}
+	if expected := m.Len() - 4; uint16(m.Length) != expected {
+		return fmt.Errorf("MatchField (class: %d, field: %d) has Length %d, but its payload is %d bytes", m.Class, m.Field, m.Length, expected)
+	}
+	start := buf.Len()
 
+	var header [8]byte
+	binary.BigEndian.PutUint16(header[0:], m.Class)
 	var fld uint8
 	if m.HasMask {
 		fld = (m.Field << 1) | 0x1
 	} else {
 		fld = m.Field << 1
 	}
-	data[n] = fld
-	n += 1
-
-	data[n] = m.Length
-	n += 1
+	header[2] = fld
+	header[3] = m.Length
+	if m.ExperimenterID != 0 {
+		binary.BigEndian.PutUint32(header[4:], m.ExperimenterID)
+		buf.Write(header[:8])
+	} else {
+		buf.Write(header[:4])
+	}
 
 	b, err := m.Value.MarshalBinary()
 	if err != nil {
-		return
+		return err
 	}
-	copy(data[n:], b)
-	n += len(b)
+	buf.Write(b)
 
 	if m.HasMask {
 		b, err = m.Mask.MarshalBinary()
 		if err != nil {
-			return
+			return err
 		}
-		copy(data[n:], b)
-		n += len(b)
+		buf.Write(b)
 	}
-	return
+	fitBytes(buf, start, int(m.Len()))
+	return nil
+}
+
+// fitBytes pads or truncates the bytes written to buf from start to length bytes, as the Len of a
+// Match or MatchField is what's declared in its header.
+func fitBytes(buf *bytes.Buffer, start, length int) {
+	if n := buf.Len() - start; n < length {
+		buf.Write(make([]byte, length-n))
+	} else if n > length {
+		buf.Truncate(start + length)
+	}
+}
+
+// ExperimenterOXMDecoder decodes the value or mask of a field in the experimenter class from data.
+// length is the oxm_length in the header, which includes the 4-byte experimenter ID.
+type ExperimenterOXMDecoder func(field uint8, length uint8, hasMask bool, data []byte) (util.Message, error)
+
+var (
+	experimenterOXMLock sync.RWMutex
+	// experimenterOXMDecoders is map to find the decoder of the experimenter fields by experimenter ID.
+	experimenterOXMDecoders = map[uint32]ExperimenterOXMDecoder{
+		ONF_EXPERIMENTER_ID: func(field uint8, length uint8, hasMask bool, data []byte) (util.Message, error) {
+			return DecodeMatchField(OXM_CLASS_EXPERIMENTER, field, length, hasMask, data)
+		},
+	}
+)
+
+// RegisterExperimenterOXM registers the decoder of the fields of the experimenter with id in the
+// experimenter class, replacing the registered one if any, including the built-in decoder of ONF.
+// The fields of an experimenter without a registered decoder are decoded as ByteArrayField.
+func RegisterExperimenterOXM(id uint32, decoder ExperimenterOXMDecoder) {
+	experimenterOXMLock.Lock()
+	defer experimenterOXMLock.Unlock()
+	experimenterOXMDecoders[id] = decoder
+}
+
+// decodeExperimenterOXM decodes the value or mask of a field of the experimenter with id with the
+// registered decoder, or as a ByteArrayField of the declared width if there is no decoder.
+func decodeExperimenterOXM(id uint32, field uint8, length uint8, hasMask bool, data []byte) (util.Message, error) {
+	experimenterOXMLock.RLock()
+	decoder, ok := experimenterOXMDecoders[id]
+	experimenterOXMLock.RUnlock()
+	if ok {
+		return decoder(field, length, hasMask, data)
+	}
+	if length < 4 {
+		return nil, fmt.Errorf("invalid length %d of experimenter field %d, which is shorter than the experimenter ID", length, field)
+	}
+	msg := &ByteArrayField{Length: length - 4}
+	if hasMask {
+		msg.Length /= 2
+	}
+	if err := msg.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
 func (m *MatchField) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("%w: %d bytes left for the MatchField header", io.ErrShortBuffer, len(data))
+	}
 	var n uint16
 	var err error
 	m.Class = binary.BigEndian.Uint16(data[n:])
@@ -175,23 +1482,42 @@ func (m *MatchField) UnmarshalBinary(data []byte) error {
 	n += 1
 
 	if m.Class == OXM_CLASS_EXPERIMENTER {
+		if len(data) < int(n)+4 {
+			return fmt.Errorf("%w: %d bytes left for the experimenter ID", io.ErrShortBuffer, len(data)-int(n))
+		}
 		experimenterID := binary.BigEndian.Uint32(data[n:])
-		if experimenterID == ONF_EXPERIMENTER_ID {
-			n += 4
-			m.ExperimenterID = experimenterID
-		} else {
+		if experimenterID == 0 {
+			// The experimenter ID is omitted from the Len of a MatchField if it's 0.
 			return fmt.Errorf("Unsupported experimenter id: %d in class: %d ", experimenterID, m.Class)
 		}
+		n += 4
+		m.ExperimenterID = experimenterID
 	}
 
-	if m.Value, err = DecodeMatchField(m.Class, m.Field, m.Length, m.HasMask, data[n:]); err != nil {
+	decode := DecodeMatchField
+	if m.Class == OXM_CLASS_EXPERIMENTER {
+		decode = func(class uint16, field uint8, length uint8, hasMask bool, data []byte) (util.Message, error) {
+			return decodeExperimenterOXM(m.ExperimenterID, field, length, hasMask, data)
+		}
+	}
+	if m.Value, err = decode(m.Class, m.Field, m.Length, m.HasMask, data[n:]); err != nil {
 		klog.ErrorS(err, "Failed to decode MatchField", "data", data[n:])
 		return err
 	}
+	if err = m.checkLength(); err != nil {
+		klog.ErrorS(err, "Failed to decode MatchField", "data", data)
+		return err
+	}
 	n += m.Value.Len()
 
 	if m.HasMask {
-		if m.Mask, err = DecodeMatchField(m.Class, m.Field, m.Length, m.HasMask, data[n:]); err != nil {
+		// The mask has the same length as the value.
+		if len(data) < int(n+m.Value.Len()) {
+			err = fmt.Errorf("%w: %d bytes left for the mask of %d bytes", io.ErrShortBuffer, len(data)-int(n), m.Value.Len())
+			klog.ErrorS(err, "Failed to decode MatchField mask", "data", data[n:])
+			return err
+		}
+		if m.Mask, err = decode(m.Class, m.Field, m.Length, m.HasMask, data[n:]); err != nil {
 			klog.ErrorS(err, "Failed to decode MatchField mask", "data", data[n:])
 			return err
 		}
@@ -200,6 +1526,29 @@ func (m *MatchField) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// checkLength returns an error if the Length of the MatchField decoded from the wire doesn't equal
+// the length of its value, mask and experimenter ID, so that a masked field is split into a value
+// and a mask of the same width, e.g. 8 bytes of ct_mark are 4 bytes of value and 4 bytes of mask.
+// It is not checked for the variable-width values, i.e. tun_metadata, xxreg and packet registers,
+// the width of which is derived from the declared length.
+func (m *MatchField) checkLength() error {
+	switch m.Value.(type) {
+	case *ByteArrayField:
+		return nil
+	}
+	expected := m.Value.Len()
+	if m.HasMask {
+		expected *= 2
+	}
+	if m.ExperimenterID != 0 {
+		expected += 4
+	}
+	if uint16(m.Length) != expected {
+		return fmt.Errorf("invalid length %d of MatchField with class %d and field %d, expected %d", m.Length, m.Class, m.Field, expected)
+	}
+	return nil
+}
+
 func (m *MatchField) MarshalHeader() uint32 {
 	var maskData uint32
 	if m.HasMask {
@@ -323,95 +1672,191 @@ func (o *OxmId) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// OxmIdList is a list of OXM IDs, e.g. in the properties of OFPMP_TABLE_FEATURES, in which each
+// OXM ID has a 4-byte header, or an 8-byte one with the experimenter ID in the experimenter class.
+type OxmIdList struct {
+	Ids []OxmId
+}
+
+func (l *OxmIdList) Len() (n uint16) {
+	for i := range l.Ids {
+		n += l.Ids[i].Len()
+	}
+	return
+}
+
+func (l *OxmIdList) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, l.Len())
+	for i := range l.Ids {
+		b, err := l.Ids[i].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (l *OxmIdList) UnmarshalBinary(data []byte) error {
+	l.Ids = nil
+	for n := 0; n < len(data); {
+		if len(data)-n < 4 {
+			return fmt.Errorf("%w: %d bytes left for an OXM ID", io.ErrShortBuffer, len(data)-n)
+		}
+		if binary.BigEndian.Uint16(data[n:]) == OXM_CLASS_EXPERIMENTER && len(data)-n < 8 {
+			return fmt.Errorf("%w: %d bytes left for an experimenter OXM ID", io.ErrShortBuffer, len(data)-n)
+		}
+		var id OxmId
+		if err := id.UnmarshalBinary(data[n:]); err != nil {
+			return err
+		}
+		l.Ids = append(l.Ids, id)
+		n += int(id.Len())
+	}
+	return nil
+}
+
+// fieldValuePools are the pools of the MatchField values decoded by DecodeMatchField, keyed by the
+// concrete type of the value, the values are returned to the pools by Match.Release.
+var fieldValuePools sync.Map
+
+func fieldValuePool(t reflect.Type) *sync.Pool {
+	if pool, ok := fieldValuePools.Load(t); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := fieldValuePools.LoadOrStore(t, &sync.Pool{
+		New: func() any {
+			return reflect.New(t).Interface()
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// getFieldValue returns a zero value of type T from its pool.
+func getFieldValue[T any]() *T {
+	return fieldValuePool(reflect.TypeFor[T]()).Get().(*T)
+}
+
+// putFieldValue resets msg to the zero value and returns it to the pool of its type. msg is left
+// untouched if its type has no pool, i.e. it's not a type decoded by DecodeMatchField.
+func putFieldValue(msg util.Message) {
+	v := reflect.ValueOf(msg)
+	if msg == nil || v.Kind() != reflect.Pointer || v.IsNil() {
+		return
+	}
+	pool, ok := fieldValuePools.Load(v.Elem().Type())
+	if !ok {
+		return
+	}
+	v.Elem().SetZero()
+	pool.(*sync.Pool).Put(msg)
+}
+
+// ErrReservedOxmField is returned when decoding a field in the OpenFlow basic class with a field
+// number which is not assigned by the OpenFlow specification.
+var ErrReservedOxmField = errors.New("reserved OXM field number")
+
+// oxmFieldReserved40 is the OpenFlow basic field number between OXM_FIELD_IPV6_EXTHDR and
+// OXM_FIELD_PBB_UCA, which is not assigned by the OpenFlow specification.
+const oxmFieldReserved40 = 40
+
 func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, data []byte) (util.Message, error) {
 	if class == OXM_CLASS_OPENFLOW_BASIC {
 		var val util.Message
 		val = nil
 		switch field {
 		case OXM_FIELD_IN_PORT:
-			val = new(InPortField)
+			val = getFieldValue[InPortField]()
 		case OXM_FIELD_IN_PHY_PORT:
-			val = new(InPhyPortField)
+			val = getFieldValue[InPhyPortField]()
 		case OXM_FIELD_METADATA:
-			val = new(MetadataField)
+			val = getFieldValue[MetadataField]()
 		case OXM_FIELD_ETH_DST:
-			val = new(EthDstField)
+			val = getFieldValue[EthDstField]()
 		case OXM_FIELD_ETH_SRC:
-			val = new(EthSrcField)
+			val = getFieldValue[EthSrcField]()
 		case OXM_FIELD_ETH_TYPE:
-			val = new(EthTypeField)
+			val = getFieldValue[EthTypeField]()
 		case OXM_FIELD_VLAN_VID:
-			val = new(VlanIdField)
+			val = getFieldValue[VlanIdField]()
 		case OXM_FIELD_VLAN_PCP:
-			val = new(VlanPcpField)
+			val = getFieldValue[VlanPcpField]()
 		case OXM_FIELD_IP_DSCP:
-			val = new(IpDscpField)
+			val = getFieldValue[IpDscpField]()
 		case OXM_FIELD_IP_ECN:
-			val = new(IpEcnField)
+			val = getFieldValue[IpEcnField]()
 		case OXM_FIELD_IP_PROTO:
-			val = new(IpProtoField)
+			val = getFieldValue[IpProtoField]()
 		case OXM_FIELD_IPV4_SRC:
-			val = new(Ipv4SrcField)
+			val = getFieldValue[Ipv4SrcField]()
 		case OXM_FIELD_IPV4_DST:
-			val = new(Ipv4DstField)
+			val = getFieldValue[Ipv4DstField]()
 		case OXM_FIELD_TCP_SRC:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case OXM_FIELD_TCP_DST:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case OXM_FIELD_UDP_SRC:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case OXM_FIELD_UDP_DST:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case OXM_FIELD_SCTP_SRC:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case OXM_FIELD_SCTP_DST:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case OXM_FIELD_ICMPV4_TYPE:
-			val = new(IcmpTypeField)
+			val = getFieldValue[IcmpTypeField]()
 		case OXM_FIELD_ICMPV4_CODE:
-			val = new(IcmpCodeField)
+			val = getFieldValue[IcmpCodeField]()
 		case OXM_FIELD_ARP_OP:
-			val = new(ArpOperField)
+			val = getFieldValue[ArpOperField]()
 		case OXM_FIELD_ARP_SPA:
-			val = new(ArpXPaField)
+			val = getFieldValue[ArpXPaField]()
 		case OXM_FIELD_ARP_TPA:
-			val = new(ArpXPaField)
+			val = getFieldValue[ArpXPaField]()
 		case OXM_FIELD_ARP_SHA:
-			val = new(ArpXHaField)
+			val = getFieldValue[ArpXHaField]()
 		case OXM_FIELD_ARP_THA:
-			val = new(ArpXHaField)
+			val = getFieldValue[ArpXHaField]()
 		case OXM_FIELD_IPV6_SRC:
-			val = new(Ipv6SrcField)
+			val = getFieldValue[Ipv6SrcField]()
 		case OXM_FIELD_IPV6_DST:
-			val = new(Ipv6DstField)
+			val = getFieldValue[Ipv6DstField]()
 		case OXM_FIELD_IPV6_FLABEL:
-			val = new(Ipv6FLabelField)
+			val = getFieldValue[Ipv6FLabelField]()
 		case OXM_FIELD_ICMPV6_TYPE:
-			val = new(IcmpTypeField)
+			val = getFieldValue[IcmpTypeField]()
 		case OXM_FIELD_ICMPV6_CODE:
-			val = new(IcmpCodeField)
+			val = getFieldValue[IcmpCodeField]()
 		case OXM_FIELD_IPV6_ND_TARGET:
-			val = new(Ipv6DstField)
+			val = getFieldValue[Ipv6DstField]()
 		case OXM_FIELD_IPV6_ND_SLL:
-			val = new(EthSrcField)
+			val = getFieldValue[EthSrcField]()
 		case OXM_FIELD_IPV6_ND_TLL:
-			val = new(EthDstField)
+			val = getFieldValue[EthDstField]()
 		case OXM_FIELD_MPLS_LABEL:
-			val = new(MplsLabelField)
+			val = getFieldValue[MplsLabelField]()
 		case OXM_FIELD_MPLS_TC:
-			val = new(MplsTcField)
+			val = getFieldValue[MplsTcField]()
 		case OXM_FIELD_MPLS_BOS:
-			val = new(MplsBosField)
+			val = getFieldValue[MplsBosField]()
 		case OXM_FIELD_PBB_ISID:
-			val = new(PbbIsidField)
+			val = getFieldValue[PbbIsidField]()
 		case OXM_FIELD_TUNNEL_ID:
-			val = new(TunnelIdField)
+			val = getFieldValue[TunnelIdField]()
 		case OXM_FIELD_IPV6_EXTHDR:
-			val = new(Ipv6ExtHdrField)
+			val = getFieldValue[Ipv6ExtHdrField]()
+		case OXM_FIELD_PBB_UCA:
+			val = getFieldValue[PbbUcaField]()
 		case OXM_FIELD_TCP_FLAGS:
-			val = new(TcpFlagsField)
+			val = getFieldValue[TcpFlagsField]()
 		case OXM_FIELD_ACTSET_OUTPUT:
-			val = new(ActsetOutputField)
+			val = getFieldValue[ActsetOutputField]()
+		case OXM_FIELD_PACKET_TYPE:
+			val = getFieldValue[PacketTypeField]()
+		case oxmFieldReserved40:
+			err := fmt.Errorf("%w: %d in Class: %d", ErrReservedOxmField, field, class)
+			klog.ErrorS(err, "Received bad pkt class", "data", data)
+			return nil, err
 		default:
 			err := fmt.Errorf("unhandled Field: %d in Class: %d", field, class)
 			klog.ErrorS(err, "Received bad pkt class", "data", data)
@@ -424,6 +1869,49 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 			return nil, err
 		}
 		return val, nil
+	} else if class == OXM_CLASS_NXM_0 {
+		var val util.Message
+		switch field {
+		case NXM_OF_IN_PORT:
+			val = getFieldValue[Uint16Message]()
+		case NXM_OF_ETH_DST:
+			val = getFieldValue[EthDstField]()
+		case NXM_OF_ETH_SRC:
+			val = getFieldValue[EthSrcField]()
+		case NXM_OF_ETH_TYPE:
+			val = getFieldValue[EthTypeField]()
+		case NXM_OF_VLAN_TCI:
+			val = getFieldValue[Uint16Message]()
+		case NXM_OF_IP_TOS:
+			val = getFieldValue[Uint8Message]()
+		case NXM_OF_IP_PROTO:
+			val = getFieldValue[IpProtoField]()
+		case NXM_OF_IP_SRC:
+			val = getFieldValue[Ipv4SrcField]()
+		case NXM_OF_IP_DST:
+			val = getFieldValue[Ipv4DstField]()
+		case NXM_OF_TCP_SRC, NXM_OF_TCP_DST, NXM_OF_UDP_SRC, NXM_OF_UDP_DST:
+			val = getFieldValue[PortField]()
+		case NXM_OF_ICMP_TYPE:
+			val = getFieldValue[IcmpTypeField]()
+		case NXM_OF_ICMP_CODE:
+			val = getFieldValue[IcmpCodeField]()
+		case NXM_OF_ARP_OP:
+			val = getFieldValue[ArpOperField]()
+		case NXM_OF_ARP_SPA, NXM_OF_ARP_TPA:
+			val = getFieldValue[ArpXPaField]()
+		default:
+			err := fmt.Errorf("unknown field for nxm_0: %v", field)
+			klog.ErrorS(err, "Received invalid field", "data", data)
+			return nil, err
+		}
+
+		err := val.UnmarshalBinary(data)
+		if err != nil {
+			klog.ErrorS(err, "Failed to unmarshal Nxm Field", "data", data)
+			return nil, err
+		}
+		return val, nil
 	} else if class == OXM_CLASS_NXM_1 {
 		var val util.Message
 		switch field {
@@ -458,45 +1946,55 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case NXM_NX_REG14:
 			fallthrough
 		case NXM_NX_REG15:
-			val = new(Uint32Message)
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_TUN_ID:
+			val = getFieldValue[Uint64Message]()
 		case NXM_NX_ARP_SHA:
-			val = new(ArpXHaField)
+			val = getFieldValue[ArpXHaField]()
 		case NXM_NX_ARP_THA:
-			val = new(ArpXHaField)
+			val = getFieldValue[ArpXHaField]()
 		case NXM_NX_IPV6_SRC:
-			val = new(Ipv6SrcField)
+			val = getFieldValue[Ipv6SrcField]()
 		case NXM_NX_IPV6_DST:
-			val = new(Ipv6DstField)
+			val = getFieldValue[Ipv6DstField]()
 		case NXM_NX_ICMPV6_TYPE:
-			val = new(IcmpTypeField)
+			val = getFieldValue[IcmpTypeField]()
 		case NXM_NX_ICMPV6_CODE:
-			val = new(IcmpCodeField)
+			val = getFieldValue[IcmpCodeField]()
 		case NXM_NX_ND_TARGET:
-			val = new(Ipv6DstField)
+			val = getFieldValue[Ipv6DstField]()
 		case NXM_NX_ND_SLL:
-			val = new(EthDstField)
+			val = getFieldValue[EthDstField]()
 		case NXM_NX_ND_TLL:
-			val = new(EthSrcField)
+			val = getFieldValue[EthSrcField]()
 		case NXM_NX_IP_FRAG:
+			val = getFieldValue[Uint8Message]()
 		case NXM_NX_IPV6_LABEL:
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_IP_ECN:
+			val = getFieldValue[IpEcnField]()
 		case NXM_NX_IP_TTL:
-			val = new(TtlField)
+			val = getFieldValue[TtlField]()
 		case NXM_NX_MPLS_TTL:
+			val = getFieldValue[Uint8Message]()
 		case NXM_NX_TUN_IPV4_SRC:
-			val = new(TunnelIpv4SrcField)
+			val = getFieldValue[TunnelIpv4SrcField]()
 		case NXM_NX_TUN_IPV4_DST:
-			val = new(TunnelIpv4DstField)
+			val = getFieldValue[TunnelIpv4DstField]()
 		case NXM_NX_PKT_MARK:
-			val = new(Uint32Message)
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_TCP_FLAGS:
+			val = getFieldValue[TcpFlagsField]()
 		case NXM_NX_DP_HASH:
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_RECIRC_ID:
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_CONJ_ID:
-			val = new(Uint32Message)
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_TUN_GBP_ID:
+			val = getFieldValue[Uint16Message]()
 		case NXM_NX_TUN_GBP_FLAGS:
+			val = getFieldValue[Uint8Message]()
 		case NXM_NX_TUN_METADATA0:
 			fallthrough
 		case NXM_NX_TUN_METADATA1:
@@ -512,40 +2010,47 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case NXM_NX_TUN_METADATA6:
 			fallthrough
 		case NXM_NX_TUN_METADATA7:
-			msg := new(ByteArrayField)
+			msg := getFieldValue[ByteArrayField]()
 			if !hasMask {
 				msg.Length = length
 			} else {
 				msg.Length = length / 2
 			}
+			index := int(field - NXM_NX_TUN_METADATA0)
+			if expectLength, ok := getTunMetadataLength(index); ok && msg.Length != expectLength {
+				err := fmt.Errorf("tun_metadata%d has length %d, but %d is registered", index, msg.Length, expectLength)
+				klog.ErrorS(err, "Received invalid field", "data", data)
+				return nil, err
+			}
 			val = msg
 		case NXM_NX_TUN_FLAGS:
+			val = getFieldValue[Uint16Message]()
 		case NXM_NX_CT_STATE:
-			val = new(Uint32Message)
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_CT_ZONE:
-			val = new(Uint16Message)
+			val = getFieldValue[Uint16Message]()
 		case NXM_NX_CT_MARK:
-			val = new(Uint32Message)
+			val = getFieldValue[Uint32Message]()
 		case NXM_NX_CT_LABEL:
-			val = new(CTLabel)
+			val = getFieldValue[CTLabel]()
 		case NXM_NX_TUN_IPV6_SRC:
-			val = new(Ipv6SrcField)
+			val = getFieldValue[Ipv6SrcField]()
 		case NXM_NX_TUN_IPV6_DST:
-			val = new(Ipv6DstField)
+			val = getFieldValue[Ipv6DstField]()
 		case NXM_NX_CT_NW_PROTO:
-			val = new(IpProtoField)
+			val = getFieldValue[IpProtoField]()
 		case NXM_NX_CT_NW_SRC:
-			val = new(Ipv4SrcField)
+			val = getFieldValue[Ipv4SrcField]()
 		case NXM_NX_CT_NW_DST:
-			val = new(Ipv4DstField)
+			val = getFieldValue[Ipv4DstField]()
 		case NXM_NX_CT_IPV6_SRC:
-			val = new(Ipv6SrcField)
+			val = getFieldValue[Ipv6SrcField]()
 		case NXM_NX_CT_IPV6_DST:
-			val = new(Ipv6DstField)
+			val = getFieldValue[Ipv6DstField]()
 		case NXM_NX_CT_TP_DST:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case NXM_NX_CT_TP_SRC:
-			val = new(PortField)
+			val = getFieldValue[PortField]()
 		case NXM_NX_XXREG0:
 			fallthrough
 		case NXM_NX_XXREG1:
@@ -553,7 +2058,7 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case NXM_NX_XXREG2:
 			fallthrough
 		case NXM_NX_XXREG3:
-			msg := new(ByteArrayField)
+			msg := getFieldValue[ByteArrayField]()
 			if !hasMask {
 				msg.Length = length
 			} else {
@@ -590,7 +2095,7 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		case OXM_PACKET_REG6:
 			fallthrough
 		case OXM_PACKET_REG7:
-			msg := new(ByteArrayField)
+			msg := getFieldValue[ByteArrayField]()
 			if !hasMask {
 				msg.Length = length
 			} else {
@@ -612,7 +2117,7 @@ func DecodeMatchField(class uint16, field uint8, length uint8, hasMask bool, dat
 		var val util.Message
 		switch field {
 		case OXM_FIELD_TCP_FLAGS:
-			val = new(TcpFlagsField)
+			val = getFieldValue[TcpFlagsField]()
 		default:
 			err := fmt.Errorf("unknown field for experimenter: %v", field)
 			klog.ErrorS(err, "Received invalid field", "data", data)
@@ -788,6 +2293,9 @@ func (m *InPortField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *InPortField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal InPortField message")
+	}
 	m.InPort = binary.BigEndian.Uint32(data)
 	return nil
 }
@@ -822,6 +2330,9 @@ func (m *InPhyPortField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *InPhyPortField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal InPhyPortField message")
+	}
 	m.InPhyPort = binary.BigEndian.Uint32(data)
 	return nil
 }
@@ -856,6 +2367,9 @@ func (m *EthDstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *EthDstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal EthDstField message")
+	}
 	m.EthDst = make([]byte, 6)
 	copy(m.EthDst, data)
 	return nil
@@ -900,6 +2414,9 @@ func (m *EthSrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *EthSrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal EthSrcField message")
+	}
 	m.EthSrc = make([]byte, 6)
 	copy(m.EthSrc, data)
 	return nil
@@ -944,6 +2461,9 @@ func (m *EthTypeField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *EthTypeField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal EthTypeField message")
+	}
 	m.EthType = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -981,19 +2501,31 @@ func (m *VlanIdField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *VlanIdField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal VlanIdField message")
+	}
 	m.VlanId = binary.BigEndian.Uint16(data)
 	return nil
 }
 
 // Return a MatchField for vlan id matching
 func NewVlanIdField(vlanId uint16, vlanMask *uint16) *MatchField {
+	return NewVlanIdFieldWithPresent(vlanId, vlanMask, true)
+}
+
+// NewVlanIdFieldWithPresent returns a MatchField for vlan id matching, the OFPVID_PRESENT bit is set
+// in the value only if present is true. NewVlanIdField always sets the bit.
+func NewVlanIdFieldWithPresent(vlanId uint16, vlanMask *uint16, present bool) *MatchField {
 	f := new(MatchField)
 	f.Class = OXM_CLASS_OPENFLOW_BASIC
 	f.Field = OXM_FIELD_VLAN_VID
 	f.HasMask = false
 
 	vlanIdField := new(VlanIdField)
-	vlanIdField.VlanId = vlanId | OFPVID_PRESENT
+	vlanIdField.VlanId = vlanId
+	if present {
+		vlanIdField.VlanId |= OFPVID_PRESENT
+	}
 	f.Value = vlanIdField
 	f.Length = uint8(vlanIdField.Len())
 
@@ -1007,6 +2539,20 @@ func NewVlanIdField(vlanId uint16, vlanMask *uint16) *MatchField {
 	return f
 }
 
+// NewVlanPresentField returns a MatchField matching the packets with any VLAN tag, i.e.
+// vlan_vid=0x1000/0x1000.
+func NewVlanPresentField() *MatchField {
+	mask := uint16(OFPVID_PRESENT)
+	return NewVlanIdFieldWithPresent(OFPVID_PRESENT, &mask, false)
+}
+
+// NewVlanNoneField returns a MatchField matching the packets without VLAN tag, i.e.
+// vlan_vid=0x0000/0x1000.
+func NewVlanNoneField() *MatchField {
+	mask := uint16(OFPVID_PRESENT)
+	return NewVlanIdFieldWithPresent(OFPVID_NONE, &mask, false)
+}
+
 // VLAN_PCP field
 type VlanPcpField struct {
 	VlanPcp uint8
@@ -1022,6 +2568,9 @@ func (m *VlanPcpField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *VlanPcpField) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal VlanPcpField message")
+	}
 	m.VlanPcp = data[0]
 	return
 }
@@ -1057,6 +2606,9 @@ func (m *MplsLabelField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MplsLabelField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MplsLabelField message")
+	}
 	m.MplsLabel = binary.BigEndian.Uint32(data)
 	return nil
 }
@@ -1091,6 +2643,9 @@ func (m *MplsTcField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MplsTcField) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MplsTcField message")
+	}
 	m.MplsTc = data[0]
 	return
 }
@@ -1125,6 +2680,9 @@ func (m *MplsBosField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MplsBosField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MplsBosField message")
+	}
 	m.MplsBos = data[0]
 	return nil
 }
@@ -1158,6 +2716,9 @@ func (m *Ipv4SrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *Ipv4SrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv4SrcField message")
+	}
 	m.Ipv4Src = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1201,6 +2762,9 @@ func (m *Ipv4DstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *Ipv4DstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv4DstField message")
+	}
 	m.Ipv4Dst = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1238,17 +2802,33 @@ func (m *Ipv6SrcField) Len() uint16 {
 	return 16
 }
 func (m *Ipv6SrcField) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, 16)
-	copy(data, m.Ipv6Src)
-	return
+	return marshalIpv6(m.Ipv6Src)
 }
 
 func (m *Ipv6SrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv6SrcField message")
+	}
 	m.Ipv6Src = make([]byte, 16)
 	copy(m.Ipv6Src, data)
 	return nil
 }
 
+// marshalIpv6 returns the 16-byte representation of ip. An IPv4 address is encoded as an
+// IPv4-mapped IPv6 address, and an empty ip is encoded as the unspecified address.
+func marshalIpv6(ip net.IP) ([]byte, error) {
+	data := make([]byte, 16)
+	if len(ip) == 0 {
+		return data, nil
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil, fmt.Errorf("invalid IP address with length %d", len(ip))
+	}
+	copy(data, ip16)
+	return data, nil
+}
+
 // Return a MatchField for ipv6 src addr
 func NewIpv6SrcField(ipSrc net.IP, ipSrcMask *net.IP) *MatchField {
 	f := new(MatchField)
@@ -1301,7 +2881,7 @@ func NewIpv6FLabelField(flabel uint32, flabelMask *uint32) *MatchField {
 	f := new(MatchField)
 	f.Class = OXM_CLASS_OPENFLOW_BASIC
 	f.Field = OXM_FIELD_IPV6_FLABEL
-	f.HasMask = true
+	f.HasMask = false
 
 	flabelField := new(Ipv6FLabelField)
 	flabelField.FLabel = flabel
@@ -1328,12 +2908,13 @@ func (m *Ipv6DstField) Len() uint16 {
 	return 16
 }
 func (m *Ipv6DstField) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, 16)
-	copy(data, m.Ipv6Dst)
-	return
+	return marshalIpv6(m.Ipv6Dst)
 }
 
 func (m *Ipv6DstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal Ipv6DstField message")
+	}
 	m.Ipv6Dst = make([]byte, 16)
 	copy(m.Ipv6Dst, data)
 	return nil
@@ -1363,6 +2944,62 @@ func NewIpv6DstField(ipDst net.IP, ipDstMask *net.IP) *MatchField {
 	return f
 }
 
+// NewMaskedIPField returns a masked ipv4_src, ipv4_dst, ipv6_src or ipv6_dst field with the given
+// value and mask. If applyMask is true, the mask is applied to the value so that the stored value
+// has the host bits cleared, e.g. 10.0.0.1/255.255.255.0 is stored as 10.0.0.0/255.255.255.0.
+func NewMaskedIPField(field uint8, ip net.IP, mask net.IP, applyMask bool) (*MatchField, error) {
+	switch field {
+	case OXM_FIELD_IPV4_SRC, OXM_FIELD_IPV4_DST:
+		ip4, mask4 := ip.To4(), mask.To4()
+		if ip4 == nil || mask4 == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %#v or mask %#v", ip, mask)
+		}
+		ip, mask = ip4, mask4
+	case OXM_FIELD_IPV6_SRC, OXM_FIELD_IPV6_DST:
+		if ip.To4() != nil || len(ip) != net.IPv6len || len(mask) != net.IPv6len {
+			return nil, fmt.Errorf("invalid IPv6 address %#v or mask %#v", ip, mask)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported field %d for masked IP address", field)
+	}
+	if applyMask {
+		ip = ip.Mask(net.IPMask(mask))
+	}
+	switch field {
+	case OXM_FIELD_IPV4_SRC:
+		return NewIpv4SrcField(ip, &mask), nil
+	case OXM_FIELD_IPV4_DST:
+		return NewIpv4DstField(ip, &mask), nil
+	case OXM_FIELD_IPV6_SRC:
+		return NewIpv6SrcField(ip, &mask), nil
+	default:
+		return NewIpv6DstField(ip, &mask), nil
+	}
+}
+
+// NewMaskedEthField returns a masked eth_src or eth_dst field with the given value and mask. If
+// applyMask is true, the mask is applied to the value so that the stored value has the bits out of
+// the mask cleared.
+func NewMaskedEthField(field uint8, mac net.HardwareAddr, mask net.HardwareAddr, applyMask bool) (*MatchField, error) {
+	if field != OXM_FIELD_ETH_SRC && field != OXM_FIELD_ETH_DST {
+		return nil, fmt.Errorf("unsupported field %d for masked Ethernet address", field)
+	}
+	if len(mac) != 6 || len(mask) != 6 {
+		return nil, fmt.Errorf("invalid Ethernet address %#v or mask %#v", mac, mask)
+	}
+	if applyMask {
+		masked := make(net.HardwareAddr, len(mac))
+		for i := range mac {
+			masked[i] = mac[i] & mask[i]
+		}
+		mac = masked
+	}
+	if field == OXM_FIELD_ETH_SRC {
+		return NewEthSrcField(mac, &mask), nil
+	}
+	return NewEthDstField(mac, &mask), nil
+}
+
 // IP_ECN field
 type IpEcnField struct {
 	IpEcn uint8
@@ -1378,24 +3015,44 @@ func (m *IpEcnField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *IpEcnField) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal IpEcnField message")
+	}
 	m.IpEcn = data[0]
 	return
 }
 
-// Return a MatchField for vlan id matching
-func NewIpEcnField(vlanPcp uint8) *MatchField {
+// Return a MatchField for ip ecn matching
+func NewIpEcnField(ecn uint8) *MatchField {
 	f := new(MatchField)
 	f.Class = OXM_CLASS_OPENFLOW_BASIC
 	f.Field = OXM_FIELD_IP_ECN
 	f.HasMask = false
 
-	vlanPcpField := new(IpEcnField)
-	f.Value = vlanPcpField
-	f.Length = uint8(vlanPcpField.Len())
+	ipEcnField := new(IpEcnField)
+	ipEcnField.IpEcn = ecn
+	f.Value = ipEcnField
+	f.Length = uint8(ipEcnField.Len())
 
 	return f
 }
 
+// NewIpDscpEcnFields returns the ip_dscp and ip_ecn MatchFields with the DSCP and ECN of ipPacket,
+// which are in the ToS byte of an IPv4 packet or the Traffic Class of an IPv6 packet. ipPacket
+// must be a *protocol.IPv4 or a *protocol.IPv6.
+func NewIpDscpEcnFields(ipPacket util.Message) (*MatchField, *MatchField, error) {
+	var dscp, ecn uint8
+	switch p := ipPacket.(type) {
+	case *protocol.IPv4:
+		dscp, ecn = p.DSCP, p.ECN
+	case *protocol.IPv6:
+		dscp, ecn = p.TrafficClass>>2, p.TrafficClass&0x03
+	default:
+		return nil, nil, fmt.Errorf("unsupported IP packet type %T", ipPacket)
+	}
+	return NewIpDscpField(dscp, nil), NewIpEcnField(ecn), nil
+}
+
 // IP_PROTO field
 type IpProtoField struct {
 	Protocol uint8
@@ -1411,10 +3068,40 @@ func (m *IpProtoField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *IpProtoField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal IpProtoField message")
+	}
 	m.Protocol = data[0]
 	return nil
 }
 
+// IpProtoString returns the name of the IP protocol as used by OVS, e.g. "tcp", or the protocol
+// number in decimal if the protocol is not known.
+func IpProtoString(protocol uint8) string {
+	switch protocol {
+	case 1:
+		return "icmp"
+	case 2:
+		return "igmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 47:
+		return "gre"
+	case 58:
+		return "icmp6"
+	case 132:
+		return "sctp"
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+// String returns the symbolic name of the protocol, the raw byte is kept in Protocol.
+func (m *IpProtoField) String() string {
+	return IpProtoString(m.Protocol)
+}
+
 // Return a MatchField for ipv4 protocol
 func NewIpProtoField(protocol uint8) *MatchField {
 	f := new(MatchField)
@@ -1445,6 +3132,9 @@ func (m *IpDscpField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *IpDscpField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal IpDscpField message")
+	}
 	m.Dscp = data[0]
 	return nil
 }
@@ -1478,14 +3168,16 @@ type PbbIsidField struct {
 	PbbIsid uint32
 }
 
+// The PBB I-SID is 24 bits, and it is encoded in 3 bytes on the wire.
 func (m *PbbIsidField) Len() uint16 {
-	return 4
+	return 3
 }
 
 func (m *PbbIsidField) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, m.Len())
-
-	binary.BigEndian.PutUint32(data[0:], m.PbbIsid)
+	data[0] = uint8(m.PbbIsid >> 16)
+	data[1] = uint8(m.PbbIsid >> 8)
+	data[2] = uint8(m.PbbIsid)
 	return
 }
 
@@ -1493,7 +3185,7 @@ func (m *PbbIsidField) UnmarshalBinary(data []byte) error {
 	if len(data) < int(m.Len()) {
 		return errors.New("The byte array has wrong size to unmarshal PbbIsidField message")
 	}
-	m.PbbIsid = binary.BigEndian.Uint32(data[0:])
+	m.PbbIsid = uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
 	return nil
 }
 
@@ -1501,7 +3193,7 @@ func NewPbbIsidField(pbbIsid uint32, pbbIsidMask *uint32) *MatchField {
 	f := new(MatchField)
 	f.Class = OXM_CLASS_OPENFLOW_BASIC
 	f.Field = OXM_FIELD_PBB_ISID
-	f.HasMask = true
+	f.HasMask = false
 
 	pbbIsidField := new(PbbIsidField)
 	pbbIsidField.PbbIsid = pbbIsid
@@ -1519,6 +3211,43 @@ func NewPbbIsidField(pbbIsid uint32, pbbIsidMask *uint32) *MatchField {
 	return f
 }
 
+// PBB_UCA field
+type PbbUcaField struct {
+	PbbUca uint8
+}
+
+func (m *PbbUcaField) Len() uint16 {
+	return 1
+}
+
+func (m *PbbUcaField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 1)
+	data[0] = m.PbbUca
+	return
+}
+
+func (m *PbbUcaField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal PbbUcaField message")
+	}
+	m.PbbUca = data[0]
+	return nil
+}
+
+// Return a MatchField for PBB UCA (Use Customer Address) bit matching
+func NewPbbUcaField(pbbUca uint8) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_PBB_UCA
+	f.HasMask = false
+
+	pbbUcaField := new(PbbUcaField)
+	pbbUcaField.PbbUca = pbbUca
+	f.Value = pbbUcaField
+	f.Length = uint8(pbbUcaField.Len())
+	return f
+}
+
 // TUNNEL_ID field
 type TunnelIdField struct {
 	TunnelId uint64
@@ -1534,6 +3263,9 @@ func (m *TunnelIdField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *TunnelIdField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TunnelIdField message")
+	}
 	m.TunnelId = binary.BigEndian.Uint64(data)
 	return nil
 }
@@ -1568,10 +3300,18 @@ func (m *MetadataField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *MetadataField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal MetadataField message")
+	}
 	m.Metadata = binary.BigEndian.Uint64(data)
 	return nil
 }
 
+// BigInt returns the value of the MetadataField as a big.Int.
+func (m *MetadataField) BigInt() *big.Int {
+	return new(big.Int).SetUint64(m.Metadata)
+}
+
 // Return a MatchField for tunnel id matching
 func NewMetadataField(metadata uint64, metadataMask *uint64) *MatchField {
 	f := new(MatchField)
@@ -1611,6 +3351,9 @@ func (m *PortField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *PortField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal PortField message")
+	}
 	m.Port = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -1705,7 +3448,7 @@ func NewIpv6ExtHdrField(ipv6ExtHeader uint16, ipv6ExtHeaderMask *uint16) *MatchF
 	f := new(MatchField)
 	f.Class = OXM_CLASS_OPENFLOW_BASIC
 	f.Field = OXM_FIELD_IPV6_EXTHDR
-	f.HasMask = true
+	f.HasMask = false
 
 	ipv6ExtHeaderField := new(Ipv6ExtHdrField)
 	ipv6ExtHeaderField.Ipv6ExtHdr = ipv6ExtHeader
@@ -1737,6 +3480,9 @@ func (m *TcpFlagsField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *TcpFlagsField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TcpFlagsField message")
+	}
 	m.TcpFlags = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -1780,6 +3526,9 @@ func (m *ArpOperField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *ArpOperField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal ArpOperField message")
+	}
 	m.ArpOper = binary.BigEndian.Uint16(data)
 	return nil
 }
@@ -1814,6 +3563,9 @@ func (m *TunnelIpv4SrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *TunnelIpv4SrcField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TunnelIpv4SrcField message")
+	}
 	m.TunnelIpv4Src = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1857,6 +3609,9 @@ func (m *TunnelIpv4DstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *TunnelIpv4DstField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal TunnelIpv4DstField message")
+	}
 	m.TunnelIpv4Dst = net.IPv4(data[0], data[1], data[2], data[3])
 	return nil
 }
@@ -1955,7 +3710,9 @@ func (m *TtlField) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
-// NewIPTtlField will return a MatchField for ipv4 ttl
+// NewIPTtlField will return a MatchField for ipv4 ttl. It is encoded as NXM_NX_IP_TTL, since there
+// is no OXM field for the IP TTL, and it has no masked form because OVS doesn't support masking
+// nw_ttl.
 func NewIPTtlField(ttl uint8) *MatchField {
 	f := new(MatchField)
 	f.Class = OXM_CLASS_NXM_1
@@ -2000,6 +3757,53 @@ func NewSctpSrcField(port uint16) *MatchField {
 	return f
 }
 
+// newMaskedPortField returns the transport port MatchField of field matching port/mask, which could
+// express a port range aligned to a power of 2, e.g. 0x8000/0xf000 for the ports 32768 to 36863.
+func newMaskedPortField(field uint8, port, mask uint16) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = field
+	f.HasMask = true
+
+	portField := NewPortField(port)
+	f.Value = portField
+	maskField := NewPortField(mask)
+	f.Mask = maskField
+	f.Length = uint8(portField.Len() + maskField.Len())
+
+	return f
+}
+
+// NewTcpSrcFieldMasked returns a MatchField matching tcp_src=port/mask.
+func NewTcpSrcFieldMasked(port, mask uint16) *MatchField {
+	return newMaskedPortField(OXM_FIELD_TCP_SRC, port, mask)
+}
+
+// NewTcpDstFieldMasked returns a MatchField matching tcp_dst=port/mask.
+func NewTcpDstFieldMasked(port, mask uint16) *MatchField {
+	return newMaskedPortField(OXM_FIELD_TCP_DST, port, mask)
+}
+
+// NewUdpSrcFieldMasked returns a MatchField matching udp_src=port/mask.
+func NewUdpSrcFieldMasked(port, mask uint16) *MatchField {
+	return newMaskedPortField(OXM_FIELD_UDP_SRC, port, mask)
+}
+
+// NewUdpDstFieldMasked returns a MatchField matching udp_dst=port/mask.
+func NewUdpDstFieldMasked(port, mask uint16) *MatchField {
+	return newMaskedPortField(OXM_FIELD_UDP_DST, port, mask)
+}
+
+// NewSctpSrcFieldMasked returns a MatchField matching sctp_src=port/mask.
+func NewSctpSrcFieldMasked(port, mask uint16) *MatchField {
+	return newMaskedPortField(OXM_FIELD_SCTP_SRC, port, mask)
+}
+
+// NewSctpDstFieldMasked returns a MatchField matching sctp_dst=port/mask.
+func NewSctpDstFieldMasked(port, mask uint16) *MatchField {
+	return newMaskedPortField(OXM_FIELD_SCTP_DST, port, mask)
+}
+
 // ARP Host Address field message, used by arp_sha and arp_tha match
 type ArpXHaField struct {
 	ArpHa net.HardwareAddr
@@ -2018,6 +3822,7 @@ func (m *ArpXHaField) UnmarshalBinary(data []byte) error {
 	if len(data) < int(m.Len()) {
 		return errors.New("The byte array has wrong size to unmarshal ArpXHaField message")
 	}
+	m.ArpHa = make([]byte, 6)
 	copy(m.ArpHa, data[:6])
 	return nil
 }
@@ -2112,10 +3917,21 @@ func (m *ActsetOutputField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (m *ActsetOutputField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(m.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal ActsetOutputField message")
+	}
 	m.OutputPort = binary.BigEndian.Uint32(data)
 	return nil
 }
 
+// String returns the name of the reserved port, e.g. "UNSET", or else the port number in decimal.
+func (m *ActsetOutputField) String() string {
+	if name, ok := reservedPortNames[m.OutputPort]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(m.OutputPort), 10)
+}
+
 // Return a MatchField for actset_output port matching
 func NewActsetOutputField(actsetOutputPort uint32) *MatchField {
 	f := new(MatchField)
@@ -2131,6 +3947,21 @@ func NewActsetOutputField(actsetOutputPort uint32) *MatchField {
 	return f
 }
 
+// NewActsetOutputReservedField returns a MatchField for actset_output matching the reserved port,
+// e.g. P_UNSET for the action set without an output action.
+func NewActsetOutputReservedField(port uint32) (*MatchField, error) {
+	if _, ok := reservedPortNames[port]; !ok {
+		return nil, fmt.Errorf("port %d is not a reserved port", port)
+	}
+	return NewActsetOutputField(port), nil
+}
+
+// NewActsetOutputUnsetField returns a MatchField for actset_output matching the action set
+// without an output action.
+func NewActsetOutputUnsetField() *MatchField {
+	return NewActsetOutputField(P_UNSET)
+}
+
 type IcmpTypeField struct {
 	Type uint8
 }
@@ -2175,6 +4006,22 @@ func (f *IcmpCodeField) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// Namespaces of packet_type.
+const (
+	OFPHTN_ONF          = 0 /* ONF namespace. */
+	OFPHTN_ETHERTYPE    = 1 /* ns_type is an Ethertype. */
+	OFPHTN_IP_PROTO     = 2 /* ns_type is a IP protocol number. */
+	OFPHTN_UDP_TCP_PORT = 3 /* ns_type is a TCP or UDP port. */
+	OFPHTN_IPV4_OPTION  = 4 /* ns_type is an IPv4 option number. */
+)
+
+// Types of packet_type in the ONF namespace.
+const (
+	OFPHTO_ETHERNET         = 0      /* Ethernet (DIX or IEEE 802.3) - default. */
+	OFPHTO_NO_HEADER        = 1      /* No header. */
+	OFPHTO_OXM_EXPERIMENTER = 0xFFFF /* Use Experimenter OXM. */
+)
+
 // PACKET_TYPE field
 type PacketTypeField struct {
 	Namespace uint16
@@ -2192,6 +4039,9 @@ func (f *PacketTypeField) MarshalBinary() (data []byte, err error) {
 	return
 }
 func (f *PacketTypeField) UnmarshalBinary(data []byte) error {
+	if len(data) < int(f.Len()) {
+		return errors.New("The byte array has wrong size to unmarshal PacketTypeField message")
+	}
 	f.Namespace = binary.BigEndian.Uint16(data[0:])
 	f.NsType = binary.BigEndian.Uint16(data[2:])
 	return nil
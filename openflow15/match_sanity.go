@@ -0,0 +1,64 @@
+package openflow15
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// sanityLogger is the logger of the warnings about the suspicious field values added to a Match, the
+// warnings are disabled if it is nil.
+var sanityLogger atomic.Pointer[klog.Logger]
+
+// SetSanityLogger enables the warnings about the likely mistaken field values added to a Match, e.g.
+// a tcp_dst of 0x5000, which is port 80 in the wrong byte order, or a vlan_vid without the
+// OFPVID_PRESENT bit. The warnings are logged by logger, and disabled if logger is nil, which is the
+// default. The library can't know the intent, so the values are still added as they are.
+func SetSanityLogger(logger *klog.Logger) {
+	sanityLogger.Store(logger)
+}
+
+// commonHighBytePorts are the transport ports which only have the high byte set, like a well-known
+// port in the wrong byte order, but are commonly used as they are: the powers of 2 from 1024, and
+// 49152, which is the first dynamic port.
+var commonHighBytePorts = map[uint16]bool{
+	1024:  true,
+	2048:  true,
+	4096:  true,
+	8192:  true,
+	16384: true,
+	32768: true,
+	49152: true,
+}
+
+// checkFieldSanity logs a warning for f if its value is suspicious and the warnings are enabled.
+func checkFieldSanity(f *MatchField) {
+	logger := sanityLogger.Load()
+	if logger == nil || f.Class != OXM_CLASS_OPENFLOW_BASIC {
+		return
+	}
+	switch f.Field {
+	case OXM_FIELD_TCP_SRC, OXM_FIELD_TCP_DST, OXM_FIELD_UDP_SRC, OXM_FIELD_UDP_DST, OXM_FIELD_SCTP_SRC, OXM_FIELD_SCTP_DST:
+		port, ok := f.Value.(*PortField)
+		if !ok || f.HasMask {
+			return
+		}
+		// A well-known port in the wrong byte order has only the high byte set, e.g. 0x5000 for 80.
+		// The ports which only have the high byte set but are commonly used as they are, e.g. 1024
+		// or 32768, are not reported.
+		swapped := bits.ReverseBytes16(port.Port)
+		if port.Port&0xff == 0 && swapped != 0 && swapped < 1024 && !commonHighBytePorts[port.Port] {
+			logger.Info("Transport port may be in the wrong byte order", "field", semanticFieldName(f.Class, f.Field), "port", port.Port, "swappedPort", swapped)
+		}
+	case OXM_FIELD_VLAN_VID:
+		vid, ok := f.Value.(*VlanIdField)
+		if !ok {
+			return
+		}
+		// OFPVID_NONE, i.e. 0, matches the packets without VLAN tag.
+		if vid.VlanId != 0 && vid.VlanId&OFPVID_PRESENT == 0 {
+			logger.Info("VLAN ID is missing the OFPVID_PRESENT bit", "field", semanticFieldName(f.Class, f.Field), "vlanID", vid.VlanId)
+		}
+	}
+}
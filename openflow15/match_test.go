@@ -2,9 +2,20 @@ package openflow15
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/go-logr/logr/funcr"
+
+	"antrea.io/libOpenflow/protocol"
+	"antrea.io/libOpenflow/util"
+	"antrea.io/libOpenflow/util/testutil"
 )
 
 func TestMatchEthAddresses(t *testing.T) {
@@ -51,3 +62,2171 @@ func checkMatchSerializationConsistency(ofMatch *Match) error {
 
 	return nil
 }
+
+func TestMatchFieldMarshalWithNilMask(t *testing.T) {
+	field := NewIpv4SrcField(net.ParseIP("10.0.0.1"), nil)
+	field.HasMask = true
+
+	data, err := field.MarshalBinary()
+	if err == nil {
+		t.Fatalf("expected error when marshaling a masked field without Mask, got data %v", data)
+	}
+
+	ofMatch := NewMatch()
+	ofMatch.AddField(*field)
+	if _, err := ofMatch.MarshalBinary(); err == nil {
+		t.Fatalf("expected error when marshaling a match containing a masked field without Mask")
+	}
+}
+
+func TestMatchUnmarshalLenient(t *testing.T) {
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	ofMatch.AddField(*NewIpv4SrcField(net.ParseIP("10.0.0.1"), nil))
+	// Field 50 is not a known OXM basic field, so it can't be decoded.
+	ofMatch.AddField(MatchField{Class: OXM_CLASS_OPENFLOW_BASIC, Field: 50, Length: 4, Value: newUint32Message(1)})
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+
+	strictMatch := new(Match)
+	if err := strictMatch.UnmarshalBinary(data); err == nil {
+		t.Fatalf("Expected error when unmarshaling match with a bad field in strict mode")
+	}
+	if len(strictMatch.Fields) != 0 || strictMatch.Length != 4 {
+		t.Errorf("Expected no fields and Length 4 in strict mode, got %d fields and Length %d", len(strictMatch.Fields), strictMatch.Length)
+	}
+
+	lenientMatch := new(Match)
+	if err := lenientMatch.UnmarshalBinaryLenient(data); err == nil {
+		t.Fatalf("Expected error when unmarshaling match with a bad field in lenient mode")
+	}
+	if len(lenientMatch.Fields) != 2 {
+		t.Fatalf("Expected 2 fields in lenient mode, got %d", len(lenientMatch.Fields))
+	}
+	// 4 bytes of header, 6 bytes of eth_type and 8 bytes of ipv4_src.
+	if lenientMatch.Length != 18 {
+		t.Errorf("Expected Length 18 of the kept fields in lenient mode, got %d", lenientMatch.Length)
+	}
+	if ethType := lenientMatch.Fields[0].Value.(*EthTypeField).EthType; ethType != 0x0800 {
+		t.Errorf("Unexpected eth_type %x", ethType)
+	}
+	if ipSrc := lenientMatch.Fields[1].Value.(*Ipv4SrcField).Ipv4Src; !ipSrc.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Unexpected ipv4_src %v", ipSrc)
+	}
+
+	// The Match header declares more bytes than data has.
+	truncatedMatch := new(Match)
+	if err := truncatedMatch.UnmarshalBinaryLenient(data[:10]); err == nil {
+		t.Fatalf("Expected error when unmarshaling a truncated match in lenient mode")
+	}
+	if len(truncatedMatch.Fields) != 0 || truncatedMatch.Length != 4 {
+		t.Errorf("Expected no fields and Length 4 of a truncated match, got %d fields and Length %d", len(truncatedMatch.Fields), truncatedMatch.Length)
+	}
+}
+
+func TestMatchFieldLen(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	ipv4 := net.ParseIP("10.0.0.1")
+	ipv6 := net.ParseIP("fe80::1")
+	u8, u16, u32, u64 := uint8(1), uint16(1), uint32(1), uint64(1)
+	for name, field := range map[string]*MatchField{
+		"in_port":            NewInPortField(1),
+		"in_phy_port":        NewInPhyPortField(1),
+		"metadata":           NewMetadataField(1, &u64),
+		"eth_dst":            NewEthDstField(mac, &mac),
+		"eth_src":            NewEthSrcField(mac, nil),
+		"eth_type":           NewEthTypeField(0x0800),
+		"vlan_vid":           NewVlanIdField(1, &u16),
+		"vlan_pcp":           NewVlanPcpField(1),
+		"ip_dscp":            NewIpDscpField(1, &u8),
+		"ip_ecn":             NewIpEcnField(1),
+		"ip_proto":           NewIpProtoField(6),
+		"ipv4_src":           NewIpv4SrcField(ipv4, &ipv4),
+		"ipv4_dst":           NewIpv4DstField(ipv4, nil),
+		"tcp_src":            NewTcpSrcField(1),
+		"udp_dst":            NewUdpDstField(1),
+		"sctp_src":           NewSctpSrcField(1),
+		"arp_op":             NewArpOperField(1),
+		"arp_spa":            NewArpSpaField(ipv4),
+		"arp_tha":            NewArpThaField(mac),
+		"ipv6_src":           NewIpv6SrcField(ipv6, &ipv6),
+		"ipv6_dst":           NewIpv6DstField(ipv6, nil),
+		"ipv6_flabel":        NewIpv6FLabelField(1, nil),
+		"ipv6_flabel_masked": NewIpv6FLabelField(1, &u32),
+		"mpls_label":         NewMplsLabelField(1),
+		"mpls_tc":            NewMplsTcField(1),
+		"mpls_bos":           NewMplsBosField(1),
+		"pbb_isid":           NewPbbIsidField(1, nil),
+		"pbb_isid_masked":    NewPbbIsidField(1, &u32),
+		"tunnel_id":          NewTunnelIdField(1),
+		"ipv6_exthdr":        NewIpv6ExtHdrField(1, nil),
+		"ipv6_exthdr_masked": NewIpv6ExtHdrField(1, &u16),
+		"tcp_flags":          NewTcpFlagsField(1, &u16),
+		"actset_output":      NewActsetOutputField(1),
+		"packet_type":        NewPacketTypeField(0, 0x0800),
+		"tun_src":            NewTunnelIpv4SrcField(ipv4, &ipv4),
+		"tun_ipv6_dst":       NewTunnelIpv6DstField(ipv6, nil),
+		"nw_ttl":             NewIPTtlField(1),
+		"reg":                NewRegMatchFieldWithMask(1, 1, 0xf),
+		"tun_metadata":       NewTunMetadataField(0, []byte{1, 2, 3, 4}, []byte{0xff, 0xff, 0, 0}),
+		"ct_state":           NewCTStateMatchField(NewCTStates()),
+		"ct_zone":            NewCTZoneMatchField(1),
+		"ct_mark":            NewCTMarkMatchField(1, &u32),
+		"ct_label":           NewCTLabelMatchField([16]byte{1}, &[16]byte{1}),
+		"conj_id":            NewConjIDMatchField(1),
+		"nx_arp_sha":         NewNxARPShaMatchField(mac, nil),
+		"nx_arp_spa":         NewNxARPSpaMatchField(ipv4, ipv4),
+	} {
+		data, err := field.MarshalBinary()
+		if err != nil {
+			t.Errorf("Failed to marshal %s field: %v", name, err)
+			continue
+		}
+		if int(field.Len()) != len(data) {
+			t.Errorf("Len() of %s field is %d, but MarshalBinary returns %d bytes", name, field.Len(), len(data))
+		}
+		if int(field.Length)+4 != len(data) {
+			t.Errorf("Length of %s field is %d, but the payload has %d bytes", name, field.Length, len(data)-4)
+		}
+	}
+}
+
+func TestCTNwProtoString(t *testing.T) {
+	field, _ := FindFieldHeaderByName("NXM_NX_CT_NW_PROTO", false)
+	field.Value = &IpProtoField{Protocol: 6}
+	ofMatch := NewMatch()
+	ofMatch.AddField(*field)
+
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	newMatch := new(Match)
+	if err := newMatch.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal match: %v", err)
+	}
+	if s := newMatch.String(); s != "ct_nw_proto=tcp" {
+		t.Errorf("Unexpected match string: %s", s)
+	}
+	if proto := newMatch.Fields[0].Value.(*IpProtoField).Protocol; proto != 6 {
+		t.Errorf("Unexpected raw ct_nw_proto value: %d", proto)
+	}
+	if s := NewIpProtoField(200).String(); s != "ip_proto=200" {
+		t.Errorf("Unexpected match field string: %s", s)
+	}
+}
+
+func TestIpv6FieldMarshal(t *testing.T) {
+	// An IPv4 address is encoded as an IPv4-mapped IPv6 address.
+	field := NewIpv6SrcField(net.IPv4(10, 0, 0, 1).To4(), nil)
+	data, err := field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal ipv6_src field with IPv4 address: %v", err)
+	}
+	if !net.IP(data[4:]).Equal(net.ParseIP("::ffff:10.0.0.1")) {
+		t.Errorf("Unexpected ipv6_src bytes: %x", data[4:])
+	}
+
+	// An empty address is encoded as the unspecified address.
+	field = NewIpv6DstField(nil, nil)
+	data, err = field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal ipv6_dst field with empty address: %v", err)
+	}
+	if !net.IP(data[4:]).Equal(net.IPv6unspecified) {
+		t.Errorf("Unexpected ipv6_dst bytes: %x", data[4:])
+	}
+
+	field = NewIpv6DstField(net.IP{1, 2, 3, 4, 5}, nil)
+	if _, err := field.MarshalBinary(); err == nil {
+		t.Errorf("Expected error when marshaling ipv6_dst field with invalid address")
+	}
+}
+
+func TestMatchRewriteIPFields(t *testing.T) {
+	_, podCIDR, _ := net.ParseCIDR("10.0.0.0/8")
+	mask := net.ParseIP("255.255.255.0").To4()
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	ofMatch.AddField(*NewIpv4SrcField(net.ParseIP("10.1.2.3"), nil))
+	ofMatch.AddField(*NewIpv4DstField(net.ParseIP("10.4.5.0"), &mask))
+	ctNwDstField, _ := FindFieldHeaderByName("NXM_NX_CT_NW_DST", false)
+	ctNwDstField.Value = &Ipv4DstField{Ipv4Dst: net.ParseIP("172.16.0.1")}
+	ofMatch.AddField(*ctNwDstField)
+
+	var rewrittenFields []uint8
+	ofMatch.RewriteIPFields(func(field uint8, ip net.IP) net.IP {
+		rewrittenFields = append(rewrittenFields, field)
+		ip = ip.To4()
+		if !podCIDR.Contains(ip) {
+			return nil
+		}
+		return net.IPv4(192, 168, ip[2], ip[3])
+	})
+
+	if len(rewrittenFields) != 3 {
+		t.Errorf("Expected fn to be called for 3 fields, got %v", rewrittenFields)
+	}
+	if ip := ofMatch.Fields[1].Value.(*Ipv4SrcField).Ipv4Src; !ip.Equal(net.ParseIP("192.168.2.3")) {
+		t.Errorf("Unexpected ipv4_src after rewrite: %v", ip)
+	}
+	if ip := ofMatch.Fields[2].Value.(*Ipv4DstField).Ipv4Dst; !ip.Equal(net.ParseIP("192.168.5.0")) {
+		t.Errorf("Unexpected ipv4_dst after rewrite: %v", ip)
+	}
+	if m := ofMatch.Fields[2].Mask.(*Ipv4DstField).Ipv4Dst; !m.Equal(mask) {
+		t.Errorf("Unexpected ipv4_dst mask after rewrite: %v", m)
+	}
+	if ip := ofMatch.Fields[3].Value.(*Ipv4DstField).Ipv4Dst; !ip.Equal(net.ParseIP("172.16.0.1")) {
+		t.Errorf("Unexpected ct_nw_dst after rewrite: %v", ip)
+	}
+}
+
+func TestMatchFieldClone(t *testing.T) {
+	mask := net.ParseIP("ffff:ffff:ffff:ffff::")
+	field := NewIpv6SrcField(net.ParseIP("2001:db8::1"), &mask)
+	clone := field.Clone()
+	if clone.String() != field.String() {
+		t.Fatalf("Clone is different from the original field: %s vs %s", clone.String(), field.String())
+	}
+
+	clone.Value.(*Ipv6SrcField).Ipv6Src[15] = 2
+	clone.Mask.(*Ipv6SrcField).Ipv6Src[8] = 0xff
+	if ip := field.Value.(*Ipv6SrcField).Ipv6Src; !ip.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Original value is changed by the clone: %v", ip)
+	}
+	if m := field.Mask.(*Ipv6SrcField).Ipv6Src; !m.Equal(net.ParseIP("ffff:ffff:ffff:ffff::")) {
+		t.Errorf("Original mask is changed by the clone: %v", m)
+	}
+
+	tunMetadata := NewTunMetadataField(0, []byte{1, 2, 3, 4}, nil)
+	tunClone := tunMetadata.Clone()
+	tunClone.Value.(*ByteArrayField).Data[0] = 0xff
+	if data := tunMetadata.Value.(*ByteArrayField).Data; data[0] != 1 {
+		t.Errorf("Original ByteArrayField is changed by the clone: %v", data)
+	}
+
+	// The values are copied as they are, e.g. a 4-byte IPv4 address isn't converted to 16 bytes.
+	ipv4Mask := net.IP{255, 255, 255, 0}
+	for _, f := range []*MatchField{
+		NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipv4Mask),
+		NewEthTypeField(0x0800),
+		NewRegMatchFieldWithMask(1, 0x5, 0xf),
+		{Class: OXM_CLASS_NXM_1, Field: NXM_NX_REG0, Length: 4, Value: uint32Value(1)},
+	} {
+		if c := f.Clone(); !reflect.DeepEqual(c, f) {
+			t.Errorf("Clone is different from the original field: %+v vs %+v", c, f)
+		}
+	}
+	if ethType := NewEthTypeField(0x0800); ethType.Clone().Value == ethType.Value {
+		t.Errorf("The value of eth_type is shared with the clone")
+	}
+}
+
+// uint32Value is a util.Message with value receivers, which is copied with the interface.
+type uint32Value uint32
+
+func (v uint32Value) Len() uint16 {
+	return 4
+}
+
+func (v uint32Value) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, uint32(v)), nil
+}
+
+func (v uint32Value) UnmarshalBinary(data []byte) error {
+	return nil
+}
+
+func TestMatchClone(t *testing.T) {
+	mask := net.IP{255, 255, 255, 0}
+	ofMatch := NewMatchBuilder().
+		AddField(*NewEthTypeField(0x0800)).
+		AddField(*NewEthSrcField(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, nil)).
+		AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &mask)).
+		Build()
+	clone := ofMatch.Clone()
+	if !reflect.DeepEqual(clone, ofMatch) || !clone.Equal(ofMatch) {
+		t.Fatalf("Clone is different from the original match: %s vs %s", clone, ofMatch)
+	}
+
+	clone.Fields[1].Value.(*EthSrcField).EthSrc[5] = 0x66
+	clone.Fields[2].Value.(*Ipv4SrcField).Ipv4Src[2] = 1
+	clone.Fields[2].Mask.(*Ipv4SrcField).Ipv4Src[3] = 255
+	clone.Fields[0] = *NewEthTypeField(0x86dd)
+	if eth := ofMatch.Fields[0].Value.(*EthTypeField).EthType; eth != 0x0800 {
+		t.Errorf("Original eth_type is changed by the clone: 0x%04x", eth)
+	}
+	if mac := ofMatch.Fields[1].Value.(*EthSrcField).EthSrc; mac.String() != "00:11:22:33:44:55" {
+		t.Errorf("Original eth_src is changed by the clone: %s", mac)
+	}
+	if ip := ofMatch.Fields[2].Value.(*Ipv4SrcField).Ipv4Src; !ip.Equal(net.IP{10, 0, 0, 0}) {
+		t.Errorf("Original ipv4_src is changed by the clone: %v", ip)
+	}
+	if m := ofMatch.Fields[2].Mask.(*Ipv4SrcField).Ipv4Src; !m.Equal(net.IP{255, 255, 255, 0}) {
+		t.Errorf("Original ipv4_src mask is changed by the clone: %v", m)
+	}
+}
+
+func TestMatchStripImpliedPrerequisites(t *testing.T) {
+	mask := net.IP{255, 255, 255, 0}
+	for _, tc := range []struct {
+		name     string
+		match    *Match
+		expected *Match
+		// restorable is set if the builder restores the stripped prerequisites.
+		restorable bool
+	}{
+		{
+			name: "ipv4_src",
+			match: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x0800)).
+				AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &mask)).
+				Build(),
+			expected: NewMatchBuilder().
+				AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &mask)).
+				Build(),
+			restorable: true,
+		},
+		{
+			name: "ipv6 and tcp_dst",
+			match: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x86dd)).
+				AddField(*NewIpProtoField(6)).
+				AddField(*NewIpv6DstField(net.ParseIP("2001:db8::1"), nil)).
+				AddField(*NewTcpDstField(80)).
+				Build(),
+			expected: NewMatchBuilder().
+				AddField(*NewIpv6DstField(net.ParseIP("2001:db8::1"), nil)).
+				AddField(*NewTcpDstField(80)).
+				Build(),
+			restorable: true,
+		},
+		{
+			// The IP version is not implied by tcp_dst.
+			name: "tcp_dst",
+			match: NewMatchBuilder().
+				AddField(*NewTcpDstField(80)).
+				WithImplicitPrerequisites().
+				Build(),
+			expected: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x0800)).
+				AddField(*NewTcpDstField(80)).
+				Build(),
+			restorable: true,
+		},
+		{
+			// eth_type doesn't match the one implied by ipv4_src.
+			name: "conflicting eth_type",
+			match: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x86dd)).
+				AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 1}, nil)).
+				Build(),
+			expected: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x86dd)).
+				AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 1}, nil)).
+				Build(),
+		},
+	} {
+		stripped := tc.match.StripImpliedPrerequisites()
+		if stripped.Fingerprint() != tc.expected.Fingerprint() || stripped.Length != tc.expected.Length {
+			t.Errorf("Unexpected stripped match for %s, expected %s, got %s", tc.name, tc.expected, stripped)
+		}
+		if !tc.restorable {
+			continue
+		}
+		rebuilt := NewMatchBuilder()
+		for _, f := range stripped.Fields {
+			rebuilt.AddField(f)
+		}
+		if rebuilt.WithImplicitPrerequisites().Build().Fingerprint() != tc.match.Fingerprint() {
+			t.Errorf("Prerequisites are not restored by the builder for %s", tc.name)
+		}
+	}
+}
+
+func TestMatchSanityWarnings(t *testing.T) {
+	var warnings []string
+	logger := funcr.New(func(prefix, args string) {
+		warnings = append(warnings, args)
+	}, funcr.Options{})
+	SetSanityLogger(&logger)
+	defer SetSanityLogger(nil)
+
+	vlan := NewVlanIdField(10, nil)
+	vlan.Value.(*VlanIdField).VlanId = 10
+	NewMatchBuilder().
+		AddField(*NewTcpDstField(0x5000)).
+		AddField(*NewUdpSrcField(8080)).
+		AddField(*NewTcpSrcField(80)).
+		AddField(*NewUdpDstField(1025)).
+		AddField(*NewSctpDstField(2049)).
+		AddField(*NewTcpDstField(1024)).
+		AddField(*NewUdpSrcField(32768)).
+		AddField(*NewSctpSrcField(49152)).
+		AddField(*vlan).
+		AddField(*NewVlanIdField(10, nil)).
+		Build()
+	if len(warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %v", warnings)
+	}
+	if !strings.Contains(warnings[0], `"field"="tcp_dst" "port"=20480 "swappedPort"=80`) {
+		t.Errorf("Unexpected warning for tcp_dst: %s", warnings[0])
+	}
+	if !strings.Contains(warnings[1], `"field"="vlan_vid" "vlanID"=10`) {
+		t.Errorf("Unexpected warning for vlan_vid: %s", warnings[1])
+	}
+
+	SetSanityLogger(nil)
+	NewMatchBuilder().AddField(*NewTcpDstField(0x5000)).Build()
+	if len(warnings) != 2 {
+		t.Errorf("Expected no warning when the warnings are disabled, got %v", warnings[2:])
+	}
+}
+
+func TestDecodeReservedMatchField(t *testing.T) {
+	_, err := DecodeMatchField(OXM_CLASS_OPENFLOW_BASIC, 40, 4, false, []byte{0, 0, 0, 1})
+	if !errors.Is(err, ErrReservedOxmField) {
+		t.Errorf("Expected ErrReservedOxmField when decoding field 40, got %v", err)
+	}
+	_, err = DecodeMatchField(OXM_CLASS_OPENFLOW_BASIC, 100, 4, false, []byte{0, 0, 0, 1})
+	if err == nil || errors.Is(err, ErrReservedOxmField) {
+		t.Errorf("Expected a generic error when decoding field 100, got %v", err)
+	}
+}
+
+func TestBuildExactMatch(t *testing.T) {
+	// A TCP SYN from 10.0.0.1:34567 to 10.0.0.2:80.
+	frame := testutil.DecodeHex(t, "aabbccddeeff"+"112233445566"+"0800"+
+		"450000280001000040060000"+"0a000001"+"0a000002"+
+		"87070050"+"00000001"+"00000000"+"50020000"+"00000000")
+	eth := new(protocol.Ethernet)
+	if err := eth.UnmarshalBinary(frame); err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+	ip := eth.Data.(*protocol.IPv4)
+	tcp := new(protocol.TCP)
+	if err := tcp.UnmarshalBinary(ip.Data.(*util.Buffer).Bytes()); err != nil {
+		t.Fatalf("Failed to decode TCP header: %v", err)
+	}
+
+	ofMatch, err := BuildExactMatch([]util.Message{eth, ip, tcp})
+	if err != nil {
+		t.Fatalf("Failed to build match: %v", err)
+	}
+	expected := "eth_src=11:22:33:44:55:66,eth_dst=aa:bb:cc:dd:ee:ff,eth_type=0x0800,ip_proto=tcp," +
+		"ipv4_src=10.0.0.1,ipv4_dst=10.0.0.2,tcp_src=34567,tcp_dst=80"
+	if ofMatch.String() != expected {
+		t.Errorf("Unexpected match:\n%s\nexpected:\n%s", ofMatch.String(), expected)
+	}
+	if err := checkMatchSerializationConsistency(ofMatch); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := BuildExactMatch([]util.Message{eth, tcp}); err == nil {
+		t.Errorf("Expected an error when the IP layer is missing")
+	}
+}
+
+func TestNewFiveTupleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		match    *Match
+		expected string
+	}{
+		{
+			match:    newFiveTupleMatch(t, "10.0.0.1", "10.0.0.2", protocol.Type_TCP, 34567, 80),
+			expected: "eth_type=0x0800,ip_proto=tcp,ipv4_src=10.0.0.1,ipv4_dst=10.0.0.2,tcp_src=34567,tcp_dst=80",
+		},
+		{
+			match:    newFiveTupleMatch(t, "2001:db8::1", "2001:db8::2", protocol.Type_UDP, 5353, 53),
+			expected: "eth_type=0x86dd,ip_proto=udp,ipv6_src=2001:db8::1,ipv6_dst=2001:db8::2,udp_src=5353,udp_dst=53",
+		},
+		{
+			// The ports are ignored for ICMP.
+			match:    newFiveTupleMatch(t, "10.0.0.1", "10.0.0.2", protocol.Type_ICMP, 1, 2),
+			expected: "eth_type=0x0800,ip_proto=icmp,ipv4_src=10.0.0.1,ipv4_dst=10.0.0.2",
+		},
+	} {
+		if tc.match.String() != tc.expected {
+			t.Errorf("Unexpected match:\n%s\nexpected:\n%s", tc.match.String(), tc.expected)
+		}
+		if err := checkMatchSerializationConsistency(tc.match); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for name, ips := range map[string][2]net.IP{
+		"nil source IP":        {nil, net.ParseIP("10.0.0.2")},
+		"nil destination IP":   {net.ParseIP("10.0.0.1"), nil},
+		"IPv4 source and IPv6": {net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::2")},
+		"IPv6 source and IPv4": {net.ParseIP("2001:db8::1"), net.ParseIP("10.0.0.2")},
+		"invalid IP":           {net.IP{1, 2, 3}, net.ParseIP("10.0.0.2")},
+	} {
+		if _, err := NewFiveTupleMatch(ips[0], ips[1], protocol.Type_TCP, 34567, 80); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+// newFiveTupleMatch returns the Match created by NewFiveTupleMatch, and fails the test if it can't
+// be created.
+func newFiveTupleMatch(tb testing.TB, srcIP, dstIP string, proto uint8, srcPort, dstPort uint16) *Match {
+	tb.Helper()
+	m, err := NewFiveTupleMatch(net.ParseIP(srcIP), net.ParseIP(dstIP), proto, srcPort, dstPort)
+	if err != nil {
+		tb.Fatalf("Failed to create 5-tuple match: %v", err)
+	}
+	return m
+}
+
+func TestMaskedPortFields(t *testing.T) {
+	for _, tc := range []struct {
+		field    *MatchField
+		expected []byte
+	}{
+		{NewTcpSrcFieldMasked(0x8000, 0xf000), []byte{0x80, 0x00, 0x1b, 0x04, 0x80, 0x00, 0xf0, 0x00}},
+		{NewTcpDstFieldMasked(0x8000, 0xf000), []byte{0x80, 0x00, 0x1d, 0x04, 0x80, 0x00, 0xf0, 0x00}},
+		{NewUdpSrcFieldMasked(0x8000, 0xf000), []byte{0x80, 0x00, 0x1f, 0x04, 0x80, 0x00, 0xf0, 0x00}},
+		{NewUdpDstFieldMasked(0x8000, 0xf000), []byte{0x80, 0x00, 0x21, 0x04, 0x80, 0x00, 0xf0, 0x00}},
+		{NewSctpSrcFieldMasked(0x8000, 0xf000), []byte{0x80, 0x00, 0x23, 0x04, 0x80, 0x00, 0xf0, 0x00}},
+		{NewSctpDstFieldMasked(0x8000, 0xf000), []byte{0x80, 0x00, 0x25, 0x04, 0x80, 0x00, 0xf0, 0x00}},
+	} {
+		data, err := tc.field.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", tc.field, err)
+		}
+		if !bytes.Equal(data, tc.expected) {
+			t.Errorf("Unexpected bytes of %s, expected %x, got %x", tc.field, tc.expected, data)
+		}
+		decoded := new(MatchField)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", tc.field, err)
+		}
+		if !decoded.HasMask || decoded.Length != 4 || decoded.Value.(*PortField).Port != 0x8000 || decoded.Mask.(*PortField).Port != 0xf000 {
+			t.Errorf("Unexpected decoded field %s", decoded)
+		}
+	}
+
+	ofMatch := NewMatchBuilder().
+		AddField(*NewUdpDstFieldMasked(0x8000, 0xf000)).
+		WithImplicitPrerequisites().
+		Build()
+	if err := checkMatchSerializationConsistency(ofMatch); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExperimenterOXM(t *testing.T) {
+	const fakeExperimenterID = 0x00abcdef
+	const unknownExperimenterID = 0x00fedcba
+	RegisterExperimenterOXM(fakeExperimenterID, func(field uint8, length uint8, hasMask bool, data []byte) (util.Message, error) {
+		if field != 1 {
+			return nil, fmt.Errorf("unknown field %d", field)
+		}
+		msg := new(Uint32Message)
+		err := msg.UnmarshalBinary(data)
+		return msg, err
+	})
+	defer func() {
+		experimenterOXMLock.Lock()
+		defer experimenterOXMLock.Unlock()
+		delete(experimenterOXMDecoders, fakeExperimenterID)
+	}()
+
+	for _, tc := range []struct {
+		field         MatchField
+		expectedValue util.Message
+		expectedMask  util.Message
+	}{
+		{
+			// A field of the registered experimenter is decoded by its decoder.
+			field: MatchField{
+				Class:          OXM_CLASS_EXPERIMENTER,
+				Field:          1,
+				HasMask:        true,
+				Length:         12,
+				ExperimenterID: fakeExperimenterID,
+				Value:          &Uint32Message{Data: 0x1234},
+				Mask:           &Uint32Message{Data: 0xffff},
+			},
+			expectedValue: &Uint32Message{Data: 0x1234},
+			expectedMask:  &Uint32Message{Data: 0xffff},
+		},
+		{
+			// A field of an unknown experimenter is decoded as raw bytes of the declared width.
+			field: MatchField{
+				Class:          OXM_CLASS_EXPERIMENTER,
+				Field:          2,
+				Length:         10,
+				ExperimenterID: unknownExperimenterID,
+				Value:          &ByteArrayField{Data: []byte{1, 2, 3, 4, 5, 6}, Length: 6},
+			},
+			expectedValue: &ByteArrayField{Data: []byte{1, 2, 3, 4, 5, 6}, Length: 6},
+		},
+	} {
+		ofMatch := NewMatch()
+		ofMatch.AddField(*NewEthTypeField(0x0800))
+		ofMatch.AddField(tc.field)
+		ofMatch.AddField(*NewIpProtoField(6))
+		data, err := ofMatch.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal match: %v", err)
+		}
+		newMatch := new(Match)
+		if err := newMatch.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Failed to unmarshal match with experimenter %x: %v", tc.field.ExperimenterID, err)
+		}
+		if len(newMatch.Fields) != 3 {
+			t.Fatalf("Expected 3 fields, got %d", len(newMatch.Fields))
+		}
+		field := newMatch.Fields[1]
+		if field.ExperimenterID != tc.field.ExperimenterID || field.Field != tc.field.Field {
+			t.Errorf("Unexpected experimenter field: %+v", field)
+		}
+		if !reflect.DeepEqual(field.Value, tc.expectedValue) || !reflect.DeepEqual(field.Mask, tc.expectedMask) {
+			t.Errorf("Unexpected value %+v or mask %+v of experimenter %x", field.Value, field.Mask, tc.field.ExperimenterID)
+		}
+		if newData, err := newMatch.MarshalBinary(); err != nil || !bytes.Equal(data, newData) {
+			t.Errorf("Match doesn't round-trip, expected %x, got %x (%v)", data, newData, err)
+		}
+	}
+}
+
+// newONFTcpFlagsField returns a tcp_flags field in the ONF experimenter class, which is how
+// OpenFlow 1.3 switches encode it.
+func newONFTcpFlagsField(flags uint16, mask *uint16) *MatchField {
+	f := NewTcpFlagsField(flags, mask)
+	f.Class = OXM_CLASS_EXPERIMENTER
+	f.ExperimenterID = ONF_EXPERIMENTER_ID
+	// The experimenter ID is counted in Length.
+	f.Length += 4
+	return f
+}
+
+func TestMatchMixedExperimenterFields(t *testing.T) {
+	flagsMask := uint16(0x0fff)
+	for _, tcpFlags := range []MatchField{
+		*newONFTcpFlagsField(0x12, nil),
+		*newONFTcpFlagsField(0x12, &flagsMask),
+	} {
+		ofMatch := NewMatch()
+		ofMatch.AddField(*NewEthTypeField(0x0800))
+		ofMatch.AddField(tcpFlags)
+		ofMatch.AddField(*NewIpv4DstField(net.IP{10, 0, 0, 1}, nil))
+		data, err := ofMatch.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal match: %v", err)
+		}
+
+		newMatch := new(Match)
+		if err := newMatch.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Failed to unmarshal match: %v", err)
+		}
+		if len(newMatch.Fields) != 3 {
+			t.Fatalf("Expected 3 fields, got %d", len(newMatch.Fields))
+		}
+		if ethType := newMatch.Fields[0].Value.(*EthTypeField); newMatch.Fields[0].Field != OXM_FIELD_ETH_TYPE || ethType.EthType != 0x0800 {
+			t.Errorf("Unexpected eth_type field: %+v", newMatch.Fields[0])
+		}
+		flags := newMatch.Fields[1]
+		if flags.Class != OXM_CLASS_EXPERIMENTER || flags.ExperimenterID != ONF_EXPERIMENTER_ID || flags.Value.(*TcpFlagsField).TcpFlags != 0x12 {
+			t.Errorf("Unexpected tcp_flags field: %+v", flags)
+		}
+		if tcpFlags.HasMask && (!flags.HasMask || flags.Mask.(*TcpFlagsField).TcpFlags != flagsMask) {
+			t.Errorf("Unexpected tcp_flags mask: %+v", flags.Mask)
+		}
+		if ipDst := newMatch.Fields[2].Value.(*Ipv4DstField); newMatch.Fields[2].Field != OXM_FIELD_IPV4_DST || !ipDst.Ipv4Dst.Equal(net.IP{10, 0, 0, 1}) {
+			t.Errorf("Unexpected ipv4_dst field: %+v", newMatch.Fields[2])
+		}
+		if newData, err := newMatch.MarshalBinary(); err != nil || !bytes.Equal(data, newData) {
+			t.Errorf("Match doesn't round-trip, expected %x, got %x (%v)", data, newData, err)
+		}
+	}
+}
+
+func TestMatchUnmarshalTruncated(t *testing.T) {
+	mask := net.IP{255, 255, 255, 0}
+	ofMatch := NewMatchBuilder().
+		AddField(*NewEthTypeField(0x0800)).
+		AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &mask)).
+		AddField(*NewTcpDstFieldMasked(0x8000, 0xf000)).
+		AddField(*NewCTLabelMatchField([16]byte{15: 1}, nil)).
+		AddField(*NewTunMetadataField(2, []byte{1, 2, 3, 4}, nil)).
+		AddField(*newONFTcpFlagsField(0x12, nil)).
+		WithImplicitPrerequisites().
+		Build()
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+
+	for name, hexString := range testutil.MalformedMatchHexes {
+		if err := testutil.UnmarshalNoPanic(t, new(Match), testutil.DecodeHex(t, hexString)); err == nil {
+			t.Errorf("Expected an error when unmarshaling the match with %s", name)
+		}
+	}
+	for _, truncated := range testutil.Truncations(data, 2) {
+		// The truncations whose declared length exceeds the buffer must be rejected.
+		if err := testutil.UnmarshalNoPanic(t, new(Match), truncated); err == nil && (len(truncated) < 4 || int(binary.BigEndian.Uint16(truncated[2:])) > len(truncated)) {
+			t.Errorf("Expected an error when unmarshaling %d bytes of the match", len(truncated))
+		}
+	}
+	for _, corrupted := range testutil.Corruptions(data, 1000, 1) {
+		testutil.UnmarshalNoPanic(t, new(Match), corrupted)
+	}
+}
+
+func TestDistinguishingFields(t *testing.T) {
+	// TCP SYNs from 10.0.0.1:34567 to 10.0.0.2:80 and 10.0.0.2:443.
+	decode := func(frameHex string) []util.Message {
+		frame := testutil.DecodeHex(t, frameHex)
+		eth := new(protocol.Ethernet)
+		if err := eth.UnmarshalBinary(frame); err != nil {
+			t.Fatalf("Failed to decode frame: %v", err)
+		}
+		ip := eth.Data.(*protocol.IPv4)
+		tcp := new(protocol.TCP)
+		if err := tcp.UnmarshalBinary(ip.Data.(*util.Buffer).Bytes()); err != nil {
+			t.Fatalf("Failed to decode TCP header: %v", err)
+		}
+		return []util.Message{eth, ip, tcp}
+	}
+	prefix := "aabbccddeeff" + "112233445566" + "0800" + "450000280001000040060000" + "0a000001" + "0a000002" + "8707"
+	suffix := "00000001" + "00000000" + "50020000" + "00000000"
+	packetA := decode(prefix + "0050" + suffix)
+	packetB := decode(prefix + "01bb" + suffix)
+
+	names, err := DistinguishingFields(packetA, packetB)
+	if err != nil {
+		t.Fatalf("Failed to get distinguishing fields: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"tcp_dst"}) {
+		t.Errorf("Unexpected distinguishing fields: %v", names)
+	}
+	if names, _ := DistinguishingFields(packetA, packetA); len(names) != 0 {
+		t.Errorf("Expected no distinguishing fields for the same packet, got %v", names)
+	}
+	// The packet without TCP layer doesn't have the TCP ports, and has ip_proto from the IP header.
+	names, _ = DistinguishingFields(packetA, packetB[:2])
+	if !reflect.DeepEqual(names, []string{"tcp_dst", "tcp_src"}) {
+		t.Errorf("Unexpected distinguishing fields: %v", names)
+	}
+	if _, err := DistinguishingFields(packetA, packetB[2:]); err == nil {
+		t.Errorf("Expected an error when the IP layer is missing")
+	}
+}
+
+func TestMatchLength(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		hexString string
+		fields    int
+		length    uint16
+		wantErr   bool
+	}{
+		{name: "complete", hexString: testutil.MatchHex, fields: 3, length: 21},
+		// The match is reset when a field fails to decode in strict mode.
+		{name: "field value cut", hexString: testutil.TruncatedMatchHex, length: 4, wantErr: true},
+		{name: "length beyond data", hexString: testutil.MalformedMatchHexes["length beyond data"], length: 4, wantErr: true},
+	} {
+		decoded := new(Match)
+		err := decoded.UnmarshalBinary(testutil.DecodeHex(t, tc.hexString))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if len(decoded.Fields) != tc.fields || decoded.Length != tc.length {
+			t.Errorf("%s: expected %d fields and Length %d, got %d fields and Length %d", tc.name, tc.fields, tc.length, len(decoded.Fields), decoded.Length)
+		}
+	}
+
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	ofMatch.AddField(*NewIpProtoField(6))
+	ofMatch.AddField(*NewTcpDstField(80))
+	if ofMatch.Length != 21 || ofMatch.Len() != 24 {
+		t.Fatalf("Unexpected Length %d and Len %d of the match", ofMatch.Length, ofMatch.Len())
+	}
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	if !bytes.Equal(data, testutil.DecodeHex(t, testutil.MatchHex)) {
+		t.Errorf("Unexpected marshaled match %x", data)
+	}
+	ofMatch.Fields = ofMatch.Fields[:2]
+	ofMatch.RecomputeLength()
+	if ofMatch.Length != 15 || ofMatch.Len() != 16 {
+		t.Errorf("Unexpected Length %d and Len %d after removing a field", ofMatch.Length, ofMatch.Len())
+	}
+}
+
+func TestMatchFieldToOxmId(t *testing.T) {
+	mask := net.ParseIP("255.255.255.0").To4()
+	field := NewIpv4SrcField(net.ParseIP("10.0.0.0"), &mask)
+	expected := &OxmId{
+		Class:   OXM_CLASS_OPENFLOW_BASIC,
+		Field:   OXM_FIELD_IPV4_SRC,
+		HasMask: true,
+		Length:  8,
+	}
+	if oxmId := field.ToOxmId(); *oxmId != *expected {
+		t.Errorf("Unexpected OxmId: %+v, expected: %+v", oxmId, expected)
+	}
+}
+
+func TestNewMaskedIPField(t *testing.T) {
+	mask := net.ParseIP("255.255.255.0")
+	field, err := NewMaskedIPField(OXM_FIELD_IPV4_DST, net.ParseIP("10.0.0.1"), mask, true)
+	if err != nil {
+		t.Fatalf("Failed to create masked ipv4_dst field: %v", err)
+	}
+	if field.String() != "ipv4_dst=10.0.0.0/255.255.255.0" {
+		t.Errorf("Unexpected masked ipv4_dst field with mask applied: %s", field.String())
+	}
+	field, _ = NewMaskedIPField(OXM_FIELD_IPV4_DST, net.ParseIP("10.0.0.1"), mask, false)
+	if field.String() != "ipv4_dst=10.0.0.1/255.255.255.0" {
+		t.Errorf("Unexpected masked ipv4_dst field without mask applied: %s", field.String())
+	}
+	if !field.HasMask || field.Length != 8 {
+		t.Errorf("Unexpected masked ipv4_dst field header: %+v", field)
+	}
+
+	ipv6Mask := net.ParseIP("ffff:ffff:ffff:ffff::")
+	field, err = NewMaskedIPField(OXM_FIELD_IPV6_SRC, net.ParseIP("2001:db8::1"), ipv6Mask, true)
+	if err != nil {
+		t.Fatalf("Failed to create masked ipv6_src field: %v", err)
+	}
+	if ip := field.Value.(*Ipv6SrcField).Ipv6Src; !ip.Equal(net.ParseIP("2001:db8::")) {
+		t.Errorf("Unexpected masked ipv6_src value: %v", ip)
+	}
+
+	if _, err := NewMaskedIPField(OXM_FIELD_IPV6_SRC, net.ParseIP("10.0.0.1"), mask, true); err == nil {
+		t.Errorf("Expected an error for an IPv4 address in ipv6_src")
+	}
+	if _, err := NewMaskedIPField(OXM_FIELD_TCP_DST, net.ParseIP("10.0.0.1"), mask, true); err == nil {
+		t.Errorf("Expected an error for a non-IP field")
+	}
+	// The error shows the rejected input rather than the result of the failed conversion.
+	_, err = NewMaskedIPField(OXM_FIELD_IPV4_DST, net.ParseIP("2001:db8::1"), nil, true)
+	if err == nil || !strings.Contains(err.Error(), "net.IP{0x20, 0x1, 0xd, 0xb8,") || !strings.Contains(err.Error(), "net.IP(nil)") {
+		t.Errorf("Expected an error showing the IPv6 address and the nil mask, got %v", err)
+	}
+}
+
+func TestNewMaskedEthField(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	mask, _ := net.ParseMAC("ff:ff:ff:00:00:00")
+	field, err := NewMaskedEthField(OXM_FIELD_ETH_SRC, mac, mask, true)
+	if err != nil {
+		t.Fatalf("Failed to create masked eth_src field: %v", err)
+	}
+	if field.String() != "eth_src=aa:bb:cc:00:00:00/ff:ff:ff:00:00:00" {
+		t.Errorf("Unexpected masked eth_src field with mask applied: %s", field.String())
+	}
+	if mac.String() != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("The input MAC address is modified: %s", mac)
+	}
+	field, _ = NewMaskedEthField(OXM_FIELD_ETH_DST, mac, mask, false)
+	if field.String() != "eth_dst=aa:bb:cc:dd:ee:ff/ff:ff:ff:00:00:00" {
+		t.Errorf("Unexpected masked eth_dst field without mask applied: %s", field.String())
+	}
+	if _, err := NewMaskedEthField(OXM_FIELD_ETH_DST, mac[:4], mask, false); err == nil || !strings.Contains(err.Error(), "net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd}") {
+		t.Errorf("Expected an error showing the truncated MAC address, got %v", err)
+	}
+}
+
+func TestMatchValidateInPhyPort(t *testing.T) {
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewInPhyPortField(1))
+	if err := ofMatch.Validate(); err == nil {
+		t.Errorf("Expected an error for in_phy_port without in_port")
+	}
+	ofMatch.AddField(*NewInPortField(2))
+	if err := ofMatch.Validate(); err != nil {
+		t.Errorf("Unexpected error for in_phy_port with in_port: %v", err)
+	}
+}
+
+func TestMatchValidatePacketType(t *testing.T) {
+	ethDst := NewEthDstField(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, nil)
+	for _, tc := range []struct {
+		name        string
+		match       *Match
+		expectedErr string
+	}{
+		{
+			name:  "eth_dst without packet_type",
+			match: NewMatchBuilder().AddField(*ethDst).Build(),
+		},
+		{
+			name: "eth_dst with Ethernet packet_type",
+			match: NewMatchBuilder().
+				AddField(*NewPacketTypeField(OFPHTN_ONF, OFPHTO_ETHERNET)).
+				AddField(*ethDst).
+				Build(),
+		},
+		{
+			name: "ipv4_dst with raw IPv4 packet_type",
+			match: NewMatchBuilder().
+				AddField(*NewPacketTypeField(OFPHTN_ETHERTYPE, 0x0800)).
+				AddField(*NewIpv4DstField(net.IP{10, 0, 0, 1}, nil)).
+				Build(),
+		},
+		{
+			name: "eth_dst with raw IPv4 packet_type",
+			match: NewMatchBuilder().
+				AddField(*NewPacketTypeField(OFPHTN_ETHERTYPE, 0x0800)).
+				AddField(*ethDst).
+				Build(),
+			expectedErr: "eth_dst is not allowed with non-Ethernet packet_type (1,0x800)",
+		},
+		{
+			name: "NXM vlan_tci with raw IPv4 packet_type",
+			match: NewMatchBuilder().
+				AddField(*NewPacketTypeField(OFPHTN_ETHERTYPE, 0x0800)).
+				AddField(MatchField{Class: OXM_CLASS_NXM_0, Field: NXM_OF_VLAN_TCI, Length: 2, Value: newUint16Message(0x1001)}).
+				Build(),
+			expectedErr: "vlan_tci is not allowed",
+		},
+	} {
+		err := tc.match.Validate()
+		if tc.expectedErr == "" && err != nil {
+			t.Errorf("Unexpected error for %s: %v", tc.name, err)
+		} else if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+			t.Errorf("Expected error %q for %s, got %v", tc.expectedErr, tc.name, err)
+		}
+	}
+}
+
+func TestActsetOutputReservedPorts(t *testing.T) {
+	field := NewActsetOutputUnsetField()
+	if field.String() != "actset_output=UNSET" {
+		t.Errorf("Unexpected actset_output field: %s", field.String())
+	}
+	data, _ := field.MarshalBinary()
+	if expectData := testutil.DecodeHex(t, "80005604fffffff7"); !bytes.Equal(data, expectData) {
+		t.Errorf("Unexpected actset_output field bytes, expect: %x, actual: %x", expectData, data)
+	}
+
+	field, err := NewActsetOutputReservedField(P_CONTROLLER)
+	if err != nil {
+		t.Fatalf("Failed to create actset_output field: %v", err)
+	}
+	if field.String() != "actset_output=CONTROLLER" {
+		t.Errorf("Unexpected actset_output field: %s", field.String())
+	}
+	data, _ = field.MarshalBinary()
+	if expectData := testutil.DecodeHex(t, "80005604fffffffd"); !bytes.Equal(data, expectData) {
+		t.Errorf("Unexpected actset_output field bytes, expect: %x, actual: %x", expectData, data)
+	}
+
+	if _, err := NewActsetOutputReservedField(10); err == nil {
+		t.Errorf("Expected an error for a non-reserved port")
+	}
+	if field := NewActsetOutputField(10); field.String() != "actset_output=10" {
+		t.Errorf("Unexpected actset_output field: %s", field.String())
+	}
+}
+
+func TestMatchSupportedIn(t *testing.T) {
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	ofMatch.AddField(*NewIpProtoField(6))
+	ofMatch.AddField(*NewTunnelIdField(1))
+	ofMatch.AddField(*NewRegMatchField(1, 2, nil))
+	for _, version := range []uint8{ofVersion13, ofVersion14, VERSION} {
+		if supported, unsupported := ofMatch.SupportedIn(version); !supported || len(unsupported) != 0 {
+			t.Errorf("Expected the match to be supported in version %d, unsupported fields: %v", version, unsupported)
+		}
+	}
+	if supported, _ := ofMatch.SupportedIn(ofVersion12); supported {
+		t.Errorf("Expected tunnel_id not to be supported in version %d", ofVersion12)
+	}
+
+	ofMatch.AddField(*NewTcpFlagsField(0x2, nil))
+	ofMatch.AddField(*NewActsetOutputField(1))
+	supported, unsupported := ofMatch.SupportedIn(ofVersion13)
+	if supported || len(unsupported) != 2 || unsupported[0].Field != OXM_FIELD_TCP_FLAGS || unsupported[1].Field != OXM_FIELD_ACTSET_OUTPUT {
+		t.Errorf("Expected tcp_flags and actset_output not to be supported in OpenFlow 1.3, got %v", unsupported)
+	}
+	if supported, _ := ofMatch.SupportedIn(VERSION); !supported {
+		t.Errorf("Expected the match to be supported in OpenFlow 1.5")
+	}
+}
+
+func TestMatchMinVersion(t *testing.T) {
+	fiveTuple := newFiveTupleMatch(t, "10.0.0.1", "10.0.0.2", protocol.Type_TCP, 34567, 80)
+	fiveTuple.AddField(*NewRegMatchField(1, 2, nil))
+	if version := fiveTuple.MinVersion(); version != ofVersion12 {
+		t.Errorf("Expected a 5-tuple match to require OpenFlow 1.2, got %d", version)
+	}
+	if supported, _ := fiveTuple.SupportedIn(ofVersion13); !supported {
+		t.Errorf("Expected a 5-tuple match to be supported in OpenFlow 1.3")
+	}
+
+	for _, tc := range []struct {
+		field    *MatchField
+		expected uint8
+	}{
+		{NewTunnelIdField(1), ofVersion13},
+		{NewPbbUcaField(1), ofVersion14},
+		{NewTcpFlagsField(0x2, nil), VERSION},
+	} {
+		ofMatch := newFiveTupleMatch(t, "10.0.0.1", "10.0.0.2", protocol.Type_TCP, 34567, 80)
+		ofMatch.AddField(*tc.field)
+		if version := ofMatch.MinVersion(); version != tc.expected {
+			t.Errorf("Expected a match with %s to require version %d, got %d", tc.field, tc.expected, version)
+		}
+		if supported, _ := ofMatch.SupportedIn(tc.expected - 1); supported {
+			t.Errorf("Expected a match with %s not to be supported in version %d", tc.field, tc.expected-1)
+		}
+	}
+}
+
+func TestMatchSpecificityScore(t *testing.T) {
+	newMatch := func(prefixLen int) *Match {
+		mask := net.IP(net.CIDRMask(prefixLen, 32))
+		ofMatch := NewMatch()
+		ofMatch.AddField(*NewEthTypeField(0x0800))
+		ofMatch.AddField(*NewIpv4DstField(net.IP{10, 0, 0, 0}, &mask))
+		return ofMatch
+	}
+	wide, narrow := newMatch(8), newMatch(24)
+	// 16 bits of eth_type plus the prefix length.
+	if score := wide.SpecificityScore(); score != 24 {
+		t.Errorf("Expected score 24 for ipv4_dst=10.0.0.0/8, got %d", score)
+	}
+	if score := narrow.SpecificityScore(); score != 40 {
+		t.Errorf("Expected score 40 for ipv4_dst=10.0.0.0/24, got %d", score)
+	}
+	exact := NewMatch()
+	exact.AddField(*NewEthTypeField(0x0800))
+	exact.AddField(*NewIpv4DstField(net.IP{10, 0, 0, 1}, nil))
+	if score := exact.SpecificityScore(); score != 48 {
+		t.Errorf("Expected score 48 for ipv4_dst=10.0.0.1, got %d", score)
+	}
+	if score := NewMatch().SpecificityScore(); score != 0 {
+		t.Errorf("Expected score 0 for an empty match, got %d", score)
+	}
+}
+
+func TestPbbIsidAndUcaFields(t *testing.T) {
+	isidMask := uint32(0xffff00)
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x88e7))
+	ofMatch.AddField(*NewPbbIsidField(0x123400, &isidMask))
+	ofMatch.AddField(*NewPbbUcaField(1))
+	if err := checkMatchSerializationConsistency(ofMatch); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range ofMatch.Fields[1:] {
+		data, _ := f.MarshalBinary()
+		if int(f.Length)+4 != len(data) {
+			t.Errorf("Length of field %d is %d, but the payload has %d bytes", f.Field, f.Length, len(data)-4)
+		}
+	}
+
+	data, _ := ofMatch.MarshalBinary()
+	newMatch := new(Match)
+	if err := newMatch.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal match: %v", err)
+	}
+	isid := newMatch.Fields[1]
+	if !isid.HasMask || isid.Value.(*PbbIsidField).PbbIsid != 0x123400 || isid.Mask.(*PbbIsidField).PbbIsid != isidMask {
+		t.Errorf("Unexpected pbb_isid field: %v", isid.String())
+	}
+	if uca := newMatch.Fields[2]; uca.HasMask || uca.Value.(*PbbUcaField).PbbUca != 1 {
+		t.Errorf("Unexpected pbb_uca field: %v", uca.String())
+	}
+	if !strings.HasSuffix(newMatch.String(), ",pbb_uca=0x01") {
+		t.Errorf("Unexpected match: %s", newMatch.String())
+	}
+}
+
+func TestPbbIsidFieldEncoding(t *testing.T) {
+	field := NewPbbIsidField(0xabcdef, nil)
+	data, err := field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal pbb_isid field: %v", err)
+	}
+	if expectData := testutil.DecodeHex(t, "80004a03abcdef"); !bytes.Equal(data, expectData) {
+		t.Errorf("Unexpected pbb_isid field bytes, expect: %x, actual: %x", expectData, data)
+	}
+
+	mask := uint32(0xfff000)
+	field = NewPbbIsidField(0xabc000, &mask)
+	data, err = field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal masked pbb_isid field: %v", err)
+	}
+	if expectData := testutil.DecodeHex(t, "80004b06abc000fff000"); !bytes.Equal(data, expectData) {
+		t.Errorf("Unexpected masked pbb_isid field bytes, expect: %x, actual: %x", expectData, data)
+	}
+	newField := new(MatchField)
+	if err := newField.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal masked pbb_isid field: %v", err)
+	}
+	if newField.String() != "pbb_isid=0xabc000/0xfff000" {
+		t.Errorf("Unexpected pbb_isid field: %s", newField.String())
+	}
+}
+
+func TestMatchCanExpress(t *testing.T) {
+	mask := net.ParseIP("255.255.255.0").To4()
+	subnet := NewIpv4DstField(net.ParseIP("10.0.0.0"), &mask)
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+
+	if err := ofMatch.CanExpress(MatchConstraint{Field: subnet}); err != nil {
+		t.Errorf("Unexpected error for ipv4_dst in 10.0.0.0/24: %v", err)
+	}
+	// A mask only selects the compared bits, so "ipv4_dst not in 10.0.0.0/24" can't be expressed.
+	if err := ofMatch.CanExpress(MatchConstraint{Field: subnet, Negated: true}); !errors.Is(err, ErrNegatedMatch) {
+		t.Errorf("Expected ErrNegatedMatch for ipv4_dst not in 10.0.0.0/24, got %v", err)
+	}
+
+	ofMatch.AddField(*subnet)
+	if err := ofMatch.CanExpress(MatchConstraint{Field: subnet}); err != nil {
+		t.Errorf("Unexpected error for the existing ipv4_dst: %v", err)
+	}
+	if err := ofMatch.CanExpress(MatchConstraint{Field: NewIpv4DstField(net.ParseIP("10.0.1.0"), &mask)}); err == nil {
+		t.Errorf("Expected an error for ipv4_dst conflicting with the existing one")
+	}
+}
+
+func TestMatchFieldUnmarshalTruncatedMask(t *testing.T) {
+	mask := net.ParseIP("255.255.255.0").To4()
+	data, _ := NewIpv4DstField(net.ParseIP("10.0.0.0"), &mask).MarshalBinary()
+	field := new(MatchField)
+	if err := field.UnmarshalBinary(data[:8]); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected io.ErrShortBuffer for a masked field without mask bytes, got %v", err)
+	}
+	if err := field.UnmarshalBinary(data[:10]); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected io.ErrShortBuffer for a masked field with partial mask bytes, got %v", err)
+	}
+}
+
+func TestMatchFieldExperimenter(t *testing.T) {
+	// tcp_flags=0x12 as an ONF experimenter field.
+	data := testutil.DecodeHex(t, "ffff5406"+"4f4e4600"+"0012")
+	field := new(MatchField)
+	if err := field.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal experimenter field: %v", err)
+	}
+	if !field.IsExperimenter() || field.ExperimenterName() != "ONF" {
+		t.Errorf("Unexpected experimenter of field: %v %s", field.IsExperimenter(), field.ExperimenterName())
+	}
+	field.ExperimenterID = 0x12345678
+	if field.ExperimenterName() != "0x12345678" {
+		t.Errorf("Unexpected name of unknown experimenter: %s", field.ExperimenterName())
+	}
+
+	field = NewTcpFlagsField(0x12, nil)
+	if field.IsExperimenter() || field.ExperimenterName() != "" {
+		t.Errorf("Unexpected experimenter of basic field: %v %s", field.IsExperimenter(), field.ExperimenterName())
+	}
+}
+
+func TestEmptyMatch(t *testing.T) {
+	ofMatch := NewMatch()
+	if ofMatch.Len() != 8 {
+		t.Errorf("Len() of empty match is %d, expected 8", ofMatch.Len())
+	}
+	data, err := ofMatch.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal empty match: %v", err)
+	}
+	if expectData := []byte{0, 1, 0, 4, 0, 0, 0, 0}; !bytes.Equal(data, expectData) {
+		t.Errorf("Unexpected empty match bytes, expect: %x, actual: %x", expectData, data)
+	}
+
+	// The pad bytes must not be decoded as a field even if they are not zero.
+	newMatch := new(Match)
+	if err := newMatch.UnmarshalBinary([]byte{0, 1, 0, 4, 0xff, 0xff, 0xff, 0xff}); err != nil {
+		t.Fatalf("Failed to unmarshal empty match: %v", err)
+	}
+	if newMatch.Type != MatchType_OXM || newMatch.Length != 4 || len(newMatch.Fields) != 0 {
+		t.Errorf("Unexpected unmarshaled empty match: %+v", newMatch)
+	}
+	if err := checkMatchSerializationConsistency(ofMatch); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMatchBuilderImplicitPrerequisites(t *testing.T) {
+	m := NewMatchBuilder().AddField(*NewTcpDstField(80)).WithImplicitPrerequisites().Build()
+	if len(m.Fields) != 3 {
+		t.Fatalf("Expected 3 fields, got %d: %s", len(m.Fields), m)
+	}
+	ethType, ok := m.Fields[0].Value.(*EthTypeField)
+	if !ok || m.Fields[0].Field != OXM_FIELD_ETH_TYPE || ethType.EthType != protocol.IPv4_MSG {
+		t.Errorf("Expected eth_type=0x0800 as the first field, got %s", &m.Fields[0])
+	}
+	ipProto, ok := m.Fields[1].Value.(*IpProtoField)
+	if !ok || m.Fields[1].Field != OXM_FIELD_IP_PROTO || ipProto.Protocol != protocol.Type_TCP {
+		t.Errorf("Expected ip_proto=6 as the second field, got %s", &m.Fields[1])
+	}
+	if m.Fields[2].Field != OXM_FIELD_TCP_DST {
+		t.Errorf("Expected tcp_dst as the last field, got %s", &m.Fields[2])
+	}
+	if err := checkMatchSerializationConsistency(m); err != nil {
+		t.Errorf("Match serialization is inconsistent: %v", err)
+	}
+
+	// Explicit prerequisites are kept, and the IP version is taken from the other fields.
+	m = NewMatchBuilder().
+		AddField(*NewIpv6DstField(net.ParseIP("2001:db8::1"), nil)).
+		AddField(*NewIpProtoField(protocol.Type_UDP)).
+		AddField(*NewUdpDstField(53)).
+		WithImplicitPrerequisites().Build()
+	if len(m.Fields) != 4 {
+		t.Fatalf("Expected 4 fields, got %d: %s", len(m.Fields), m)
+	}
+	if ethType, ok := m.Fields[0].Value.(*EthTypeField); !ok || ethType.EthType != protocol.IPv6_MSG {
+		t.Errorf("Expected eth_type=0x86dd as the first field, got %s", &m.Fields[0])
+	}
+
+	// Nothing is injected without WithImplicitPrerequisites.
+	m = NewMatchBuilder().AddField(*NewTcpDstField(80)).Build()
+	if len(m.Fields) != 1 {
+		t.Errorf("Expected 1 field, got %d: %s", len(m.Fields), m)
+	}
+}
+
+func TestMacFieldsRoundTrip(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	for _, f := range []*MatchField{
+		NewEthDstField(mac, nil),
+		NewEthSrcField(mac, nil),
+		NewArpShaField(mac),
+		NewArpThaField(mac),
+	} {
+		data, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", f, err)
+		}
+		decoded := new(MatchField)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", f, err)
+		}
+		var got net.HardwareAddr
+		switch v := decoded.Value.(type) {
+		case *EthDstField:
+			got = v.EthDst
+		case *EthSrcField:
+			got = v.EthSrc
+		case *ArpXHaField:
+			got = v.ArpHa
+		default:
+			t.Fatalf("Unexpected value type %T for %s", v, f)
+		}
+		if !bytes.Equal(got, mac) {
+			t.Errorf("Unexpected MAC for %s, expected %s, got %s", f, mac, got)
+		}
+	}
+}
+
+func TestMatchFieldValueShortBuffer(t *testing.T) {
+	for _, value := range []util.Message{
+		new(InPortField),
+		new(InPhyPortField),
+		new(EthDstField),
+		new(EthSrcField),
+		new(EthTypeField),
+		new(VlanIdField),
+		new(VlanPcpField),
+		new(MplsLabelField),
+		new(MplsTcField),
+		new(MplsBosField),
+		new(Ipv4SrcField),
+		new(Ipv4DstField),
+		new(Ipv6SrcField),
+		new(Ipv6FLabelField),
+		new(Ipv6DstField),
+		new(IpEcnField),
+		new(IpProtoField),
+		new(IpDscpField),
+		new(PbbIsidField),
+		new(PbbUcaField),
+		new(TunnelIdField),
+		new(MetadataField),
+		new(PortField),
+		new(Ipv6ExtHdrField),
+		new(TcpFlagsField),
+		new(ArpOperField),
+		new(TunnelIpv4SrcField),
+		new(TunnelIpv4DstField),
+		new(TtlField),
+		new(ArpXHaField),
+		new(ArpXPaField),
+		new(ActsetOutputField),
+		new(Uint8Message),
+		new(Uint16Message),
+		new(Uint32Message),
+		new(Uint64Message),
+		&ByteArrayField{Length: 16},
+	} {
+		data := make([]byte, value.Len()-1)
+		if err := value.UnmarshalBinary(data); err == nil {
+			t.Errorf("Expected an error when unmarshaling %T from %d bytes", value, len(data))
+		}
+	}
+}
+
+func TestMatchFieldTextRoundTrip(t *testing.T) {
+	ipMask := net.IP{255, 255, 255, 0}
+	ethMask := net.HardwareAddr{0xff, 0xff, 0xff, 0, 0, 0}
+	oxmMatch := NewMatch()
+	oxmMatch.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask))
+	oxmMatch.AddField(*NewEthSrcField(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, nil))
+	nxmMatch := oxmMatch.ToNXM()
+	for _, tc := range []struct {
+		field *MatchField
+		text  string
+	}{
+		{field: NewIpv4SrcField(net.IP{10, 0, 0, 1}, nil), text: "ipv4_src=10.0.0.1"},
+		{field: NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask), text: "ipv4_src=10.0.0.0/24"},
+		{field: NewEthDstField(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, &ethMask), text: "eth_dst=00:11:22:33:44:55/ff:ff:ff:00:00:00"},
+		{field: NewIpProtoField(6), text: "ip_proto=tcp"},
+		{field: NewTcpDstField(80), text: "tcp_dst=80"},
+		{field: NewEthTypeField(0x0800), text: "eth_type=0x0800"},
+		{field: NewActsetOutputField(P_CONTROLLER), text: "actset_output=CONTROLLER"},
+		{field: NewRegMatchFieldWithMask(1, 0x5, 0xf), text: "reg1=0x5/0xf"},
+		// NXM fields whose short names are decoded as OXM fields keep their full names.
+		{field: &nxmMatch.Fields[0], text: "NXM_OF_IP_SRC=10.0.0.0/24"},
+		{field: &nxmMatch.Fields[1], text: "NXM_OF_ETH_SRC=00:11:22:33:44:55"},
+	} {
+		text, err := tc.field.MarshalText()
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", tc.field, err)
+		}
+		if string(text) != tc.text {
+			t.Errorf("Unexpected text, expected %s, got %s", tc.text, text)
+		}
+		decoded := new(MatchField)
+		if err := decoded.UnmarshalText(text); err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", text, err)
+		}
+		if decoded.Class != tc.field.Class || decoded.Field != tc.field.Field ||
+			fieldFingerprint(decoded) != fieldFingerprint(tc.field) || decoded.Length != tc.field.Length {
+			t.Errorf("Unexpected MatchField decoded from %s, expected %s, got %s", text, tc.field, decoded)
+		}
+	}
+
+	// A mask in the address format is accepted as well.
+	decoded := new(MatchField)
+	if err := decoded.UnmarshalText([]byte("ipv4_dst=10.0.0.0/255.255.255.0")); err != nil {
+		t.Fatalf("Failed to unmarshal masked ipv4_dst: %v", err)
+	}
+	if expected := NewIpv4DstField(net.IP{10, 0, 0, 0}, &ipMask); fieldFingerprint(decoded) != fieldFingerprint(expected) {
+		t.Errorf("Unexpected MatchField, expected %s, got %s", expected, decoded)
+	}
+	for _, text := range []string{"ipv4_src", "foo=1", "ipv4_src=2001:db8::1", "tcp_dst=65536", "ipv4_src=10.0.0.0/33"} {
+		if err := new(MatchField).UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("Expected an error when unmarshaling %q", text)
+		}
+	}
+}
+
+func TestMatchEqual(t *testing.T) {
+	ipMask := net.IP{255, 255, 255, 0}
+	allOnes := net.IP{255, 255, 255, 255}
+	m1 := NewMatch()
+	m1.AddField(*NewEthTypeField(0x0800))
+	m1.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask))
+	m1.AddField(*NewIpv4DstField(net.IP{10, 0, 1, 1}, nil))
+
+	// Reordered fields, and ipv4_dst with an all-ones mask.
+	m2 := NewMatch()
+	m2.AddField(*NewIpv4DstField(net.IP{10, 0, 1, 1}, &allOnes))
+	m2.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask))
+	m2.AddField(*NewEthTypeField(0x0800))
+	if !m1.Equal(m2) || !m2.Equal(m1) {
+		t.Errorf("Expected %s to be equal to %s", m1, m2)
+	}
+
+	// A different mask.
+	m3 := NewMatch()
+	m3.AddField(*NewEthTypeField(0x0800))
+	m3.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &allOnes))
+	m3.AddField(*NewIpv4DstField(net.IP{10, 0, 1, 1}, nil))
+	if m1.Equal(m3) {
+		t.Errorf("Expected %s not to be equal to %s", m1, m3)
+	}
+
+	// A different value, and a duplicated field.
+	m4 := NewMatch()
+	m4.AddField(*NewEthTypeField(0x0800))
+	m4.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask))
+	m4.AddField(*NewIpv4DstField(net.IP{10, 0, 1, 2}, nil))
+	m5 := NewMatch()
+	m5.AddField(*NewEthTypeField(0x0800))
+	m5.AddField(*NewEthTypeField(0x0800))
+	m5.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask))
+	for _, m := range []*Match{m4, m5, NewMatch(), nil} {
+		if m1.Equal(m) {
+			t.Errorf("Expected %s not to be equal to %s", m1, m)
+		}
+	}
+	var nilMatch *Match
+	if !nilMatch.Equal(nil) || nilMatch.Equal(m1) {
+		t.Errorf("Expected a nil Match to be only equal to nil")
+	}
+
+	// The same fields in the NXM classes.
+	nxmMatch := m1.ToNXM()
+	if !m1.Equal(nxmMatch) || !nxmMatch.Equal(m2) {
+		t.Errorf("Expected %s to be equal to its NXM translation", m1)
+	}
+}
+
+func TestMatchRelease(t *testing.T) {
+	m := NewMatchBuilder().
+		AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 1}, nil)).
+		AddField(*NewTcpDstField(80)).
+		WithImplicitPrerequisites().Build()
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	// The values released from a decoded Match are reset before they are reused.
+	for i := 0; i < 3; i++ {
+		decoded := new(Match)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Failed to unmarshal match: %v", err)
+		}
+		if !decoded.Equal(m) {
+			t.Errorf("Unexpected decoded match, expected %s, got %s", m, decoded)
+		}
+		decoded.Release()
+		if len(decoded.Fields) != 0 {
+			t.Errorf("Expected no fields in the released match, got %d", len(decoded.Fields))
+		}
+	}
+}
+
+// testValue is a util.Message of a type not decoded by DecodeMatchField.
+type testValue struct {
+	data uint32
+}
+
+func (v *testValue) Len() uint16 {
+	return 4
+}
+
+func (v *testValue) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, v.data), nil
+}
+
+func (v *testValue) UnmarshalBinary(data []byte) error {
+	v.data = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+func TestMatchReleaseOwnership(t *testing.T) {
+	// A value of a type which is not decoded by DecodeMatchField is not reset or pooled.
+	foreign := &testValue{data: 1}
+	m := NewMatch()
+	m.AddField(MatchField{Class: OXM_CLASS_NXM_1, Field: NXM_NX_REG0, Length: 4, Value: foreign})
+	m.Release()
+	if foreign.data != 1 {
+		t.Errorf("Value of a type not decoded by DecodeMatchField is reset by Release")
+	}
+
+	// A value referenced by multiple fields is pooled once, so it is not handed out twice.
+	shared := getFieldValue[Uint32Message]()
+	shared.Data = 2
+	field := MatchField{Class: OXM_CLASS_NXM_1, Field: NXM_NX_REG0, Length: 4, Value: shared}
+	m = NewMatch()
+	m.AddField(field)
+	m.AddField(field)
+	m.Release()
+	first, second := getFieldValue[Uint32Message](), getFieldValue[Uint32Message]()
+	if first == second {
+		t.Errorf("The same value is returned twice by the pool")
+	}
+}
+
+// newFlowDumpMatches returns the serialized matches of a flow dump with n flows.
+func newFlowDumpMatches(n int) [][]byte {
+	matches := make([][]byte, n)
+	for i := range matches {
+		m := NewMatchBuilder().
+			AddField(*NewInPortField(uint32(i % 64))).
+			AddField(*NewEthDstField(net.HardwareAddr{0, 0x11, 0x22, 0x33, byte(i >> 8), byte(i)}, nil)).
+			AddField(*NewIpv4DstField(net.IP{10, 0, byte(i >> 8), byte(i)}, nil)).
+			AddField(*NewTcpDstField(uint16(i))).
+			AddField(*NewRegMatchFieldWithMask(0, uint32(i), 0xffff)).
+			AddField(*NewCTStateMatchField(NewCTStates())).
+			WithImplicitPrerequisites().Build()
+		matches[i], _ = m.MarshalBinary()
+	}
+	return matches
+}
+
+func BenchmarkDecodeFlowDump(b *testing.B) {
+	matches := newFlowDumpMatches(50000)
+	for _, release := range []bool{false, true} {
+		b.Run(fmt.Sprintf("release=%t", release), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, data := range matches {
+					m := new(Match)
+					if err := m.UnmarshalBinary(data); err != nil {
+						b.Fatalf("Failed to unmarshal match: %v", err)
+					}
+					if release {
+						m.Release()
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMatchMarshalBinary(b *testing.B) {
+	states := NewCTStates()
+	states.SetTrk()
+	states.SetNew()
+	ofMatch := newFiveTupleMatch(b, "10.0.0.1", "10.0.0.2", protocol.Type_TCP, 34567, 80)
+	ofMatch.AddField(*NewInPortField(1))
+	ofMatch.AddField(*NewRegMatchField(1, 2, nil))
+	ofMatch.AddField(*NewCTStateMatchField(states))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ofMatch.MarshalBinary(); err != nil {
+			b.Fatalf("Failed to marshal match: %v", err)
+		}
+	}
+}
+
+func TestPacketTypeField(t *testing.T) {
+	m := NewMatch()
+	m.AddField(*NewPacketTypeField(0, 0x800))
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	decoded := new(Match)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal match: %v", err)
+	}
+	if len(decoded.Fields) != 1 {
+		t.Fatalf("Expected 1 field, got %d", len(decoded.Fields))
+	}
+	packetType, ok := decoded.Fields[0].Value.(*PacketTypeField)
+	if !ok {
+		t.Fatalf("Expected PacketTypeField, got %T", decoded.Fields[0].Value)
+	}
+	if packetType.Namespace != 0 || packetType.NsType != 0x800 {
+		t.Errorf("Unexpected packet_type, expected (0,0x800), got (%d,0x%x)", packetType.Namespace, packetType.NsType)
+	}
+}
+
+func TestUnmarshalMatchAt(t *testing.T) {
+	flowMod := NewFlowMod()
+	flowMod.Priority = 100
+	flowMod.Match.AddField(*NewInPortField(3))
+	flowMod.Match.AddField(*NewEthTypeField(0x0800))
+	flowMod.Match.AddField(*NewIpv4DstField(net.IP{10, 0, 0, 1}, nil))
+	flowMod.AddInstruction(NewInstrGotoTable(1))
+	data, err := flowMod.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal FlowMod: %v", err)
+	}
+
+	m, err := UnmarshalMatchAt(data, FlowModMatchOffset)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the Match of FlowMod: %v", err)
+	}
+	if !m.Equal(&flowMod.Match) {
+		t.Errorf("Unexpected Match, expected %s, got %s", &flowMod.Match, m)
+	}
+
+	// The Match declares more bytes than the message has.
+	if _, err := UnmarshalMatchAt(data[:FlowModMatchOffset+8], FlowModMatchOffset); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for a truncated Match, got %v", err)
+	}
+	if _, err := UnmarshalMatchAt(data, len(data)-2); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for an offset out of the message, got %v", err)
+	}
+}
+
+func TestMatchFieldWrongLength(t *testing.T) {
+	for name, data := range map[string][]byte{
+		// eth_type declaring 8 bytes, followed by another TLV.
+		"eth_type":        {0x80, 0x00, 0x0a, 0x08, 0x08, 0x00, 0x80, 0x00, 0x14, 0x01, 0x06, 0x00},
+		"masked ipv4_src": {0x80, 0x00, 0x17, 0x04, 10, 0, 0, 0, 255, 255, 255, 0},
+		"reg0":            {0x00, 0x01, 0x00, 0x02, 0, 0, 0, 1},
+	} {
+		if err := new(MatchField).UnmarshalBinary(data); err == nil || !strings.Contains(err.Error(), "invalid length") {
+			t.Errorf("Expected an invalid length error for %s, got %v", name, err)
+		}
+	}
+
+	// The declared length is used for the variable-width fields.
+	field := NewTunMetadataField(1, []byte{1, 2, 3}, nil)
+	data, _ := field.MarshalBinary()
+	decoded := new(MatchField)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Errorf("Failed to unmarshal tun_metadata1 with 3 bytes: %v", err)
+	} else if decoded.Length != 3 {
+		t.Errorf("Expected Length 3 of tun_metadata1, got %d", decoded.Length)
+	}
+
+	// A field with a Length different from its value and mask fails to marshal, rather than being
+	// truncated or zero-padded.
+	mask := net.IP{255, 255, 255, 0}
+	for name, tc := range map[string]struct {
+		field  *MatchField
+		length uint8
+	}{
+		"short eth_type":         {NewEthTypeField(0x0800), 1},
+		"long eth_type":          {NewEthTypeField(0x0800), 4},
+		"masked ipv4_src":        {NewIpv4SrcField(net.IP{10, 0, 0, 0}, &mask), 4},
+		"tun_metadata1":          {NewTunMetadataField(1, []byte{1, 2, 3}, nil), 8},
+		"experimenter tcp_flags": {newONFTcpFlagsField(0, nil), 2},
+	} {
+		tc.field.Length = tc.length
+		if _, err := tc.field.MarshalBinary(); err == nil || !strings.Contains(err.Error(), "has Length") {
+			t.Errorf("Expected a length error when marshaling %s, got %v", name, err)
+		}
+		ofMatch := NewMatch()
+		ofMatch.AddField(*tc.field)
+		if _, err := ofMatch.MarshalBinary(); err == nil {
+			t.Errorf("Expected an error when marshaling a match with %s", name)
+		}
+	}
+}
+
+func TestMaskedCTFieldsLength(t *testing.T) {
+	// ct_state=+trk-inv as 4 bytes of value and 4 bytes of mask.
+	ctState := []byte{0x00, 0x01, 0xd3, 0x08, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x30}
+	// ct_mark=0x10/0xf0 as 4 bytes of value and 4 bytes of mask.
+	ctMark := []byte{0x00, 0x01, 0xd7, 0x08, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0xf0}
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		value uint32
+		mask  uint32
+	}{
+		{"ct_state", ctState, 0x20, 0x30},
+		{"ct_mark", ctMark, 0x10, 0xf0},
+	} {
+		field := new(MatchField)
+		if err := field.UnmarshalBinary(tc.data); err != nil {
+			t.Errorf("Failed to unmarshal masked %s: %v", tc.name, err)
+			continue
+		}
+		if !field.HasMask || field.Length != 8 || field.Len() != uint16(len(tc.data)) {
+			t.Errorf("Unexpected header of masked %s: %+v", tc.name, field)
+		}
+		value, ok1 := field.Value.(*Uint32Message)
+		mask, ok2 := field.Mask.(*Uint32Message)
+		if !ok1 || !ok2 || value.Data != tc.value || mask.Data != tc.mask {
+			t.Errorf("Unexpected value and mask of %s: %v/%v", tc.name, field.Value, field.Mask)
+		}
+		if data, _ := field.MarshalBinary(); !bytes.Equal(data, tc.data) {
+			t.Errorf("Unexpected bytes of masked %s: %x", tc.name, data)
+		}
+	}
+
+	// A masked ct_label is 16 bytes of value and 16 bytes of mask, a length for a narrower label
+	// would make the label consume the mask.
+	label := NewCTLabelMatchField([16]byte{15: 1}, &[16]byte{15: 0xff})
+	data, _ := label.MarshalBinary()
+	if err := new(MatchField).UnmarshalBinary(data); err != nil {
+		t.Errorf("Failed to unmarshal masked ct_label: %v", err)
+	}
+	data[3] = 16
+	if err := new(MatchField).UnmarshalBinary(data[:20]); err == nil {
+		t.Errorf("Expected an error for a masked ct_label of 16 bytes")
+	}
+}
+
+func TestMatchStringGolden(t *testing.T) {
+	ipMask := net.IP{255, 255, 0, 0}
+	m := NewMatch()
+	m.AddField(*NewInPortField(1))
+	m.AddField(*NewEthSrcField(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, nil))
+	m.AddField(*NewEthTypeField(0x0800))
+	m.AddField(*NewIpProtoField(17))
+	// A 4-byte and a 16-byte IPv4 address are rendered in the same way.
+	m.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 1}, nil))
+	m.AddField(*NewIpv4DstField(net.ParseIP("10.1.0.0"), &ipMask))
+	m.AddField(*NewUdpDstField(53))
+	m.AddField(*NewRegMatchFieldWithMask(2, 0x10, 0xff))
+	m.AddField(*NewCTLabelMatchField([16]byte{15: 1}, nil))
+	golden := "in_port=1,eth_src=aa:bb:cc:dd:ee:ff,eth_type=0x0800,ip_proto=udp,ipv4_src=10.0.0.1," +
+		"ipv4_dst=10.1.0.0/255.255.0.0,udp_dst=53,reg2=0x10/0xff,ct_label=0x00000000000000000000000000000001"
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	decoded := new(Match)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal match: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		for _, match := range []*Match{m, decoded} {
+			if s := match.String(); s != golden {
+				t.Fatalf("Unexpected match string:\n%s\nexpected:\n%s", s, golden)
+			}
+		}
+	}
+}
+
+func TestOxmIdList(t *testing.T) {
+	list := &OxmIdList{Ids: []OxmId{
+		*NewOxmId(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ETH_TYPE, false, 2, 0),
+		*NewOxmId(OXM_CLASS_EXPERIMENTER, OXM_FIELD_TCP_FLAGS, true, 8, ONF_EXPERIMENTER_ID),
+		*NewOxmId(OXM_CLASS_NXM_1, NXM_NX_REG0, true, 8, 0),
+	}}
+	if list.Len() != 16 {
+		t.Errorf("Expected list length 16, got %d", list.Len())
+	}
+	data, err := list.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal OxmIdList: %v", err)
+	}
+	expected := testutil.DecodeHex(t, "80000a02"+"ffff5508"+"4f4e4600"+"00010108")
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Unexpected OxmIdList bytes, expected %x, got %x", expected, data)
+	}
+	decoded := new(OxmIdList)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal OxmIdList: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, list) {
+		t.Errorf("Unexpected OxmIdList, expected %+v, got %+v", list, decoded)
+	}
+
+	// An experimenter OXM ID without the experimenter ID.
+	if err := decoded.UnmarshalBinary(data[:8]); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for a truncated OxmIdList, got %v", err)
+	}
+}
+
+func TestMatchConntrackFields(t *testing.T) {
+	states := NewCTStates()
+	states.SetTrk()
+	states.SetNew()
+	mark := uint32(0xf)
+	ofMatch := NewMatchBuilder().
+		AddField(*NewEthTypeField(0x0800)).
+		AddField(*NewCTStateMatchField(states)).
+		AddField(*NewRegMatchField(1, 5, nil)).
+		AddField(*NewCTMarkMatchField(0x1, &mark)).
+		AddField(*NewCTLabelMatchField([16]byte{15: 1}, nil)).
+		Build()
+	names := ofMatch.ConntrackFields()
+	if !reflect.DeepEqual(names, []string{"ct_state", "ct_mark", "ct_label"}) {
+		t.Errorf("Unexpected conntrack fields: %v", names)
+	}
+	if names := NewMatch().ConntrackFields(); len(names) != 0 {
+		t.Errorf("Expected no conntrack fields in an empty match, got %v", names)
+	}
+}
+
+func TestVlanIdFields(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		field    *MatchField
+		expected []byte
+	}{
+		// vlan_vid=0x100a
+		{"vid 10", NewVlanIdField(10, nil), []byte{0x80, 0x00, 0x0c, 0x02, 0x10, 0x0a}},
+		// vlan_vid=0x000a, which is only valid with a mask excluding OFPVID_PRESENT.
+		{"vid 10 without present bit", NewVlanIdFieldWithPresent(10, nil, false), []byte{0x80, 0x00, 0x0c, 0x02, 0x00, 0x0a}},
+		// vlan_vid=0x1000/0x1000
+		{"present", NewVlanPresentField(), []byte{0x80, 0x00, 0x0d, 0x04, 0x10, 0x00, 0x10, 0x00}},
+		// vlan_vid=0x0000/0x1000
+		{"none", NewVlanNoneField(), []byte{0x80, 0x00, 0x0d, 0x04, 0x00, 0x00, 0x10, 0x00}},
+	} {
+		data, err := tc.field.MarshalBinary()
+		if err != nil {
+			t.Errorf("Failed to marshal %s: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(data, tc.expected) {
+			t.Errorf("Unexpected bytes of %s, expected %x, got %x", tc.name, tc.expected, data)
+		}
+	}
+}
+
+func TestMatchGetMatchField(t *testing.T) {
+	tcpFlags := *newONFTcpFlagsField(0x12, nil)
+	ofMatch := NewMatchBuilder().
+		AddField(*NewEthTypeField(0x0800)).
+		AddField(*NewIpProtoField(6)).
+		AddField(*NewRegMatchField(1, 5, nil)).
+		AddField(tcpFlags).
+		Build()
+
+	f, found := ofMatch.GetMatchField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IP_PROTO)
+	if !found || f.Value.(*IpProtoField).Protocol != 6 {
+		t.Errorf("Failed to get ip_proto: %v", f)
+	}
+	f, found = ofMatch.GetMatchField(OXM_CLASS_NXM_1, NXM_NX_REG1)
+	if !found || f.Value.(*Uint32Message).Data != 5 {
+		t.Errorf("Failed to get reg1: %v", f)
+	}
+	f, found = ofMatch.GetMatchField(OXM_CLASS_EXPERIMENTER, OXM_FIELD_TCP_FLAGS)
+	if !found || f.ExperimenterID != ONF_EXPERIMENTER_ID || f.Value.(*TcpFlagsField).TcpFlags != 0x12 {
+		t.Errorf("Failed to get the experimenter tcp_flags: %v", f)
+	}
+	// The returned field is the one in the Match.
+	f.Value.(*TcpFlagsField).TcpFlags = 0x2
+	if ofMatch.Fields[3].Value.(*TcpFlagsField).TcpFlags != 0x2 {
+		t.Errorf("The returned field is not in the Match")
+	}
+
+	if f, found := ofMatch.GetMatchField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TCP_FLAGS); found || f != nil {
+		t.Errorf("Expected no basic tcp_flags, got %v", f)
+	}
+	if !ofMatch.HasField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ETH_TYPE) {
+		t.Errorf("Expected eth_type in the match")
+	}
+	if ofMatch.HasField(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV4_SRC) {
+		t.Errorf("Expected no ipv4_src in the match")
+	}
+}
+
+func TestMatchIPs(t *testing.T) {
+	ipMask := net.IP{255, 255, 255, 0}
+	m := NewMatch()
+	m.AddField(*NewEthTypeField(0x0800))
+	m.AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 1}, &ipMask))
+	m.AddField(*NewIpv6DstField(net.ParseIP("2001:db8::1"), nil))
+	m.AddField(*NewTunnelIpv4DstField(net.IP{192, 168, 1, 1}, nil))
+	m.AddField(*NewTcpDstField(80))
+	ips := m.IPs()
+	expected := []net.IP{{10, 0, 0, 1}, net.ParseIP("2001:db8::1"), {192, 168, 1, 1}}
+	if len(ips) != len(expected) {
+		t.Fatalf("Expected %d IPs, got %v", len(expected), ips)
+	}
+	for i := range expected {
+		if !ips[i].Equal(expected[i]) {
+			t.Errorf("Unexpected IP %d, expected %s, got %s", i, expected[i], ips[i])
+		}
+	}
+	if ips := NewMatch().IPs(); len(ips) != 0 {
+		t.Errorf("Expected no IPs in an empty match, got %v", ips)
+	}
+}
+
+func TestByteArrayFieldLength(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	field := &ByteArrayField{Data: data, Length: 12}
+	b, err := field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal ByteArrayField: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("Unexpected ByteArrayField bytes %v", b)
+	}
+	// The extra bytes belong to the next TLV and mustn't be consumed.
+	decoded := &ByteArrayField{Length: 12}
+	if err := decoded.UnmarshalBinary(append(b, 0xff, 0xff)); err != nil {
+		t.Fatalf("Failed to unmarshal ByteArrayField: %v", err)
+	}
+	if !bytes.Equal(decoded.Data, data) {
+		t.Errorf("Unexpected ByteArrayField data %v", decoded.Data)
+	}
+	if err := (&ByteArrayField{Length: 12}).UnmarshalBinary(b[:8]); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for a truncated ByteArrayField, got %v", err)
+	}
+
+	// MarshalBinary emits exactly Length bytes regardless of the size of Data.
+	if b, _ := (&ByteArrayField{Data: data[:4], Length: 8}).MarshalBinary(); !bytes.Equal(b, []byte{1, 2, 3, 4, 0, 0, 0, 0}) {
+		t.Errorf("Unexpected bytes for a short Data %v", b)
+	}
+	if b, _ := (&ByteArrayField{Data: data, Length: 8}).MarshalBinary(); !bytes.Equal(b, data[:8]) {
+		t.Errorf("Unexpected bytes for a long Data %v", b)
+	}
+
+	tunMetadata := NewTunMetadataField(1, data, nil)
+	b, err = tunMetadata.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal tun_metadata1: %v", err)
+	}
+	decodedField := new(MatchField)
+	if err := decodedField.UnmarshalBinary(b); err != nil {
+		t.Fatalf("Failed to unmarshal tun_metadata1: %v", err)
+	}
+	if value := decodedField.Value.(*ByteArrayField); value.Length != 12 || !bytes.Equal(value.Data, data) {
+		t.Errorf("Unexpected tun_metadata1 value %v", value)
+	}
+}
+
+func TestMatchTranslateClass(t *testing.T) {
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	macMask := net.HardwareAddr{0xff, 0xff, 0xff, 0x00, 0x00, 0x00}
+	oxmMatch := NewMatch()
+	oxmMatch.AddField(*NewEthSrcField(mac, &macMask))
+	oxmMatch.AddField(*NewArpShaField(mac))
+	oxmMatch.AddField(*NewRegMatchField(1, 0x10, nil))
+
+	nxmMatch := oxmMatch.ToNXM()
+	expected := []struct {
+		class uint16
+		field uint8
+	}{
+		{OXM_CLASS_NXM_0, NXM_OF_ETH_SRC},
+		{OXM_CLASS_NXM_1, NXM_NX_ARP_SHA},
+		{OXM_CLASS_NXM_1, NXM_NX_REG1},
+	}
+	if len(nxmMatch.Fields) != len(expected) {
+		t.Fatalf("Unexpected fields in the NXM match: %s", nxmMatch)
+	}
+	for i, e := range expected {
+		if f := nxmMatch.Fields[i]; f.Class != e.class || f.Field != e.field {
+			t.Errorf("Unexpected header of field %d, expected %d:%d, got %d:%d", i, e.class, e.field, f.Class, f.Field)
+		}
+	}
+	if oxmMatch.Fields[0].Class != OXM_CLASS_OPENFLOW_BASIC {
+		t.Errorf("The original Match is changed by ToNXM")
+	}
+	if nxmMatch.Fingerprint() != oxmMatch.Fingerprint() {
+		t.Errorf("The NXM match is different from the OXM one, %s vs %s", nxmMatch.Fingerprint(), oxmMatch.Fingerprint())
+	}
+	if err := checkMatchSerializationConsistency(nxmMatch); err != nil {
+		t.Error(err)
+	}
+
+	if back := nxmMatch.ToOXM(); !back.Equal(oxmMatch) {
+		t.Errorf("The match translated back to OXM is different from the original, %s vs %s", back, oxmMatch)
+	}
+
+	// in_port has different lengths in NXM and OXM so it's not translated.
+	inPortMatch := NewMatch()
+	inPortMatch.AddField(*NewInPortField(1))
+	if f := inPortMatch.ToNXM().Fields[0]; f.Class != OXM_CLASS_OPENFLOW_BASIC {
+		t.Errorf("Expected in_port to be left untouched, got class %d", f.Class)
+	}
+}
+
+func TestMatchExperimenterFieldPadding(t *testing.T) {
+	field := *newONFTcpFlagsField(0x12, nil)
+	if field.Len() != 10 {
+		t.Fatalf("Expected length 10 of the experimenter field, got %d", field.Len())
+	}
+	m := NewMatch()
+	m.AddField(field)
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal match: %v", err)
+	}
+	// 4 bytes of Match header, 10 bytes of field and 2 bytes of padding.
+	expected := []byte{0x00, 0x01, 0x00, 0x0e, 0xff, 0xff, 0x54, 0x06, 0x4f, 0x4e, 0x46, 0x00, 0x00, 0x12, 0x00, 0x00}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Unexpected bytes of the match %x", data)
+	}
+	if len(data)%8 != 0 {
+		t.Errorf("The marshaled match of %d bytes is not 8-aligned", len(data))
+	}
+
+	decoded := new(Match)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal match: %v", err)
+	}
+	if len(decoded.Fields) != 1 {
+		t.Fatalf("Expected 1 field, got %s", decoded)
+	}
+	f := decoded.Fields[0]
+	if f.Class != OXM_CLASS_EXPERIMENTER || f.Field != OXM_FIELD_TCP_FLAGS || f.ExperimenterID != ONF_EXPERIMENTER_ID || f.Length != 6 {
+		t.Errorf("Unexpected header of the decoded field %+v", f)
+	}
+	if flags := f.Value.(*TcpFlagsField).TcpFlags; flags != 0x12 {
+		t.Errorf("Expected tcp_flags 0x12, got 0x%x", flags)
+	}
+	if err := checkMatchSerializationConsistency(decoded); err != nil {
+		t.Error(err)
+	}
+	if err := new(MatchField).UnmarshalBinary(data[4:10]); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for a truncated experimenter ID, got %v", err)
+	}
+}
+
+func TestMatchOverlaps(t *testing.T) {
+	ipv4Match := func(cidr string) *Match {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		mask := net.IP(ipNet.Mask)
+		m := NewMatch()
+		m.AddField(*NewEthTypeField(0x0800))
+		m.AddField(*NewIpv4SrcField(ipNet.IP, &mask))
+		return m
+	}
+	ipv6Match := func(cidr string) *Match {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		mask := net.IP(ipNet.Mask)
+		m := NewMatch()
+		m.AddField(*NewEthTypeField(0x86dd))
+		m.AddField(*NewIpv6SrcField(ipNet.IP, &mask))
+		return m
+	}
+	for _, tc := range []struct {
+		name     string
+		m1, m2   *Match
+		overlaps bool
+	}{
+		{"nested IPv4 subnets", ipv4Match("10.0.0.0/8"), ipv4Match("10.1.0.0/16"), true},
+		{"sibling IPv4 subnets", ipv4Match("10.1.0.0/16"), ipv4Match("10.2.0.0/16"), false},
+		{"identical IPv4 subnets", ipv4Match("10.1.0.0/16"), ipv4Match("10.1.0.0/16"), true},
+		{"IPv4 address in a subnet", ipv4Match("10.1.2.3/32"), ipv4Match("10.1.0.0/16"), true},
+		{"nested IPv6 subnets", ipv6Match("2001:db8::/32"), ipv6Match("2001:db8:1::/48"), true},
+		{"sibling IPv6 subnets", ipv6Match("2001:db8:1::/48"), ipv6Match("2001:db8:2::/48"), false},
+		{"identical IPv6 subnets", ipv6Match("2001:db8:1::/48"), ipv6Match("2001:db8:1::/48"), true},
+		{"IPv4 and IPv6", ipv4Match("10.0.0.0/8"), ipv6Match("::/0"), false},
+	} {
+		if overlaps := tc.m1.Overlaps(tc.m2); overlaps != tc.overlaps {
+			t.Errorf("%s: expected Overlaps %t, got %t", tc.name, tc.overlaps, overlaps)
+		}
+		if overlaps := tc.m2.Overlaps(tc.m1); overlaps != tc.overlaps {
+			t.Errorf("%s: expected reversed Overlaps %t, got %t", tc.name, tc.overlaps, overlaps)
+		}
+	}
+
+	// A 16-byte IPv4 address is the same as the 4-byte one.
+	m1 := NewMatch()
+	m1.AddField(*NewIpv4SrcField(net.ParseIP("10.1.2.3"), nil))
+	if !m1.Overlaps(ipv4Match("10.1.0.0/16")) {
+		t.Errorf("Expected a 16-byte IPv4 address to overlap its subnet")
+	}
+	// A field in only one Match doesn't prevent the overlap, a conflicting field does.
+	m2 := ipv4Match("10.0.0.0/8")
+	m2.AddField(*NewTcpDstField(80))
+	if !m2.Overlaps(ipv4Match("10.1.0.0/16")) {
+		t.Errorf("Expected matches with different fields to overlap")
+	}
+	m3 := ipv4Match("10.0.0.0/8")
+	m3.AddField(*NewTcpDstField(443))
+	if m2.Overlaps(m3) {
+		t.Errorf("Expected matches with different tcp_dst not to overlap")
+	}
+	// Masked registers overlap if they agree in the bits set in both masks.
+	reg1 := NewMatch()
+	reg1.AddField(*NewRegMatchFieldWithMask(0, 0x1, 0xf))
+	reg2 := NewMatch()
+	reg2.AddField(*NewRegMatchFieldWithMask(0, 0x11, 0xf0))
+	if !reg1.Overlaps(reg2) {
+		t.Errorf("Expected registers with disjoint masks to overlap")
+	}
+}
+
+func TestNewIpDscpEcnFields(t *testing.T) {
+	ipv4 := protocol.NewIPv4()
+	ipv4.DSCP = 10
+	ipv4.ECN = 2
+	ipv6 := new(protocol.IPv6)
+	ipv6.TrafficClass = 10<<2 | 2
+	for _, pkt := range []util.Message{ipv4, ipv6} {
+		dscp, ecn, err := NewIpDscpEcnFields(pkt)
+		if err != nil {
+			t.Fatalf("Failed to get DSCP and ECN fields of %T: %v", pkt, err)
+		}
+		if dscp.Field != OXM_FIELD_IP_DSCP || dscp.Value.(*IpDscpField).Dscp != 10 {
+			t.Errorf("Unexpected DSCP field of %T: %s", pkt, dscp)
+		}
+		if ecn.Field != OXM_FIELD_IP_ECN || ecn.Value.(*IpEcnField).IpEcn != 2 {
+			t.Errorf("Unexpected ECN field of %T: %s", pkt, ecn)
+		}
+	}
+	if _, _, err := NewIpDscpEcnFields(protocol.NewUDP()); err == nil {
+		t.Errorf("Expected an error for a UDP packet")
+	}
+}
+
+func TestMatchDiff(t *testing.T) {
+	oldMatch := NewMatch()
+	oldMatch.AddField(*NewEthTypeField(0x0800))
+	oldMatch.AddField(*NewIpv4SrcField(net.ParseIP("10.0.0.5"), nil))
+	oldMatch.AddField(*NewIpv4DstField(net.ParseIP("10.0.0.1"), nil))
+	newMatch := NewMatch()
+	newMatch.AddField(*NewEthTypeField(0x0800))
+	newMatch.AddField(*NewIpv4DstField(net.ParseIP("10.0.0.2"), nil))
+	newMatch.AddField(*NewTcpDstField(80))
+
+	added, removed, changed := oldMatch.Diff(newMatch)
+	if len(added) != 1 || added[0].Field != OXM_FIELD_TCP_DST {
+		t.Errorf("Unexpected added fields: %v", added)
+	}
+	if len(removed) != 1 || removed[0].Field != OXM_FIELD_IPV4_SRC {
+		t.Errorf("Unexpected removed fields: %v", removed)
+	}
+	if len(changed) != 1 || changed[0].Field != OXM_FIELD_IPV4_DST {
+		t.Fatalf("Unexpected changed fields: %v", changed)
+	}
+	if ip := changed[0].Value.(*Ipv4DstField).Ipv4Dst; !ip.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("Unexpected ipv4_dst in changed field: %v", ip)
+	}
+
+	// Adding a mask changes the field.
+	mask := net.ParseIP("255.255.255.0").To4()
+	maskedMatch := NewMatch()
+	maskedMatch.AddField(*NewIpv4DstField(net.ParseIP("10.0.0.1"), &mask))
+	if _, _, changed = oldMatch.Diff(maskedMatch); len(changed) != 1 {
+		t.Errorf("Expected the masked ipv4_dst to be changed, got %v", changed)
+	}
+	if added, removed, changed = oldMatch.Diff(oldMatch); len(added)+len(removed)+len(changed) != 0 {
+		t.Errorf("Expected no difference with itself, got %v %v %v", added, removed, changed)
+	}
+}
+
+func TestMatchSortCanonical(t *testing.T) {
+	ofMatch := NewMatch()
+	ofMatch.AddField(*NewTcpDstField(80))
+	ofMatch.AddField(*NewCTMarkMatchField(1, nil))
+	ofMatch.AddField(*NewIpProtoField(6))
+	ofMatch.AddField(*NewRegMatchField(1, 2, nil))
+	ofMatch.AddField(*NewEthTypeField(0x0800))
+	unsortedData, _ := ofMatch.MarshalBinary()
+	fingerprint := ofMatch.Fingerprint()
+
+	ofMatch.SortCanonical()
+	expectedOrder := []struct {
+		class uint16
+		field uint8
+	}{
+		{OXM_CLASS_NXM_1, NXM_NX_REG1},
+		{OXM_CLASS_NXM_1, NXM_NX_CT_MARK},
+		{OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ETH_TYPE},
+		{OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IP_PROTO},
+		{OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TCP_DST},
+	}
+	for i, expected := range expectedOrder {
+		if ofMatch.Fields[i].Class != expected.class || ofMatch.Fields[i].Field != expected.field {
+			t.Errorf("Unexpected field at %d: class %d field %d", i, ofMatch.Fields[i].Class, ofMatch.Fields[i].Field)
+		}
+	}
+	if ofMatch.Fingerprint() != fingerprint {
+		t.Errorf("Sorting changed the match semantics: %s vs %s", ofMatch.Fingerprint(), fingerprint)
+	}
+	sortedData, _ := ofMatch.MarshalBinary()
+	if len(sortedData) != len(unsortedData) {
+		t.Errorf("Sorting changed the match length: %d vs %d", len(sortedData), len(unsortedData))
+	}
+	if err := checkMatchSerializationConsistency(ofMatch); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSummarizeMatchBytes(t *testing.T) {
+	// eth_type=0x0800, ip_proto=6 and ipv4_dst=10.0.0.0/24 with 5 bytes of padding.
+	data := testutil.DecodeHex(t, "0001001b"+"80000a020800"+"8000140106"+"800019080a000000ffffff00"+"0000000000")
+	summary, err := SummarizeMatchBytes(data)
+	if err != nil {
+		t.Fatalf("Failed to summarize match: %v", err)
+	}
+	if expected := "eth_type=0x0800,ip_proto=tcp,ipv4_dst=10.0.0.0/255.255.255.0"; summary != expected {
+		t.Errorf("Unexpected match summary, expect: %s, actual: %s", expected, summary)
+	}
+
+	for _, truncated := range [][]byte{data[:2], data[:20]} {
+		if _, err := SummarizeMatchBytes(truncated); err == nil {
+			t.Errorf("Expected error when summarizing truncated match %x", truncated)
+		}
+	}
+}
@@ -2,11 +2,40 @@ package openflow15
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+type Uint8Message struct {
+	Data uint8
+}
+
+func (m *Uint8Message) Len() uint16 {
+	return 1
+}
+
+func (m *Uint8Message) MarshalBinary() (data []byte, err error) {
+	data = []byte{m.Data}
+	return
+}
+
+func (m *Uint8Message) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errors.New("the []byte is too short to unmarshal a full Uint8Message")
+	}
+	m.Data = data[0]
+	return nil
+}
+
 type Uint16Message struct {
 	Data uint16
 }
@@ -59,6 +88,42 @@ func (m *Uint32Message) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// BigInt returns the value of the Uint32Message as a big.Int.
+func (m *Uint32Message) BigInt() *big.Int {
+	return new(big.Int).SetUint64(uint64(m.Data))
+}
+
+type Uint64Message struct {
+	Data uint64
+}
+
+func newUint64Message(data uint64) *Uint64Message {
+	return &Uint64Message{Data: data}
+}
+
+func (m *Uint64Message) Len() uint16 {
+	return 8
+}
+
+func (m *Uint64Message) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, m.Len())
+	binary.BigEndian.PutUint64(data, m.Data)
+	return
+}
+
+func (m *Uint64Message) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("the []byte is too short to unmarshal a full Uint64Message")
+	}
+	m.Data = binary.BigEndian.Uint64(data[:8])
+	return nil
+}
+
+// BigInt returns the value of the Uint64Message as a big.Int.
+func (m *Uint64Message) BigInt() *big.Int {
+	return new(big.Int).SetUint64(m.Data)
+}
+
 type ByteArrayField struct {
 	Data   []byte
 	Length uint8
@@ -69,22 +134,81 @@ func (m *ByteArrayField) Len() uint16 {
 	return uint16(m.Length)
 }
 
+// MarshalBinary returns exactly Length bytes, Data is zero-padded or truncated to fit.
 func (m *ByteArrayField) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, m.Len())
 	copy(data, m.Data)
 	return
 }
 
+// UnmarshalBinary reads Length bytes from data, Length must be set before calling it, e.g. by
+// DecodeMatchField. An error wrapping io.ErrShortBuffer is returned if data is shorter than Length.
 func (m *ByteArrayField) UnmarshalBinary(data []byte) error {
 	expectLength := m.Len()
 	if len(data) < int(expectLength) {
-		return errors.New("The byte array has wrong size to unmarshal ByteArrayField message")
+		return fmt.Errorf("%w: %d bytes left for ByteArrayField of %d bytes", io.ErrShortBuffer, len(data), expectLength)
 	}
 	m.Data = make([]byte, expectLength)
 	copy(m.Data, data[:expectLength])
 	return nil
 }
 
+// BigInt returns the value of the ByteArrayField as an unsigned big-endian integer.
+func (m *ByteArrayField) BigInt() *big.Int {
+	data, _ := m.MarshalBinary()
+	return new(big.Int).SetBytes(data)
+}
+
+// NewByteArrayFieldFromBigInt returns a ByteArrayField of length bytes with the big-endian
+// representation of value. An error is returned if value is negative or doesn't fit into length bytes.
+func NewByteArrayFieldFromBigInt(value *big.Int, length uint8) (*ByteArrayField, error) {
+	if value.Sign() < 0 {
+		return nil, fmt.Errorf("negative value %s", value)
+	}
+	if value.BitLen() > int(length)*8 {
+		return nil, fmt.Errorf("value 0x%x doesn't fit into %d bytes", value, length)
+	}
+	data := make([]byte, length)
+	value.FillBytes(data)
+	return &ByteArrayField{Data: data, Length: length}, nil
+}
+
+// NewByteArrayField returns a MatchField of class and field with value, e.g. an xxreg, tun_metadata
+// or packet register, setting the Length of the value, mask and field from the byte slices. The field
+// is masked if mask is not empty. An error is returned if value is empty, the mask has a different
+// length from value, or the field doesn't fit into the 1-byte Length. The experimenter class is not
+// supported as there is no experimenter ID.
+func NewByteArrayField(class uint16, field uint8, value, mask []byte) (*MatchField, error) {
+	hasMask := len(mask) > 0
+	if len(value) == 0 {
+		return nil, fmt.Errorf("empty value for field (class: %d, field: %d)", class, field)
+	}
+	if hasMask && len(mask) != len(value) {
+		return nil, fmt.Errorf("mask of %d bytes doesn't match value of %d bytes", len(mask), len(value))
+	}
+	if class == OXM_CLASS_EXPERIMENTER {
+		return nil, fmt.Errorf("experimenter class is not supported")
+	}
+	length := len(value)
+	if hasMask {
+		length *= 2
+	}
+	if length > math.MaxUint8 {
+		return nil, fmt.Errorf("field of %d bytes exceeds the max length %d", length, math.MaxUint8)
+	}
+	f := &MatchField{
+		Class:   class,
+		Field:   field,
+		HasMask: hasMask,
+		Length:  uint8(length),
+		Value:   &ByteArrayField{Data: value, Length: uint8(len(value))},
+	}
+	if hasMask {
+		f.Mask = &ByteArrayField{Data: mask, Length: uint8(len(mask))}
+	}
+	return f, nil
+}
+
 type CTStates struct {
 	Data uint32
 	Mask uint32
@@ -96,8 +220,8 @@ func NewCTStates() *CTStates {
 
 // SetNew sets ct_state as "+new".
 func (s *CTStates) SetNew() {
-	s.Data |= 1 << 0
-	s.Mask |= 1 << 0
+	s.Data |= 1 << NX_CT_STATE_NEW_OFS
+	s.Mask |= 1 << NX_CT_STATE_NEW_OFS
 }
 
 // UnsetNew sets ct_state as "-new".
@@ -227,12 +351,186 @@ func NewRegMatchFieldWithMask(idx int, data uint32, mask uint32) *MatchField {
 	return field
 }
 
+// NewRegField returns a MatchField for reg<regID> with value. An all-ones mask is an exact match,
+// any other mask is passed to NewRegMatchFieldWithMask. An error is returned if regID is not in
+// 0..15 or mask is 0, since a zero mask would match every packet.
+func NewRegField(regID int, value uint32, mask uint32) (*MatchField, error) {
+	if regID < 0 || regID > 15 {
+		return nil, fmt.Errorf("invalid register ID %d, expected 0..15", regID)
+	}
+	if mask == 0 {
+		return nil, fmt.Errorf("invalid zero mask for register %d", regID)
+	}
+	if mask == ^uint32(0) {
+		mask = 0
+	}
+	return NewRegMatchFieldWithMask(regID, value, mask), nil
+}
+
+// NewXXRegMatchField returns a MatchField for xxreg<idx> with the 128-bit value. The field is
+// masked if mask is not nil. An error is returned if value or mask doesn't fit into 128 bits.
+func NewXXRegMatchField(idx int, value *big.Int, mask *big.Int) (*MatchField, error) {
+	field, err := FindFieldHeaderByName(fmt.Sprintf("NXM_NX_XXREG%d", idx), mask != nil)
+	if err != nil {
+		return nil, err
+	}
+	if field.Value, err = NewByteArrayFieldFromBigInt(value, 16); err != nil {
+		return nil, err
+	}
+	if mask != nil {
+		if field.Mask, err = NewByteArrayFieldFromBigInt(mask, 16); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+// NewRegFieldFromSpec builds a masked MatchField from an ofnet-style register spec, e.g.
+// ("reg0", 0, 4, 0x5) for reg0[0..3]=0x5. The supported field names are "regN" (N in 0..15),
+// "xxregN" (N in 0..3) and "pkt_mark". The bit range must fit into the field, and the
+// value must fit into nbits.
+func NewRegFieldFromSpec(field string, start, nbits int, value uint64) (*MatchField, error) {
+	name := strings.ToLower(field)
+	var fieldName string
+	var width int
+	switch {
+	case name == "pkt_mark":
+		fieldName, width = "NXM_NX_PKT_MARK", 32
+	case strings.HasPrefix(name, "xxreg"):
+		idx, err := strconv.Atoi(strings.TrimPrefix(name, "xxreg"))
+		if err != nil || idx < 0 || idx > 3 {
+			return nil, fmt.Errorf("invalid xxreg field %s", field)
+		}
+		fieldName, width = fmt.Sprintf("NXM_NX_XXREG%d", idx), 128
+	case strings.HasPrefix(name, "reg"):
+		idx, err := strconv.Atoi(strings.TrimPrefix(name, "reg"))
+		if err != nil || idx < 0 || idx > 15 {
+			return nil, fmt.Errorf("invalid reg field %s", field)
+		}
+		fieldName, width = fmt.Sprintf("NXM_NX_REG%d", idx), 32
+	default:
+		return nil, fmt.Errorf("unsupported register field %s", field)
+	}
+	if start < 0 || nbits < 1 || nbits > 64 || start+nbits > width {
+		return nil, fmt.Errorf("invalid bit range [%d..%d] for field %s with %d bits", start, start+nbits-1, field, width)
+	}
+	bitMask := ^uint64(0) >> (64 - nbits)
+	if value&^bitMask != 0 {
+		return nil, fmt.Errorf("value 0x%x doesn't fit into %d bits", value, nbits)
+	}
+
+	matchField, err := FindFieldHeaderByName(fieldName, true)
+	if err != nil {
+		return nil, err
+	}
+	if width == 32 {
+		matchField.Value = newUint32Message(uint32(value << start))
+		matchField.Mask = newUint32Message(uint32(bitMask << start))
+		return matchField, nil
+	}
+	matchField.Value = &ByteArrayField{Data: shiftUint128(value, start), Length: 16}
+	matchField.Mask = &ByteArrayField{Data: shiftUint128(bitMask, start), Length: 16}
+	return matchField, nil
+}
+
+// shiftUint128 returns the 16-byte big-endian representation of value shifted left by start bits.
+func shiftUint128(value uint64, start int) []byte {
+	var hi, lo uint64
+	if start >= 64 {
+		hi = value << (start - 64)
+	} else {
+		lo = value << start
+		if start > 0 {
+			hi = value >> (64 - start)
+		}
+	}
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data[0:], hi)
+	binary.BigEndian.PutUint64(data[8:], lo)
+	return data
+}
+
+var (
+	tunMetadataLock sync.RWMutex
+	// tunMetadataLengths is map to find the configured length of a tun_metadata field by index.
+	tunMetadataLengths = map[int]uint8{}
+	// geneveOptionIndexes is map to find the tun_metadata index mapped to a Geneve option.
+	geneveOptionIndexes = map[geneveOption]int{}
+)
+
+// geneveOption identifies a Geneve option by its class and type.
+type geneveOption struct {
+	class   uint16
+	optType uint8
+}
+
+// RegisterTunMetadataLength registers the length in bytes of tun_metadata<index>, which is configured
+// in the tun_metadata table of OVS. The length of a decoded tun_metadata field is validated against
+// the registered one, and the on-wire length is used if no length is registered for the index.
+func RegisterTunMetadataLength(index int, length int) error {
+	if index < 0 || index > 7 {
+		return fmt.Errorf("invalid tun_metadata index %d, it should be in [0, 7]", index)
+	}
+	if length <= 0 || length > 124 {
+		return fmt.Errorf("invalid tun_metadata length %d, it should be in [1, 124]", length)
+	}
+	tunMetadataLock.Lock()
+	defer tunMetadataLock.Unlock()
+	tunMetadataLengths[index] = uint8(length)
+	return nil
+}
+
+// getTunMetadataLength returns the registered length of tun_metadata<index>.
+func getTunMetadataLength(index int) (uint8, bool) {
+	tunMetadataLock.RLock()
+	defer tunMetadataLock.RUnlock()
+	length, ok := tunMetadataLengths[index]
+	return length, ok
+}
+
+// RegisterGeneveOption registers the tun_metadata index which the Geneve option with optClass and
+// optType is mapped to, which is configured in the tun_metadata table of OVS.
+func RegisterGeneveOption(optClass uint16, optType uint8, index int) error {
+	if index < 0 || index > 7 {
+		return fmt.Errorf("invalid tun_metadata index %d, it should be in [0, 7]", index)
+	}
+	tunMetadataLock.Lock()
+	defer tunMetadataLock.Unlock()
+	geneveOptionIndexes[geneveOption{class: optClass, optType: optType}] = index
+	return nil
+}
+
+// NewGeneveOptionField returns a MatchField for the Geneve option with optClass and optType, using
+// the tun_metadata index registered with RegisterGeneveOption. The field is masked if mask is not
+// empty. An error is returned if the option is not registered, or data doesn't have the length
+// registered for the tun_metadata.
+func NewGeneveOptionField(optClass uint16, optType uint8, data, mask []byte) (*MatchField, error) {
+	tunMetadataLock.RLock()
+	index, ok := geneveOptionIndexes[geneveOption{class: optClass, optType: optType}]
+	tunMetadataLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Geneve option with class 0x%04x and type 0x%02x is not registered", optClass, optType)
+	}
+	if length, ok := getTunMetadataLength(index); ok && len(data) != int(length) {
+		return nil, fmt.Errorf("Geneve option data has %d bytes, but tun_metadata%d has %d bytes", len(data), index, length)
+	}
+	if len(mask) > 0 && len(mask) != len(data) {
+		return nil, fmt.Errorf("Geneve option mask has %d bytes, but the data has %d bytes", len(mask), len(data))
+	}
+	return NewTunMetadataFieldWithValidation(index, data, mask)
+}
+
 func newNXTunMetadataHeader(idx int, hasMask bool) *MatchField {
 	idKey := fmt.Sprintf("NXM_NX_TUN_METADATA%d", idx)
 	header, _ := FindFieldHeaderByName(idKey, hasMask)
 	return header
 }
 
+// NewTunMetadataField returns a MatchField of NXM_NX_TUN_METADATA<idx> matching the Geneve option
+// mapped to the index, the Length of which is doubled if mask is set. The arguments are not
+// validated: idx must be in [0, 7], data must be 1 to 124 bytes, and mask, if set, must have the
+// same length as data. NewTunMetadataFieldWithValidation could be used for arguments which are not
+// known to be valid.
 func NewTunMetadataField(idx int, data []byte, mask []byte) *MatchField {
 	field := newNXTunMetadataHeader(idx, len(mask) > 0)
 
@@ -251,6 +549,21 @@ func NewTunMetadataField(idx int, data []byte, mask []byte) *MatchField {
 	return field
 }
 
+// NewTunMetadataFieldWithValidation is like NewTunMetadataField, but it returns an error if idx is
+// not in [0, 7], data is not 1 to 124 bytes, or mask is set but has a different length from data.
+func NewTunMetadataFieldWithValidation(idx int, data []byte, mask []byte) (*MatchField, error) {
+	if idx < 0 || idx > 7 {
+		return nil, fmt.Errorf("invalid tun_metadata index %d, it should be in [0, 7]", idx)
+	}
+	if len(data) == 0 || len(data) > 124 {
+		return nil, fmt.Errorf("invalid tun_metadata length %d, it should be in [1, 124]", len(data))
+	}
+	if len(mask) > 0 && len(mask) != len(data) {
+		return nil, fmt.Errorf("tun_metadata mask has %d bytes, but the data has %d bytes", len(mask), len(data))
+	}
+	return NewTunMetadataField(idx, data, mask), nil
+}
+
 func NewCTStateMatchField(states *CTStates) *MatchField {
 	field, _ := FindFieldHeaderByName("NXM_NX_CT_STATE", true)
 	field.Value = newUint32Message(states.Data)
@@ -301,6 +614,66 @@ func (m *CTLabel) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// String returns the 128-bit label in hex.
+func (m *CTLabel) String() string {
+	return "0x" + hex.EncodeToString(m.Data[:])
+}
+
+// Get returns the bits in rng of the label, in which bit 0 is the least significant bit. rng must
+// be within 128 bits and not wider than 64 bits.
+func (m *CTLabel) Get(rng *NXRange) uint64 {
+	value := new(big.Int).SetBytes(m.Data[:])
+	value.Rsh(value, uint(rng.start))
+	return value.Uint64() & (^uint64(0) >> (64 - rng.GetNbits()))
+}
+
+// Set sets the bits in rng of the label to value, in which bit 0 is the least significant bit. An
+// error is returned if rng is not within 128 bits or value doesn't fit into rng.
+func (m *CTLabel) Set(rng *NXRange, value uint64) error {
+	nBits := int(rng.GetNbits())
+	if rng.start < 0 || rng.end >= 128 || nBits > 64 {
+		return fmt.Errorf("invalid bit range [%d..%d] of ct_label", rng.start, rng.end)
+	}
+	if nBits < 64 && value>>nBits != 0 {
+		return fmt.Errorf("value 0x%x doesn't fit into bit range [%d..%d] of ct_label", value, rng.start, rng.end)
+	}
+	label := new(big.Int).SetBytes(m.Data[:])
+	mask := new(big.Int).Lsh(new(big.Int).SetUint64(^uint64(0)>>(64-nBits)), uint(rng.start))
+	label.AndNot(label, mask)
+	label.Or(label, new(big.Int).Lsh(new(big.Int).SetUint64(value), uint(rng.start)))
+	label.FillBytes(m.Data[:])
+	return nil
+}
+
+// CTLabelBitGroup is a value set in a range of bits of ct_label.
+type CTLabelBitGroup struct {
+	Range *NXRange
+	Value uint64
+}
+
+// NewCtLabelField returns a masked ct_label MatchField which matches the values of the bit groups,
+// the names of the groups are only used in errors. An error is returned if a group is invalid or
+// overlaps with another one.
+func NewCtLabelField(groups map[string]CTLabelBitGroup) (*MatchField, error) {
+	label, mask := new(CTLabel), new(CTLabel)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		group := groups[name]
+		if err := label.Set(group.Range, group.Value); err != nil {
+			return nil, fmt.Errorf("invalid bit group %s: %w", name, err)
+		}
+		if mask.Get(group.Range) != 0 {
+			return nil, fmt.Errorf("bit group %s overlaps with another group", name)
+		}
+		_ = mask.Set(group.Range, ^uint64(0)>>(64-group.Range.GetNbits()))
+	}
+	return NewCTLabelMatchField(label.Data, &mask.Data), nil
+}
+
 func newCTLabel(data [16]byte) *CTLabel {
 	label := new(CTLabel)
 	_ = label.UnmarshalBinary(data[:16])
@@ -319,6 +692,18 @@ func NewCTLabelMatchField(label [16]byte, mask *[16]byte) *MatchField {
 	return field
 }
 
+// NewNxTunIdField returns a MatchField for NXM_NX_TUN_ID. The field is masked unless mask is
+// all ones, e.g. a mask of 0xffffff could be used to match the 24-bit VNI.
+func NewNxTunIdField(id uint64, mask uint64) *MatchField {
+	hasMask := mask != ^uint64(0)
+	field, _ := FindFieldHeaderByName("NXM_NX_TUN_ID", hasMask)
+	field.Value = newUint64Message(id)
+	if hasMask {
+		field.Mask = newUint64Message(mask)
+	}
+	return field
+}
+
 func NewConjIDMatchField(conjID uint32) *MatchField {
 	field, _ := FindFieldHeaderByName("NXM_NX_CONJ_ID", false)
 	field.Value = newUint32Message(conjID)
@@ -3,6 +3,8 @@ package openflow15
 import (
 	"fmt"
 	"strings"
+
+	"antrea.io/libOpenflow/util"
 )
 
 const (
@@ -144,7 +146,7 @@ var oxxFieldHeaderMap = map[string]*MatchField{
 	"NXM_NX_ND_SLL":        newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_ND_SLL, 6),
 	"NXM_NX_ND_TLL":        newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_ND_TLL, 6),
 	"NXM_NX_IP_FRAG":       newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_IP_FRAG, 1),
-	"NXM_NX_IPV6_LABEL":    newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_IPV6_LABEL, 1),
+	"NXM_NX_IPV6_LABEL":    newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_IPV6_LABEL, 4),
 	"NXM_NX_IP_ECN":        newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_IP_ECN, 1),
 	"NXM_NX_IP_TTL":        newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_IP_TTL, 1),
 	"NXM_NX_MPLS_TTL":      newMatchFieldHeader(OXM_CLASS_NXM_1, NXM_NX_MPLS_TTL, 1),
@@ -222,6 +224,20 @@ var oxxFieldHeaderMap = map[string]*MatchField{
 	"OXM_OF_PBB_ISID":       newMatchFieldHeader(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_PBB_ISID, 3),
 	"OXM_OF_TUNNEL_ID":      newMatchFieldHeader(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TUNNEL_ID, 8),
 	"OXM_OF_IPV6_EXTHDR":    newMatchFieldHeader(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_EXTHDR, 2),
+	"OXM_OF_PBB_UCA":        newMatchFieldHeader(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_PBB_UCA, 1),
+	"OXM_OF_ACTSET_OUTPUT":  newMatchFieldHeader(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ACTSET_OUTPUT, 4),
+	"OXM_OF_PACKET_TYPE":    newMatchFieldHeader(OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_PACKET_TYPE, 4),
+}
+
+// oxxFieldNameMap is map to find the OVS known OXX field name using the class and field number of a field header.
+var oxxFieldNameMap = util.OXXFieldNames(oxxFieldHeaderMap, func(header *MatchField) (uint16, uint8) {
+	return header.Class, header.Field
+})
+
+// FindFieldNameByHeader finds the OVS known OXX field name, e.g. "NXM_NX_REG0", by class and field.
+func FindFieldNameByHeader(class uint16, field uint8) (string, bool) {
+	name, found := oxxFieldNameMap[util.OXXFieldKey(class, field)]
+	return name, found
 }
 
 // FindFieldHeaderByName finds OXM/NXM field by name and mask.
@@ -6,8 +6,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 	"net"
+	"reflect"
+	"sync"
 	"testing"
+
+	"antrea.io/libOpenflow/util/testutil"
 )
 
 func TestNXActionResubmit(t *testing.T) {
@@ -930,3 +935,524 @@ func testMatchFieldHeaderMarshalUnMarshal(tgtField *MatchField, t *testing.T) {
 		t.Errorf("Unmarshalled header has incorrect 'Length' field, expect: %d, actual: %d", testMFHeader.Length, tgtField.Length)
 	}
 }
+
+func TestNewRegFieldFromSpec(t *testing.T) {
+	field, err := NewRegFieldFromSpec("reg0", 0, 4, 0x5)
+	if err != nil {
+		t.Fatalf("Failed to build reg0[0..3] field: %v", err)
+	}
+	if field.Class != OXM_CLASS_NXM_1 || field.Field != NXM_NX_REG0 || !field.HasMask || field.Length != 8 {
+		t.Errorf("Unexpected reg0 field header: %+v", field)
+	}
+	if field.Value.(*Uint32Message).Data != 0x5 || field.Mask.(*Uint32Message).Data != 0xf {
+		t.Errorf("Unexpected reg0 value/mask: %x/%x", field.Value.(*Uint32Message).Data, field.Mask.(*Uint32Message).Data)
+	}
+
+	field, err = NewRegFieldFromSpec("xxreg1", 60, 8, 0xab)
+	if err != nil {
+		t.Fatalf("Failed to build xxreg1[60..67] field: %v", err)
+	}
+	if field.Field != NXM_NX_XXREG1 || field.Length != 32 {
+		t.Errorf("Unexpected xxreg1 field header: %+v", field)
+	}
+	expectValue := testutil.DecodeHex(t, "000000000000000ab000000000000000")
+	expectMask := testutil.DecodeHex(t, "000000000000000ff000000000000000")
+	if !bytes.Equal(field.Value.(*ByteArrayField).Data, expectValue) {
+		t.Errorf("Unexpected xxreg1 value: %x", field.Value.(*ByteArrayField).Data)
+	}
+	if !bytes.Equal(field.Mask.(*ByteArrayField).Data, expectMask) {
+		t.Errorf("Unexpected xxreg1 mask: %x", field.Mask.(*ByteArrayField).Data)
+	}
+	testMatchFieldHeaderMarshalUnMarshal(field, t)
+
+	for _, tc := range []struct {
+		field string
+		start int
+		nbits int
+		value uint64
+	}{
+		{"reg16", 0, 4, 0},
+		{"reg1", 30, 4, 0},
+		{"reg1", 0, 2, 0x5},
+		{"xxreg4", 0, 4, 0},
+		{"xxreg0", 120, 16, 0},
+		{"tun_id", 0, 4, 0},
+	} {
+		if _, err := NewRegFieldFromSpec(tc.field, tc.start, tc.nbits, tc.value); err == nil {
+			t.Errorf("Expected error for spec %s[%d:%d]=%x", tc.field, tc.start, tc.nbits, tc.value)
+		}
+	}
+}
+
+func TestNxTunIdField(t *testing.T) {
+	vni := uint64(0x123456)
+	field := NewNxTunIdField(vni, 0xffffff)
+	if !field.HasMask || field.Length != 16 {
+		t.Errorf("Unexpected tun_id field header: %+v", field)
+	}
+	data, err := field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal tun_id field: %v", err)
+	}
+	expectData := testutil.DecodeHex(t, "00012110"+"0000000000123456"+"0000000000ffffff")
+	if !bytes.Equal(data, expectData) {
+		t.Errorf("Unexpected tun_id field bytes, expect: %x, actual: %x", expectData, data)
+	}
+
+	newField := new(MatchField)
+	if err := newField.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal tun_id field: %v", err)
+	}
+	if newField.Value.(*Uint64Message).Data != vni || newField.Mask.(*Uint64Message).Data != 0xffffff {
+		t.Errorf("Unexpected tun_id value/mask: %+v/%+v", newField.Value, newField.Mask)
+	}
+
+	field = NewNxTunIdField(vni, ^uint64(0))
+	if field.HasMask || field.Mask != nil || field.Length != 8 {
+		t.Errorf("Unexpected exact tun_id field header: %+v", field)
+	}
+}
+
+func TestRegisterTunMetadataLength(t *testing.T) {
+	if err := RegisterTunMetadataLength(0, 4); err != nil {
+		t.Fatalf("Failed to register tun_metadata length: %v", err)
+	}
+	defer func() {
+		tunMetadataLock.Lock()
+		delete(tunMetadataLengths, 0)
+		tunMetadataLock.Unlock()
+	}()
+	if err := RegisterTunMetadataLength(8, 4); err == nil {
+		t.Errorf("Expected an error when registering tun_metadata8")
+	}
+	if err := RegisterTunMetadataLength(1, 0); err == nil {
+		t.Errorf("Expected an error when registering a zero length")
+	}
+
+	data, _ := NewTunMetadataField(0, []byte{0x12, 0x34, 0x56, 0x78}, nil).MarshalBinary()
+	newField := new(MatchField)
+	if err := newField.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal tun_metadata0 field: %v", err)
+	}
+	if value := newField.Value.(*ByteArrayField); value.Length != 4 || !bytes.Equal(value.Data, []byte{0x12, 0x34, 0x56, 0x78}) {
+		t.Errorf("Unexpected tun_metadata0 value: %+v", value)
+	}
+
+	data, _ = NewTunMetadataField(0, []byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 0}, nil).MarshalBinary()
+	if err := new(MatchField).UnmarshalBinary(data); err == nil {
+		t.Errorf("Expected an error when decoding tun_metadata0 with a length different from the registered one")
+	}
+	// The on-wire length is used for the index without registered length.
+	data, _ = NewTunMetadataField(1, []byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 0}, nil).MarshalBinary()
+	if err := new(MatchField).UnmarshalBinary(data); err != nil {
+		t.Errorf("Failed to unmarshal tun_metadata1 field: %v", err)
+	}
+}
+
+func TestNewTunMetadataField(t *testing.T) {
+	for _, tc := range []struct {
+		mask           []byte
+		expectedLength uint8
+	}{
+		{nil, 4},
+		{[]byte{0xff, 0xff, 0, 0}, 8},
+	} {
+		field := NewTunMetadataField(3, []byte{0x12, 0x34, 0x56, 0x78}, tc.mask)
+		if field.Class != OXM_CLASS_NXM_1 || field.Field != NXM_NX_TUN_METADATA0+3 || field.HasMask != (tc.mask != nil) {
+			t.Errorf("Unexpected tun_metadata3 field header: %+v", field)
+		}
+		if field.Length != tc.expectedLength {
+			t.Errorf("Unexpected tun_metadata3 field length %d, expected %d", field.Length, tc.expectedLength)
+		}
+		data, _ := field.MarshalBinary()
+		if data[3] != tc.expectedLength || len(data) != 4+int(tc.expectedLength) {
+			t.Errorf("Unexpected marshaled tun_metadata3 field: %v", data)
+		}
+		newField := new(MatchField)
+		if err := newField.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Failed to unmarshal tun_metadata3 field: %v", err)
+		}
+		if value := newField.Value.(*ByteArrayField); !bytes.Equal(value.Data, []byte{0x12, 0x34, 0x56, 0x78}) {
+			t.Errorf("Unexpected tun_metadata3 value: %+v", value)
+		}
+		if tc.mask != nil {
+			if mask := newField.Mask.(*ByteArrayField); !bytes.Equal(mask.Data, tc.mask) {
+				t.Errorf("Unexpected tun_metadata3 mask: %+v", mask)
+			}
+		}
+	}
+
+}
+
+func TestNewTunMetadataFieldWithValidation(t *testing.T) {
+	field, err := NewTunMetadataFieldWithValidation(3, []byte{0x12, 0x34, 0x56, 0x78}, []byte{0xff, 0xff, 0, 0})
+	if err != nil {
+		t.Fatalf("Failed to create tun_metadata3 field: %v", err)
+	}
+	if expected := NewTunMetadataField(3, []byte{0x12, 0x34, 0x56, 0x78}, []byte{0xff, 0xff, 0, 0}); !reflect.DeepEqual(field, expected) {
+		t.Errorf("Unexpected tun_metadata3 field %+v, expected %+v", field, expected)
+	}
+	for name, tc := range map[string]struct {
+		idx        int
+		data, mask []byte
+	}{
+		"tun_metadata8":   {8, []byte{1, 2, 3, 4}, nil},
+		"negative index":  {-1, []byte{1, 2, 3, 4}, nil},
+		"empty value":     {0, nil, nil},
+		"too long value":  {0, make([]byte, 125), nil},
+		"mismatched mask": {0, []byte{1, 2, 3, 4}, []byte{0xff}},
+	} {
+		if _, err := NewTunMetadataFieldWithValidation(tc.idx, tc.data, tc.mask); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestXXRegBigInt(t *testing.T) {
+	value, _ := new(big.Int).SetString("0123456789abcdeffedcba9876543210", 16)
+	mask, _ := new(big.Int).SetString("ffffffffffffffff0000000000000000", 16)
+	field, err := NewXXRegMatchField(1, value, mask)
+	if err != nil {
+		t.Fatalf("Failed to create xxreg1 field: %v", err)
+	}
+	data, err := field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal xxreg1 field: %v", err)
+	}
+	newField := new(MatchField)
+	if err := newField.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal xxreg1 field: %v", err)
+	}
+	if v := newField.Value.(*ByteArrayField).BigInt(); v.Cmp(value) != 0 {
+		t.Errorf("Unexpected xxreg1 value: %x, expected: %x", v, value)
+	}
+	if m := newField.Mask.(*ByteArrayField).BigInt(); m.Cmp(mask) != 0 {
+		t.Errorf("Unexpected xxreg1 mask: %x, expected: %x", m, mask)
+	}
+
+	tooLarge := new(big.Int).Lsh(big.NewInt(1), 128)
+	if _, err := NewXXRegMatchField(1, tooLarge, nil); err == nil {
+		t.Errorf("Expected an error for a value wider than 128 bits")
+	}
+	if _, err := NewXXRegMatchField(1, big.NewInt(-1), nil); err == nil {
+		t.Errorf("Expected an error for a negative value")
+	}
+	if v := newUint32Message(0xffffffff).BigInt(); v.Uint64() != 0xffffffff {
+		t.Errorf("Unexpected Uint32Message value: %s", v)
+	}
+	if v := (&MetadataField{Metadata: 1 << 63}).BigInt(); v.Uint64() != 1<<63 {
+		t.Errorf("Unexpected MetadataField value: %s", v)
+	}
+}
+
+func TestNewGeneveOptionField(t *testing.T) {
+	if _, err := NewGeneveOptionField(0x0102, 0x80, []byte{1, 2, 3, 4}, nil); err == nil {
+		t.Errorf("Expected an error for an unregistered Geneve option")
+	}
+	if err := RegisterGeneveOption(0x0102, 0x80, 2); err != nil {
+		t.Fatalf("Failed to register Geneve option: %v", err)
+	}
+	if err := RegisterTunMetadataLength(2, 4); err != nil {
+		t.Fatalf("Failed to register tun_metadata length: %v", err)
+	}
+	defer func() {
+		tunMetadataLock.Lock()
+		delete(geneveOptionIndexes, geneveOption{class: 0x0102, optType: 0x80})
+		delete(tunMetadataLengths, 2)
+		tunMetadataLock.Unlock()
+	}()
+
+	field, err := NewGeneveOptionField(0x0102, 0x80, []byte{1, 2, 3, 4}, []byte{0xff, 0xff, 0, 0})
+	if err != nil {
+		t.Fatalf("Failed to create Geneve option field: %v", err)
+	}
+	if field.Class != OXM_CLASS_NXM_1 || field.Field != NXM_NX_TUN_METADATA2 || !field.HasMask || field.Length != 8 {
+		t.Errorf("Unexpected Geneve option field header: %+v", field)
+	}
+	if !bytes.Equal(field.Value.(*ByteArrayField).Data, []byte{1, 2, 3, 4}) || !bytes.Equal(field.Mask.(*ByteArrayField).Data, []byte{0xff, 0xff, 0, 0}) {
+		t.Errorf("Unexpected Geneve option field value/mask: %+v/%+v", field.Value, field.Mask)
+	}
+	if _, err := NewGeneveOptionField(0x0102, 0x80, []byte{1, 2}, nil); err == nil {
+		t.Errorf("Expected an error for Geneve option data with a different length from tun_metadata2")
+	}
+}
+
+func TestCTLabelBitGroups(t *testing.T) {
+	label := new(CTLabel)
+	if err := label.Set(NewNXRange(0, 15), 0x1234); err != nil {
+		t.Fatalf("Failed to set bits [0..15]: %v", err)
+	}
+	if err := label.Set(NewNXRange(64, 79), 0xabcd); err != nil {
+		t.Fatalf("Failed to set bits [64..79]: %v", err)
+	}
+	expected := [16]byte{6: 0xab, 7: 0xcd, 14: 0x12, 15: 0x34}
+	if label.Data != expected {
+		t.Errorf("Unexpected ct_label, expected %x, got %x", expected, label.Data)
+	}
+	if v := label.Get(NewNXRange(64, 79)); v != 0xabcd {
+		t.Errorf("Unexpected bits [64..79], expected 0xabcd, got 0x%x", v)
+	}
+	if label.String() != "0x000000000000abcd0000000000001234" {
+		t.Errorf("Unexpected ct_label string %s", label)
+	}
+	if err := label.Set(NewNXRange(0, 3), 0x10); err == nil {
+		t.Errorf("Expected an error for a value not fitting into the bit range")
+	}
+
+	field, err := NewCtLabelField(map[string]CTLabelBitGroup{
+		"ingressRule": {Range: NewNXRange(0, 15), Value: 0x1234},
+		"egressRule":  {Range: NewNXRange(64, 79), Value: 0xabcd},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create ct_label field: %v", err)
+	}
+	expectedMask := [16]byte{6: 0xff, 7: 0xff, 14: 0xff, 15: 0xff}
+	if !field.HasMask || field.Value.(*CTLabel).Data != expected || field.Mask.(*CTLabel).Data != expectedMask {
+		t.Errorf("Unexpected ct_label field %s", field)
+	}
+	if field.String() != "ct_label=0x000000000000abcd0000000000001234/0x000000000000ffff000000000000ffff" {
+		t.Errorf("Unexpected ct_label field string %s", field)
+	}
+	if _, err := NewCtLabelField(map[string]CTLabelBitGroup{
+		"a": {Range: NewNXRange(0, 15)},
+		"b": {Range: NewNXRange(8, 23)},
+	}); err == nil {
+		t.Errorf("Expected an error for overlapping bit groups")
+	}
+}
+
+func TestNewByteArrayField(t *testing.T) {
+	value := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, tc := range []struct {
+		mask           []byte
+		expectedLength uint8
+	}{
+		{nil, 8},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, 16},
+	} {
+		// The 8-byte xreg1 in the packet registers class.
+		field, err := NewByteArrayField(OXM_CLASS_PACKET_REGS, 1, value, tc.mask)
+		if err != nil {
+			t.Fatalf("Failed to create xreg1 field: %v", err)
+		}
+		if field.Length != tc.expectedLength || field.HasMask != (tc.mask != nil) {
+			t.Errorf("Unexpected xreg1 field: %+v", field)
+		}
+		data, err := field.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal xreg1 field: %v", err)
+		}
+		if data[3] != tc.expectedLength || len(data) != 4+int(tc.expectedLength) {
+			t.Errorf("Unexpected marshaled xreg1 field: %v", data)
+		}
+		if !bytes.Equal(data[4:12], value) || !bytes.Equal(data[12:], tc.mask) {
+			t.Errorf("Unexpected xreg1 value or mask: %v", data)
+		}
+	}
+
+	for name, args := range map[string][2][]byte{
+		"empty value":     {nil, nil},
+		"mask too short":  {value, {0xff}},
+		"value too large": {make([]byte, 128), make([]byte, 128)},
+	} {
+		if _, err := NewByteArrayField(OXM_CLASS_PACKET_REGS, 1, args[0], args[1]); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+	if _, err := NewByteArrayField(OXM_CLASS_EXPERIMENTER, 1, value, nil); err == nil {
+		t.Errorf("Expected an error for the experimenter class")
+	}
+}
+
+func TestDecodeNXMFields(t *testing.T) {
+	for _, name := range []string{
+		"NXM_NX_TUN_ID",
+		"NXM_NX_IP_FRAG",
+		"NXM_NX_IPV6_LABEL",
+		"NXM_NX_IP_ECN",
+		"NXM_NX_MPLS_TTL",
+		"NXM_NX_TCP_FLAGS",
+		"NXM_NX_TUN_GBP_ID",
+		"NXM_NX_TUN_GBP_FLAGS",
+		"NXM_NX_TUN_FLAGS",
+	} {
+		header, err := FindFieldHeaderByName(name, false)
+		if err != nil {
+			t.Fatalf("Failed to find header of %s: %v", name, err)
+		}
+		data := make([]byte, header.Length)
+		for i := range data {
+			data[i] = byte(i + 1)
+		}
+		value, err := DecodeMatchField(header.Class, header.Field, header.Length, false, data)
+		if err != nil {
+			t.Errorf("Failed to decode %s: %v", name, err)
+			continue
+		}
+		if value.Len() != uint16(header.Length) {
+			t.Errorf("Unexpected length of %s, expected %d, got %d", name, header.Length, value.Len())
+		}
+		encoded, _ := value.MarshalBinary()
+		if !bytes.Equal(encoded, data) {
+			t.Errorf("Unexpected value of %s, expected %x, got %x", name, data, encoded)
+		}
+	}
+	// The fields missing in the name map.
+	for _, field := range []uint8{NXM_NX_DP_HASH, NXM_NX_RECIRC_ID} {
+		data := []byte{1, 2, 3, 4}
+		value, err := DecodeMatchField(OXM_CLASS_NXM_1, field, 4, false, data)
+		if err != nil {
+			t.Errorf("Failed to decode NXM_NX field %d: %v", field, err)
+			continue
+		}
+		if encoded, _ := value.MarshalBinary(); !bytes.Equal(encoded, data) {
+			t.Errorf("Unexpected value of NXM_NX field %d, expected %x, got %x", field, data, encoded)
+		}
+	}
+}
+
+func TestNewRegField(t *testing.T) {
+	exact, err := NewRegField(3, 0x12345678, 0xffffffff)
+	if err != nil {
+		t.Fatalf("Failed to create reg3 field: %v", err)
+	}
+	if exact.Class != OXM_CLASS_NXM_1 || exact.Field != NXM_NX_REG3 || exact.HasMask || exact.Length != 4 {
+		t.Errorf("Unexpected reg3 field %+v", exact)
+	}
+
+	masked, err := NewRegField(3, 0x5600, 0xff00)
+	if err != nil {
+		t.Fatalf("Failed to create masked reg3 field: %v", err)
+	}
+	if !masked.HasMask || masked.Length != 2*exact.Length {
+		t.Errorf("Expected a masked field with Length %d, got HasMask %t and Length %d", 2*exact.Length, masked.HasMask, masked.Length)
+	}
+	data, err := masked.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal masked reg3 field: %v", err)
+	}
+	decoded := new(MatchField)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal masked reg3 field: %v", err)
+	}
+	if decoded.Field != NXM_NX_REG3 || !decoded.HasMask || decoded.Length != 8 ||
+		decoded.Value.(*Uint32Message).Data != 0x5600 || decoded.Mask.(*Uint32Message).Data != 0xff00 {
+		t.Errorf("Unexpected decoded reg3 field %s", decoded)
+	}
+
+	for _, regID := range []int{-1, 16} {
+		if _, err := NewRegField(regID, 0, 0xffffffff); err == nil {
+			t.Errorf("Expected an error for register ID %d", regID)
+		}
+	}
+	if _, err := NewRegField(3, 0x1, 0); err == nil {
+		t.Errorf("Expected an error for a zero mask")
+	}
+}
+
+func TestIPTtlFieldRoundTrip(t *testing.T) {
+	field := NewIPTtlField(64)
+	if field.Class != OXM_CLASS_NXM_1 || field.Field != NXM_NX_IP_TTL || field.HasMask || field.Length != 1 {
+		t.Errorf("Unexpected nw_ttl field %+v", field)
+	}
+	data, err := field.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal nw_ttl field: %v", err)
+	}
+	if len(data) != 5 || int(field.Len()) != len(data) {
+		t.Errorf("Expected nw_ttl field marshaled to 5 bytes with Len() %d, got %d bytes", field.Len(), len(data))
+	}
+	decoded := new(MatchField)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal nw_ttl field: %v", err)
+	}
+	ttl, ok := decoded.Value.(*TtlField)
+	if !ok || decoded.Field != NXM_NX_IP_TTL || decoded.Length != 1 || ttl.Ttl != 64 {
+		t.Errorf("Unexpected decoded nw_ttl field %s", decoded)
+	}
+}
+
+func TestCTStateMatchField(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		setFn    func(s *CTStates)
+		expected []byte
+	}{
+		{
+			// ct_state=+new+trk is encoded by OVS as 0x21/0x21.
+			name: "+new+trk",
+			setFn: func(s *CTStates) {
+				s.SetNew()
+				s.SetTrk()
+			},
+			expected: []byte{0x00, 0x01, 0xd3, 0x08, 0, 0, 0, 0x21, 0, 0, 0, 0x21},
+		},
+		{
+			// ct_state=-est is encoded by OVS as 0x00/0x02.
+			name: "-est",
+			setFn: func(s *CTStates) {
+				s.UnsetEst()
+			},
+			expected: []byte{0x00, 0x01, 0xd3, 0x08, 0, 0, 0, 0x00, 0, 0, 0, 0x02},
+		},
+		{
+			// A flag set and then unset is kept in the mask.
+			name: "+trk-trk+rpl",
+			setFn: func(s *CTStates) {
+				s.SetTrk()
+				s.UnsetTrk()
+				s.SetRpl()
+			},
+			expected: []byte{0x00, 0x01, 0xd3, 0x08, 0, 0, 0, 0x08, 0, 0, 0, 0x28},
+		},
+	} {
+		states := NewCTStates()
+		tc.setFn(states)
+		data, err := NewCTStateMatchField(states).MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal ct_state=%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(data, tc.expected) {
+			t.Errorf("Unexpected ct_state=%s, expected %x, got %x", tc.name, tc.expected, data)
+		}
+	}
+}
+
+// TestTunMetadataRegistryConcurrency registers and looks up tun_metadata lengths and Geneve options
+// concurrently, it is meaningful when run with -race.
+func TestTunMetadataRegistryConcurrency(t *testing.T) {
+	defer func() {
+		tunMetadataLock.Lock()
+		tunMetadataLengths = map[int]uint8{}
+		geneveOptionIndexes = map[geneveOption]int{}
+		tunMetadataLock.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := RegisterTunMetadataLength(i, 4); err != nil {
+				t.Errorf("Failed to register tun_metadata%d length: %v", i, err)
+			}
+			if err := RegisterGeneveOption(0x0102, uint8(i), i); err != nil {
+				t.Errorf("Failed to register Geneve option %d: %v", i, err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			getTunMetadataLength(i)
+			_, _ = NewGeneveOptionField(0x0102, uint8(i), []byte{1, 2, 3, 4}, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		if length, ok := getTunMetadataLength(i); !ok || length != 4 {
+			t.Errorf("Expected tun_metadata%d length 4, got %d", i, length)
+		}
+		if _, err := NewGeneveOptionField(0x0102, uint8(i), []byte{1, 2, 3, 4}, nil); err != nil {
+			t.Errorf("Failed to create Geneve option %d field: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package openflow15
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"antrea.io/libOpenflow/protocol"
+)
+
+// ovsProtocolShortcuts maps the OVS protocol shortcuts to the eth_type and ip_proto they stand for,
+// ip_proto is 0 if the shortcut doesn't imply one.
+var ovsProtocolShortcuts = map[string]struct {
+	ethType uint16
+	ipProto uint8
+}{
+	"ip":    {protocol.IPv4_MSG, 0},
+	"ipv6":  {protocol.IPv6_MSG, 0},
+	"arp":   {protocol.ARP_MSG, 0},
+	"icmp":  {protocol.IPv4_MSG, protocol.Type_ICMP},
+	"tcp":   {protocol.IPv4_MSG, protocol.Type_TCP},
+	"udp":   {protocol.IPv4_MSG, protocol.Type_UDP},
+	"sctp":  {protocol.IPv4_MSG, protocol.Type_SCTP},
+	"icmp6": {protocol.IPv6_MSG, protocol.Type_IPv6ICMP},
+	"tcp6":  {protocol.IPv6_MSG, protocol.Type_TCP},
+	"udp6":  {protocol.IPv6_MSG, protocol.Type_UDP},
+	"sctp6": {protocol.IPv6_MSG, protocol.Type_SCTP},
+}
+
+// ParseOVSMatch parses the match part of an OVS flow, e.g. "ip,nw_src=10.0.0.0/24,tcp,tp_dst=80",
+// into a Match. The supported tokens are the protocol shortcuts (ip, ipv6, arp, icmp, tcp, udp,
+// sctp, icmp6, tcp6, udp6 and sctp6), dl_type, nw_proto, dl_src, dl_dst, nw_src, nw_dst, tp_src,
+// tp_dst, in_port and reg0 to reg15. Addresses may be followed by "/mask", and IP addresses by a
+// prefix length. The prerequisites of the fields are added to the Match, e.g. eth_type=0x0800 for
+// nw_src. nw_src and nw_dst are matched against arp_spa and arp_tpa in an ARP match.
+func ParseOVSMatch(s string) (*Match, error) {
+	var tokens [][2]string
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(token, "=")
+		tokens = append(tokens, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+
+	// The protocol is determined first, since the fields such as tp_dst depend on it regardless of
+	// the order of the tokens.
+	var ethType uint16
+	var ipProto uint8
+	setEthType := func(t uint16) error {
+		if ethType != 0 && ethType != t {
+			return fmt.Errorf("conflicting eth_type 0x%04x and 0x%04x", ethType, t)
+		}
+		ethType = t
+		return nil
+	}
+	setIPProto := func(p uint8) error {
+		if ipProto != 0 && ipProto != p {
+			return fmt.Errorf("conflicting ip_proto %d and %d", ipProto, p)
+		}
+		ipProto = p
+		return nil
+	}
+	for _, token := range tokens {
+		name, value := token[0], token[1]
+		var err error
+		if shortcut, ok := ovsProtocolShortcuts[name]; ok && value == "" {
+			if err = setEthType(shortcut.ethType); err == nil && shortcut.ipProto != 0 {
+				err = setIPProto(shortcut.ipProto)
+			}
+		} else if name == "dl_type" {
+			var t uint64
+			if t, err = strconv.ParseUint(value, 0, 16); err == nil {
+				err = setEthType(uint16(t))
+			}
+		} else if name == "nw_proto" {
+			var p uint64
+			if p, err = strconv.ParseUint(value, 0, 8); err == nil {
+				err = setIPProto(uint8(p))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid token %q: %w", name, err)
+		}
+	}
+
+	builder := NewMatchBuilder()
+	if ethType != 0 {
+		builder.AddField(*NewEthTypeField(ethType))
+	}
+	if ipProto != 0 {
+		builder.AddField(*NewIpProtoField(ipProto))
+	}
+	for _, token := range tokens {
+		name, value := token[0], token[1]
+		if _, ok := ovsProtocolShortcuts[name]; (ok && value == "") || name == "dl_type" || name == "nw_proto" {
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("unknown token %q", name)
+		}
+		f, err := parseOVSMatchField(name, value, ethType, ipProto)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token %q: %w", name, err)
+		}
+		builder.AddField(*f)
+	}
+	return builder.WithImplicitPrerequisites().Build(), nil
+}
+
+// parseOVSMatchField returns the MatchField of the OVS match token name=value.
+func parseOVSMatchField(name, value string, ethType uint16, ipProto uint8) (*MatchField, error) {
+	switch name {
+	case "in_port":
+		port, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		return NewInPortField(uint32(port)), nil
+	case "dl_src", "dl_dst":
+		field := uint8(OXM_FIELD_ETH_SRC)
+		if name == "dl_dst" {
+			field = OXM_FIELD_ETH_DST
+		}
+		return parseOVSEthField(field, value)
+	case "nw_src", "nw_dst":
+		return parseOVSIPField(name == "nw_src", value, ethType)
+	case "tp_src", "tp_dst":
+		port, err := strconv.ParseUint(value, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		return newTransportPortField(name == "tp_src", uint16(port), ipProto)
+	}
+	if idx, ok := strings.CutPrefix(name, "reg"); ok {
+		return parseOVSRegField(idx, value)
+	}
+	return nil, fmt.Errorf("unknown field")
+}
+
+func parseOVSEthField(field uint8, value string) (*MatchField, error) {
+	addr, maskStr, hasMask := strings.Cut(value, "/")
+	mac, err := net.ParseMAC(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !hasMask {
+		if field == OXM_FIELD_ETH_SRC {
+			return NewEthSrcField(mac, nil), nil
+		}
+		return NewEthDstField(mac, nil), nil
+	}
+	mask, err := net.ParseMAC(maskStr)
+	if err != nil {
+		return nil, err
+	}
+	return NewMaskedEthField(field, mac, mask, false)
+}
+
+func parseOVSIPField(isSrc bool, value string, ethType uint16) (*MatchField, error) {
+	addr, maskStr, hasMask := strings.Cut(value, "/")
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %s", addr)
+	}
+	isIPv4 := ip.To4() != nil
+	if ethType == protocol.ARP_MSG {
+		if !isIPv4 || hasMask {
+			return nil, fmt.Errorf("ARP address must be an IPv4 address without mask")
+		}
+		if isSrc {
+			return NewArpSpaField(ip), nil
+		}
+		return NewArpTpaField(ip), nil
+	}
+	if (ethType == protocol.IPv4_MSG && !isIPv4) || (ethType == protocol.IPv6_MSG && isIPv4) {
+		return nil, fmt.Errorf("IP address %s doesn't match eth_type 0x%04x", addr, ethType)
+	}
+	var field uint8
+	switch {
+	case isIPv4 && isSrc:
+		field = OXM_FIELD_IPV4_SRC
+	case isIPv4:
+		field = OXM_FIELD_IPV4_DST
+	case isSrc:
+		field = OXM_FIELD_IPV6_SRC
+	default:
+		field = OXM_FIELD_IPV6_DST
+	}
+	if !hasMask {
+		switch field {
+		case OXM_FIELD_IPV4_SRC:
+			return NewIpv4SrcField(ip.To4(), nil), nil
+		case OXM_FIELD_IPV4_DST:
+			return NewIpv4DstField(ip.To4(), nil), nil
+		case OXM_FIELD_IPV6_SRC:
+			return NewIpv6SrcField(ip, nil), nil
+		default:
+			return NewIpv6DstField(ip, nil), nil
+		}
+	}
+	var mask net.IP
+	if prefixLen, err := strconv.Atoi(maskStr); err == nil {
+		bits := net.IPv6len * 8
+		if isIPv4 {
+			bits = net.IPv4len * 8
+		}
+		if prefixLen < 0 || prefixLen > bits {
+			return nil, fmt.Errorf("invalid prefix length %d", prefixLen)
+		}
+		mask = net.IP(net.CIDRMask(prefixLen, bits))
+	} else if mask = net.ParseIP(maskStr); mask == nil {
+		return nil, fmt.Errorf("invalid IP mask %s", maskStr)
+	}
+	if !isIPv4 {
+		mask = mask.To16()
+	}
+	return NewMaskedIPField(field, ip, mask, true)
+}
+
+// newTransportPortField returns the TCP, UDP or SCTP source or destination port field according to
+// ipProto.
+func newTransportPortField(isSrc bool, port uint16, ipProto uint8) (*MatchField, error) {
+	switch {
+	case ipProto == protocol.Type_TCP && isSrc:
+		return NewTcpSrcField(port), nil
+	case ipProto == protocol.Type_TCP:
+		return NewTcpDstField(port), nil
+	case ipProto == protocol.Type_UDP && isSrc:
+		return NewUdpSrcField(port), nil
+	case ipProto == protocol.Type_UDP:
+		return NewUdpDstField(port), nil
+	case ipProto == protocol.Type_SCTP && isSrc:
+		return NewSctpSrcField(port), nil
+	case ipProto == protocol.Type_SCTP:
+		return NewSctpDstField(port), nil
+	}
+	return nil, fmt.Errorf("transport port requires tcp, udp or sctp")
+}
+
+func parseOVSRegField(idx, value string) (*MatchField, error) {
+	i, err := strconv.Atoi(idx)
+	if err != nil || i < 0 || i > 15 {
+		return nil, fmt.Errorf("unknown register reg%s", idx)
+	}
+	dataStr, maskStr, hasMask := strings.Cut(value, "/")
+	data, err := strconv.ParseUint(dataStr, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+	if !hasMask {
+		return NewRegMatchField(i, uint32(data), nil), nil
+	}
+	mask, err := strconv.ParseUint(maskStr, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+	if mask == 0 {
+		return nil, fmt.Errorf("register mask must not be 0")
+	}
+	return NewRegMatchFieldWithMask(i, uint32(data), uint32(mask)), nil
+}
@@ -0,0 +1,81 @@
+package openflow15
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseOVSMatch(t *testing.T) {
+	ipMask := net.IP{255, 255, 255, 0}
+	for _, tc := range []struct {
+		flow     string
+		expected *Match
+	}{
+		{
+			flow: "ip,nw_src=10.0.0.0/24,tcp,tp_dst=80",
+			expected: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x0800)).
+				AddField(*NewIpProtoField(6)).
+				AddField(*NewIpv4SrcField(net.IP{10, 0, 0, 0}, &ipMask)).
+				AddField(*NewTcpDstField(80)).
+				Build(),
+		},
+		{
+			flow: "udp6,in_port=3,nw_dst=2001:db8::1,tp_src=53",
+			expected: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x86dd)).
+				AddField(*NewIpProtoField(17)).
+				AddField(*NewInPortField(3)).
+				AddField(*NewIpv6DstField(net.ParseIP("2001:db8::1"), nil)).
+				AddField(*NewUdpSrcField(53)).
+				Build(),
+		},
+		{
+			flow: "arp,dl_src=00:11:22:33:44:55,nw_dst=10.0.0.1",
+			expected: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x0806)).
+				AddField(*NewEthSrcField(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, nil)).
+				AddField(*NewArpTpaField(net.IP{10, 0, 0, 1})).
+				Build(),
+		},
+		{
+			// The prerequisites are added if no protocol token is given.
+			flow: "nw_dst=10.0.0.1, reg1=0x5/0xf",
+			expected: NewMatchBuilder().
+				AddField(*NewEthTypeField(0x0800)).
+				AddField(*NewIpv4DstField(net.IP{10, 0, 0, 1}, nil)).
+				AddField(*NewRegMatchFieldWithMask(1, 5, 0xf)).
+				Build(),
+		},
+	} {
+		m, err := ParseOVSMatch(tc.flow)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tc.flow, err)
+			continue
+		}
+		if m.Fingerprint() != tc.expected.Fingerprint() {
+			t.Errorf("Unexpected match for %q, expected %s, got %s", tc.flow, tc.expected, m)
+		}
+		if err := checkMatchSerializationConsistency(m); err != nil {
+			t.Errorf("Match serialization is inconsistent for %q: %v", tc.flow, err)
+		}
+	}
+}
+
+func TestParseOVSMatchErrors(t *testing.T) {
+	for _, flow := range []string{
+		"ip,foo=1",
+		"ip,bogus",
+		"tp_dst=80",
+		"ip,ipv6",
+		"tcp,udp",
+		"ip,nw_src=2001:db8::1",
+		"ip,nw_src=10.0.0.0/33",
+		"reg16=1",
+		"dl_dst=00:11:22",
+	} {
+		if _, err := ParseOVSMatch(flow); err == nil {
+			t.Errorf("Expected an error when parsing %q", flow)
+		}
+	}
+}
@@ -555,6 +555,19 @@ const (
 	P_ANY        = 0xffffffff
 )
 
+// reservedPortNames is map to find the name of a reserved port by the port number.
+var reservedPortNames = map[uint32]string{
+	P_UNSET:      "UNSET",
+	P_IN_PORT:    "IN_PORT",
+	P_TABLE:      "TABLE",
+	P_NORMAL:     "NORMAL",
+	P_FLOOD:      "FLOOD",
+	P_ALL:        "ALL",
+	P_CONTROLLER: "CONTROLLER",
+	P_LOCAL:      "LOCAL",
+	P_ANY:        "ANY",
+}
+
 // ofp_port_features
 const (
 	PF_10MB_HD  = 1 << 0
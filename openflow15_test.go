@@ -13,6 +13,7 @@ import (
 	"antrea.io/libOpenflow/common"
 	"antrea.io/libOpenflow/openflow15"
 	"antrea.io/libOpenflow/util"
+	"antrea.io/libOpenflow/util/testutil"
 )
 
 var eth_arp_payload string = "fffffffffffff26626a37d0c08060001" +
@@ -145,12 +146,7 @@ func Test_PacketOut(t *testing.T) {
 	pktOut.AddAction(aOut)
 
 	/* Data */
-	var b []byte
-	b, err = hex.DecodeString(eth_arp_payload)
-	if err != nil {
-		t.Errorf("hex DecodeString failed with error code: %v", err)
-	}
-	dataARP := util.NewBuffer(b)
+	dataARP := util.NewBuffer(testutil.DecodeHex(t, eth_arp_payload))
 	pktOut.Data = dataARP
 
 	n := openflow15.NewPacketOut()
@@ -168,7 +164,7 @@ func Test_PacketIn(t *testing.T) {
 	pktIn.Cookie = 0x08090a0b0c0d0e0f
 
 	/* Data */
-	a, _ := hex.DecodeString(eth_arp_payload)
+	a := testutil.DecodeHex(t, eth_arp_payload)
 	dataARP := util.NewBuffer(a)
 	pktIn.Data = dataARP
 
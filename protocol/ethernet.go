@@ -29,6 +29,10 @@ type Ethernet struct {
 	VLANID    VLAN
 	Ethertype uint16
 	Data      util.Message
+	// Padding is the trailing bytes after Data, which are added to frames shorter than the minimum
+	// Ethernet frame size of 60 bytes. It is only set when the length of Data could be known from
+	// its headers, e.g. for ARP.
+	Padding []byte
 }
 
 func NewEthernet() *Ethernet {
@@ -51,6 +55,7 @@ func (e *Ethernet) Len() (n uint16) {
 	if e.Data != nil {
 		n += e.Data.Len()
 	}
+	n += uint16(len(e.Padding))
 	return
 }
 
@@ -79,7 +84,9 @@ func (e *Ethernet) MarshalBinary() (data []byte, err error) {
 			return
 		}
 		copy(data[n:n+len(bytes)], bytes)
+		n += len(bytes)
 	}
+	copy(data[n:], e.Padding)
 	return
 }
 
@@ -121,7 +128,15 @@ func (e *Ethernet) UnmarshalBinary(data []byte) error {
 	default:
 		e.Data = new(util.Buffer)
 	}
-	return e.Data.UnmarshalBinary(data[n:])
+	if err := e.Data.UnmarshalBinary(data[n:]); err != nil {
+		return err
+	}
+	e.Padding = nil
+	if dataLen := int(e.Data.Len()); dataLen < len(data[n:]) {
+		e.Padding = make([]byte, len(data[n:])-dataLen)
+		copy(e.Padding, data[n+dataLen:])
+	}
+	return nil
 }
 
 const (
@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthernetPadding(t *testing.T) {
+	arp, _ := NewARP(Type_Request)
+	arp.HWSrc, _ = net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	arp.IPSrc = net.ParseIP("10.0.0.1").To4()
+	arp.IPDst = net.ParseIP("10.0.0.2").To4()
+	eth := NewEthernet()
+	eth.HWDst, _ = net.ParseMAC("ff:ff:ff:ff:ff:ff")
+	eth.HWSrc = arp.HWSrc
+	eth.Ethertype = ARP_MSG
+	eth.Data = arp
+	data, err := eth.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, data, 42)
+	// Pad the frame to the minimum Ethernet frame size.
+	frame := append(data, make([]byte, 18)...)
+
+	newEth := new(Ethernet)
+	require.NoError(t, newEth.UnmarshalBinary(frame))
+	assert.Equal(t, arp, newEth.Data)
+	assert.Equal(t, make([]byte, 18), newEth.Padding)
+	assert.Equal(t, uint16(60), newEth.Len())
+	newData, err := newEth.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, frame, newData)
+
+	// No padding is set for an unpadded frame.
+	newEth = new(Ethernet)
+	require.NoError(t, newEth.UnmarshalBinary(data))
+	assert.Nil(t, newEth.Padding)
+}
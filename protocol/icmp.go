@@ -5,6 +5,11 @@ import (
 	"errors"
 )
 
+const (
+	ICMP_Type_EchoReply   = 0
+	ICMP_Type_EchoRequest = 8
+)
+
 type ICMP struct {
 	Type     uint8
 	Code     uint8
@@ -43,3 +48,29 @@ func (i *ICMP) UnmarshalBinary(data []byte) error {
 	copy(i.Data, data[4:])
 	return nil
 }
+
+// ComputeChecksum returns the Internet checksum of the ICMP message computed with the Checksum field
+// as zero. It equals Checksum if the message is intact.
+func (i *ICMP) ComputeChecksum() uint16 {
+	data, _ := i.MarshalBinary()
+	data[2], data[3] = 0, 0
+	return internetChecksum(data)
+}
+
+// Identifier returns the identifier of an echo request or reply, which are the first 2 bytes of
+// Data. It returns 0 if Data is too short.
+func (i *ICMP) Identifier() uint16 {
+	if len(i.Data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(i.Data[0:2])
+}
+
+// SeqNum returns the sequence number of an echo request or reply, which are the bytes 2 to 4 of
+// Data. It returns 0 if Data is too short.
+func (i *ICMP) SeqNum() uint16 {
+	if len(i.Data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(i.Data[2:4])
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+func TestICMPEchoRequest(t *testing.T) {
+	// An echo request sent by ping with a 56-byte payload.
+	data := testutil.DecodeHex(t, "080080fb1c2b0001"+"c5a1d66300000000"+
+		"101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f3031323334353637")
+
+	icmp := NewICMP()
+	require.NoError(t, icmp.UnmarshalBinary(data))
+	assert.Equal(t, uint8(ICMP_Type_EchoRequest), icmp.Type)
+	assert.Equal(t, uint8(0), icmp.Code)
+	assert.Equal(t, uint16(0x80fb), icmp.Checksum)
+	assert.Equal(t, uint16(0x1c2b), icmp.Identifier())
+	assert.Equal(t, uint16(1), icmp.SeqNum())
+	assert.Equal(t, icmp.Checksum, icmp.ComputeChecksum())
+
+	b, err := icmp.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	// A corrupted message doesn't match its checksum.
+	icmp.Data[10] ^= 0xff
+	assert.NotEqual(t, icmp.Checksum, icmp.ComputeChecksum())
+
+	// The checksum is computed with an odd-length message padded with a zero byte.
+	reply := &ICMP{Type: ICMP_Type_EchoReply, Data: []byte{0x1c, 0x2b, 0x00, 0x01, 0xab}}
+	reply.Checksum = reply.ComputeChecksum()
+	assert.Equal(t, uint16(0x38d3), reply.Checksum)
+	assert.Equal(t, uint16(0), (&ICMP{Data: []byte{1}}).SeqNum())
+}
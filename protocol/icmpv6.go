@@ -106,11 +106,13 @@ func (i *ICMPv6EchoReqRpl) MarshalBinary() (data []byte, err error) {
 	n += 2
 	binary.BigEndian.PutUint16(data[n:], i.SeqNum)
 	n += 2
-	dataBytes, err := i.Data.MarshalBinary()
-	if err != nil {
-		return nil, err
+	if i.Data != nil {
+		dataBytes, err := i.Data.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		copy(data[n:], dataBytes)
 	}
-	copy(data[n:], dataBytes)
 	return data, nil
 }
 
@@ -160,6 +162,71 @@ func NewICMPv6EchoReply(identifier, sequenceNumber uint16) *ICMPv6EchoReqRpl {
 	}
 }
 
+// ICMPv6PacketTooBig is the ICMPv6 Packet Too Big message, see RFC 4443. Data is as much of the
+// invoking packet as possible, which is usually truncated.
+//
+//	 0                   1                   2                   3
+//	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |     Code      |          Checksum             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                             MTU                               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                    As much of invoking packet                 |
+// +               as possible without the ICMPv6 packet           +
+// |               exceeding the minimum IPv6 MTU                  |
+type ICMPv6PacketTooBig struct {
+	ICMPv6Header
+	MTU  uint32
+	Data []byte
+}
+
+func (p *ICMPv6PacketTooBig) Len() uint16 {
+	return 8 + uint16(len(p.Data))
+}
+
+func (p *ICMPv6PacketTooBig) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(p.Len()))
+	n := 0
+	b, err := p.ICMPv6Header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	copy(data[n:], b)
+	n += len(b)
+	binary.BigEndian.PutUint32(data[n:], p.MTU)
+	n += 4
+	copy(data[n:], p.Data)
+	return data, nil
+}
+
+func (p *ICMPv6PacketTooBig) UnmarshalBinary(data []byte) error {
+	if err := p.ICMPv6Header.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if len(data) < 8 {
+		return errors.New("The []byte is too short to unmarshal a full ICMPv6PacketTooBig message.")
+	}
+	n := p.ICMPv6Header.Len()
+	p.MTU = binary.BigEndian.Uint32(data[n:])
+	n += 4
+	p.Data = make([]byte, len(data[n:]))
+	copy(p.Data, data[n:])
+	return nil
+}
+
+func NewICMPv6PacketTooBig(mtu uint32, invokingPacket []byte) *ICMPv6PacketTooBig {
+	return &ICMPv6PacketTooBig{
+		ICMPv6Header: ICMPv6Header{
+			Type: ICMPv6_ErrType_Packet_Large,
+			Code: 0,
+		},
+		MTU:  mtu,
+		Data: invokingPacket,
+	}
+}
+
 type ICMPv6Error ICMPv6Header
 
 //	 0                   1                   2                   3
@@ -646,6 +713,216 @@ func NewICMPv6ByHeaderType(packetType uint8) util.Message {
 		return new(MLD)
 	case ICMPv6_Type_MLDv2_Report:
 		return new(MLDv2Report)
+	case ICMPv6_Type_Router_Advertisement:
+		return new(RouterAdvertisement)
+	case ICMPv6_ErrType_Packet_Large:
+		return new(ICMPv6PacketTooBig)
 	}
 	return new(util.Buffer)
 }
+
+const (
+	ICMPv6_Type_Router_Advertisement = 134
+
+	// Types of the Neighbor Discovery options, see RFC 4861.
+	NDOptionType_Source_Link_Layer_Address = 1
+	NDOptionType_Target_Link_Layer_Address = 2
+	NDOptionType_Prefix_Information        = 3
+	NDOptionType_MTU                       = 5
+)
+
+// NDOption is a Neighbor Discovery option, Length is in units of 8 bytes including Type and Length.
+//
+//	0                   1                   2                   3
+//	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |    Length     |              ...              |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// ~                              ...                              ~
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type NDOption struct {
+	Type   uint8
+	Length uint8
+	Data   []byte
+}
+
+func (o *NDOption) Len() uint16 {
+	return uint16(o.Length) * 8
+}
+
+func (o *NDOption) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(o.Len()))
+	data[0] = o.Type
+	data[1] = o.Length
+	copy(data[2:], o.Data)
+	return data, nil
+}
+
+func (o *NDOption) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("The []byte is too short to unmarshal a full NDOption message.")
+	}
+	o.Type = data[0]
+	o.Length = data[1]
+	if o.Length == 0 {
+		return errors.New("NDOption with zero length is invalid")
+	}
+	if len(data) < int(o.Len()) {
+		return fmt.Errorf("The []byte is too short to unmarshal NDOption with %d bytes", o.Len())
+	}
+	o.Data = make([]byte, int(o.Len())-2)
+	copy(o.Data, data[2:o.Len()])
+	switch o.Type {
+	case NDOptionType_Prefix_Information:
+		if o.Length != 4 {
+			return fmt.Errorf("Prefix Information option has invalid length %d", o.Length)
+		}
+	case NDOptionType_MTU:
+		if o.Length != 1 {
+			return fmt.Errorf("MTU option has invalid length %d", o.Length)
+		}
+	}
+	return nil
+}
+
+// PrefixInformation is the decoded Prefix Information option.
+//
+//	0                   1                   2                   3
+//	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |    Length     | Prefix Length |L|A| Reserved1 |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                         Valid Lifetime                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                       Preferred Lifetime                      |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                           Reserved2                           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                                                               |
+// +                            Prefix                             +
+// |                                                               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type PrefixInformation struct {
+	PrefixLength      uint8
+	OnLink            bool
+	Autonomous        bool
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+	Prefix            net.IP
+}
+
+// RouterAdvertisement is the ICMPv6 Router Advertisement message.
+//
+//	0                   1                   2                   3
+//	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Type      |     Code      |          Checksum             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// | Cur Hop Limit |M|O|  Reserved |       Router Lifetime         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                         Reachable Time                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          Retrans Timer                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |   Options ...
+// +-+-+-+-+-+-+-+-+-+-+-+-
+type RouterAdvertisement struct {
+	ICMPv6Header
+	CurHopLimit    uint8
+	Flags          uint8
+	RouterLifetime uint16
+	ReachableTime  uint32
+	RetransTimer   uint32
+	Options        []*NDOption
+}
+
+func (r *RouterAdvertisement) Len() uint16 {
+	n := uint16(16)
+	for _, o := range r.Options {
+		n += o.Len()
+	}
+	return n
+}
+
+func (r *RouterAdvertisement) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, int(r.Len()))
+	b, err := r.ICMPv6Header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	n := copy(data, b)
+	data[n] = r.CurHopLimit
+	data[n+1] = r.Flags
+	binary.BigEndian.PutUint16(data[n+2:], r.RouterLifetime)
+	binary.BigEndian.PutUint32(data[n+4:], r.ReachableTime)
+	binary.BigEndian.PutUint32(data[n+8:], r.RetransTimer)
+	n += 12
+	for _, o := range r.Options {
+		if b, err = o.MarshalBinary(); err != nil {
+			return nil, err
+		}
+		n += copy(data[n:], b)
+	}
+	return data, nil
+}
+
+func (r *RouterAdvertisement) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("The []byte is too short to unmarshal a full RouterAdvertisement message.")
+	}
+	if err := r.ICMPv6Header.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	n := int(r.ICMPv6Header.Len())
+	r.CurHopLimit = data[n]
+	r.Flags = data[n+1]
+	r.RouterLifetime = binary.BigEndian.Uint16(data[n+2:])
+	r.ReachableTime = binary.BigEndian.Uint32(data[n+4:])
+	r.RetransTimer = binary.BigEndian.Uint32(data[n+8:])
+	n += 12
+	r.Options = nil
+	for n < len(data) {
+		o := new(NDOption)
+		if err := o.UnmarshalBinary(data[n:]); err != nil {
+			return err
+		}
+		r.Options = append(r.Options, o)
+		n += int(o.Len())
+	}
+	return nil
+}
+
+// Prefixes returns the decoded Prefix Information options in the RouterAdvertisement.
+func (r *RouterAdvertisement) Prefixes() []PrefixInformation {
+	var prefixes []PrefixInformation
+	for _, o := range r.Options {
+		if o.Type != NDOptionType_Prefix_Information || len(o.Data) < 30 {
+			continue
+		}
+		prefix := make(net.IP, net.IPv6len)
+		copy(prefix, o.Data[14:30])
+		prefixes = append(prefixes, PrefixInformation{
+			PrefixLength:      o.Data[0],
+			OnLink:            o.Data[1]&0x80 != 0,
+			Autonomous:        o.Data[1]&0x40 != 0,
+			ValidLifetime:     binary.BigEndian.Uint32(o.Data[2:]),
+			PreferredLifetime: binary.BigEndian.Uint32(o.Data[6:]),
+			Prefix:            prefix,
+		})
+	}
+	return prefixes
+}
+
+// MTU returns the MTU in the MTU option of the RouterAdvertisement, and false if there is no MTU
+// option.
+func (r *RouterAdvertisement) MTU() (uint32, bool) {
+	for _, o := range r.Options {
+		if o.Type == NDOptionType_MTU && len(o.Data) >= 6 {
+			return binary.BigEndian.Uint32(o.Data[2:]), true
+		}
+	}
+	return 0, false
+}
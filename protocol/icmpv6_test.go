@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+func TestRouterAdvertisement(t *testing.T) {
+	// A Router Advertisement with a source link-layer address option, an MTU option of 1500 and a
+	// prefix information option of 2001:db8::/64.
+	data := testutil.DecodeHex(t, "8600a1b2"+"40000708"+"00000000"+"00000000"+
+		"0101aabbccddeeff"+
+		"05010000000005dc"+
+		"030440c0"+"00278d00"+"00093a80"+"00000000"+"20010db8000000000000000000000000")
+
+	msg := NewICMPv6ByHeaderType(data[0])
+	require.IsType(t, new(RouterAdvertisement), msg)
+	require.NoError(t, msg.UnmarshalBinary(data))
+	ra := msg.(*RouterAdvertisement)
+	assert.Equal(t, uint8(64), ra.CurHopLimit)
+	assert.Equal(t, uint16(1800), ra.RouterLifetime)
+	require.Len(t, ra.Options, 3)
+	assert.Equal(t, uint8(NDOptionType_Source_Link_Layer_Address), ra.Options[0].Type)
+
+	mtu, ok := ra.MTU()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1500), mtu)
+	prefixes := ra.Prefixes()
+	require.Len(t, prefixes, 1)
+	assert.Equal(t, PrefixInformation{
+		PrefixLength:      64,
+		OnLink:            true,
+		Autonomous:        true,
+		ValidLifetime:     2592000,
+		PreferredLifetime: 604800,
+		Prefix:            net.ParseIP("2001:db8::"),
+	}, prefixes[0])
+
+	newData, err := ra.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, newData)
+	assert.Equal(t, uint16(len(data)), ra.Len())
+
+	// Truncated option.
+	assert.Error(t, new(RouterAdvertisement).UnmarshalBinary(data[:len(data)-1]))
+	// Option with zero length.
+	invalid := append([]byte{}, data[:24]...)
+	invalid = append(invalid, 0x01, 0x00, 0, 0, 0, 0, 0, 0)
+	assert.Error(t, new(RouterAdvertisement).UnmarshalBinary(invalid))
+	// Prefix information option with invalid length.
+	invalid = append([]byte{}, data[:24]...)
+	invalid = append(invalid, 0x03, 0x01, 0, 0, 0, 0, 0, 0)
+	assert.Error(t, new(RouterAdvertisement).UnmarshalBinary(invalid))
+}
+
+func TestICMPv6PacketTooBig(t *testing.T) {
+	// An IPv6 packet from 2001:db8::1 to 2001:db8::2 carrying a Packet Too Big message with MTU 1400,
+	// which embeds the IPv6 header and the UDP header of the invoking packet from 2001:db8::2 to
+	// 2001:db8::3.
+	invoking := "6000000005c01140" + "20010db8000000000000000000000002" + "20010db8000000000000000000000003" +
+		"d431003505c00000"
+	data := testutil.DecodeHex(t, "6000000000383a40"+"20010db8000000000000000000000001"+"20010db8000000000000000000000002"+
+		"02001234"+"00000578"+invoking)
+
+	ipv6 := new(IPv6)
+	require.NoError(t, ipv6.UnmarshalBinary(data))
+	require.IsType(t, new(ICMPv6PacketTooBig), ipv6.Data)
+	ptb := ipv6.Data.(*ICMPv6PacketTooBig)
+	assert.Equal(t, uint8(ICMPv6_ErrType_Packet_Large), ptb.Type)
+	assert.Equal(t, uint32(1400), ptb.MTU)
+	invokingData := testutil.DecodeHex(t, invoking)
+	assert.Equal(t, invokingData, ptb.Data)
+
+	// The embedded datagram is truncated, but its headers are readable.
+	original := new(IPv6)
+	require.NoError(t, original.UnmarshalBinary(ptb.Data))
+	assert.Equal(t, net.ParseIP("2001:db8::3"), original.NWDst)
+	assert.Equal(t, uint8(Type_UDP), original.NextHeader)
+
+	newData, err := ptb.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data[40:], newData)
+	assert.Equal(t, NewICMPv6PacketTooBig(1400, invokingData).Len(), ptb.Len())
+
+	assert.Error(t, new(ICMPv6PacketTooBig).UnmarshalBinary(data[40:46]))
+}
@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 
 	"antrea.io/libOpenflow/util"
@@ -15,6 +16,7 @@ const (
 	Type_UDP      = 0x11
 	Type_IPv6     = 0x29
 	Type_IPv6ICMP = 0x3a
+	Type_SCTP     = 0x84
 )
 
 type IPv4 struct {
@@ -139,6 +141,9 @@ func (i *IPv4) UnmarshalBinary(data []byte) error {
 	copy(i.NWDst, data[n:n+4])
 	n += 4
 
+	if err := i.validateIHL(len(data)); err != nil {
+		return err
+	}
 	err := i.Options.UnmarshalBinary(data[n:int(i.IHL*4)])
 	if err != nil {
 		return err
@@ -151,7 +156,48 @@ func (i *IPv4) UnmarshalBinary(data []byte) error {
 	case Type_UDP:
 		i.Data = NewUDP()
 	default:
-		i.Data = new(util.Buffer)
+		i.Data = newL4Message(i.Protocol)
 	}
 	return i.Data.UnmarshalBinary(data[n:])
 }
+
+// validateIHL checks IHL, which is the IPv4 header length in 32-bit words, is at least 5 and the
+// options it implies are in the packet of length bytes.
+func (i *IPv4) validateIHL(length int) error {
+	if i.IHL < 5 {
+		return fmt.Errorf("invalid IPv4 header length %d, it should be at least 5", i.IHL)
+	}
+	if hdrLen := int(i.IHL) * 4; hdrLen > length {
+		return fmt.Errorf("IPv4 header length %d implies %d bytes of header, but there are only %d bytes", i.IHL, hdrLen, length)
+	}
+	return nil
+}
+
+// ComputeChecksum returns the checksum of the IPv4 header, including the options, computed with
+// the Checksum field as zero. It equals Checksum if the header is intact.
+func (i *IPv4) ComputeChecksum() uint16 {
+	data, _ := i.MarshalBinary()
+	hdrLen := int(i.IHL) * 4
+	if hdrLen > len(data) {
+		hdrLen = len(data)
+	}
+	header := data[:hdrLen]
+	header[10], header[11] = 0, 0
+	return internetChecksum(header)
+}
+
+// internetChecksum returns the Internet checksum defined in RFC 1071 of data, an odd length of
+// data is padded with a zero byte.
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for n := 0; n+1 < len(data); n += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[n:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
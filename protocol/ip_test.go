@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+func TestIPv4WithOptions(t *testing.T) {
+	// An IPv4 packet with the Router Alert option, which makes IHL 6, carrying a UDP datagram.
+	data := testutil.DecodeHex(t, "46000024000140004011"+"91c1"+"0a000001"+"0a000002"+"94040000"+
+		"04d2162e000c0000"+"deadbeef")
+
+	ip := NewIPv4()
+	require.NoError(t, ip.UnmarshalBinary(data))
+	assert.Equal(t, uint8(4), ip.Version)
+	assert.Equal(t, uint8(6), ip.IHL)
+	assert.Equal(t, uint16(0x24), ip.Length)
+	assert.Equal(t, uint16(2), ip.Flags)
+	assert.Equal(t, uint8(64), ip.TTL)
+	assert.Equal(t, uint8(Type_UDP), ip.Protocol)
+	assert.Equal(t, net.IP{10, 0, 0, 1}, ip.NWSrc)
+	assert.Equal(t, net.IP{10, 0, 0, 2}, ip.NWDst)
+	assert.Equal(t, []byte{0x94, 0x04, 0x00, 0x00}, ip.Options.Bytes())
+	assert.Equal(t, uint16(0x91c1), ip.ComputeChecksum())
+	assert.Equal(t, data[24:], ip.Payload())
+	udp, ok := ip.Data.(*UDP)
+	require.True(t, ok)
+	assert.Equal(t, uint16(5678), udp.PortDst)
+
+	b, err := ip.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	ip.TTL = 63
+	assert.NotEqual(t, ip.Checksum, ip.ComputeChecksum())
+}
+
+func TestIPv4InvalidIHL(t *testing.T) {
+	data := testutil.DecodeHex(t, "45000014000140004011"+"0000"+"0a000001"+"0a000002")
+	for _, ihl := range []byte{4, 6} {
+		data[0] = 0x40 | ihl
+		assert.Error(t, NewIPv4().UnmarshalBinary(data), "IHL %d", ihl)
+	}
+}
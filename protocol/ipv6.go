@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 
 	"antrea.io/libOpenflow/util"
@@ -12,8 +13,24 @@ const (
 	Type_HBH      = 0x0
 	Type_Routing  = 0x2b
 	Type_Fragment = 0x2c
+	Type_AH       = 0x33
+	Type_DstOpts  = 0x3c
 )
 
+// ipv6GenericExtHeaders are the IPv6 extension headers in the format of RFC 6564, the length of
+// which is in 8-octet units not including the first 8 octets. They are skipped by length when
+// looking for the upper-layer protocol.
+var ipv6GenericExtHeaders = map[uint8]bool{
+	Type_HBH:     true,
+	Type_Routing: true,
+	Type_DstOpts: true,
+	135:          true, // Mobility Header
+	139:          true, // Host Identity Protocol
+	140:          true, // Shim6 Protocol
+	253:          true, // Use for experimentation and testing
+	254:          true, // Use for experimentation and testing
+}
+
 type IPv6 struct {
 	Version        uint8 //4-bits
 	TrafficClass   uint8
@@ -173,13 +190,59 @@ checkXHeader:
 			i.Data = NewUDP()
 			break checkXHeader
 		default:
-			i.Data = new(util.Buffer)
+			i.Data = newL4Message(nxtHeader)
 			break checkXHeader
 		}
 	}
 	return i.Data.UnmarshalBinary(data[n:])
 }
 
+// IPv6UpperLayer walks the extension headers of the IPv6 packet in data, and returns the protocol
+// number of the upper-layer header, e.g. Type_TCP, and its offset in data. The extension headers
+// are skipped by length, including the ones which are not decoded by IPv6.UnmarshalBinary such as
+// the Destination Options header. The walk stops at a header which isn't an extension header, or
+// which can't be skipped, e.g. ESP.
+func IPv6UpperLayer(data []byte) (uint8, int, error) {
+	if len(data) < 40 {
+		return 0, 0, errors.New("The []byte is too short to contain an IPv6 header.")
+	}
+	proto := data[6]
+	offset := 40
+	for {
+		if !ipv6GenericExtHeaders[proto] && proto != Type_Fragment && proto != Type_AH {
+			return proto, offset, nil
+		}
+		if len(data) < offset+2 {
+			return 0, 0, fmt.Errorf("extension header %d at offset %d exceeds the packet of %d bytes", proto, offset, len(data))
+		}
+		hdrLen := 8
+		if ipv6GenericExtHeaders[proto] {
+			hdrLen = 8 * (int(data[offset+1]) + 1)
+		} else if proto == Type_AH {
+			hdrLen = 4 * (int(data[offset+1]) + 2)
+		}
+		if len(data) < offset+hdrLen {
+			return 0, 0, fmt.Errorf("extension header %d at offset %d exceeds the packet of %d bytes", proto, offset, len(data))
+		}
+		proto = data[offset]
+		offset += hdrLen
+	}
+}
+
+// UpperLayer returns the protocol number of the upper-layer header after the extension headers,
+// and the upper-layer header and payload in bytes.
+func (i *IPv6) UpperLayer() (uint8, []byte, error) {
+	data, err := i.MarshalBinary()
+	if err != nil {
+		return 0, nil, err
+	}
+	proto, offset, err := IPv6UpperLayer(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return proto, data[offset:], nil
+}
+
 type Option struct {
 	Type   uint8
 	Length uint8
@@ -283,3 +283,54 @@ func testIPv6Equals(oriMessage, newMessage *IPv6) error {
 	}
 	return nil
 }
+
+func TestIPv6UpperLayer(t *testing.T) {
+	header := func(nextHeader uint8) []byte {
+		data := make([]byte, 40)
+		data[0] = 0x60
+		data[6] = nextHeader
+		data[7] = 64
+		copy(data[8:], net.ParseIP("2001:db8::1"))
+		copy(data[24:], net.ParseIP("2001:db8::2"))
+		return data
+	}
+	udp := []byte{0x04, 0xd2, 0x16, 0x2e, 0x00, 0x0a, 0x00, 0x00, 0xab, 0xcd}
+
+	// Hop-by-hop options, a fragment header and a destination options header of 16 bytes, which
+	// isn't decoded by IPv6 but skipped by its length.
+	data := header(Type_HBH)
+	data = append(data, Type_Fragment, 0, 0x01, 0x04, 0, 0, 0, 0)
+	data = append(data, Type_DstOpts, 0, 0x00, 0x01, 0x12, 0x34, 0x56, 0x78)
+	data = append(data, Type_UDP, 1, 0x01, 0x0c, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, udp...)
+	binary.BigEndian.PutUint16(data[4:], uint16(len(data)-40))
+
+	proto, offset, err := IPv6UpperLayer(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(Type_UDP), proto)
+	assert.Equal(t, 72, offset)
+
+	ip := new(IPv6)
+	require.NoError(t, ip.UnmarshalBinary(data))
+	require.NotNil(t, ip.FragmentHeader)
+	assert.True(t, ip.FragmentHeader.MoreFragments)
+	assert.Equal(t, uint32(0x12345678), ip.FragmentHeader.Identification)
+	proto, payload, err := ip.UpperLayer()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(Type_UDP), proto)
+	assert.Equal(t, udp, payload)
+
+	// An experimental extension header is skipped by its length as well.
+	data = header(253)
+	data = append(data, Type_TCP, 0, 0, 0, 0, 0, 0, 0)
+	proto, offset, err = IPv6UpperLayer(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(Type_TCP), proto)
+	assert.Equal(t, 48, offset)
+
+	// An extension header which exceeds the packet.
+	data = header(Type_DstOpts)
+	data = append(data, Type_UDP, 1, 0, 0, 0, 0, 0, 0)
+	_, _, err = IPv6UpperLayer(data)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"antrea.io/libOpenflow/util"
+)
+
+// Payloader is implemented by the protocol headers carrying a payload, so that a generic walker
+// could reach the payload of each layer without type switching.
+//
+// For TCP and UDP, Payload returns a sub-slice of Data, so modifying the returned bytes modifies
+// the message. For Ethernet, IPv4 and IPv6, the payload is a decoded Message, and Payload returns
+// a copy marshaled from it, which doesn't alias the message.
+type Payloader interface {
+	Payload() []byte
+	// NextLayer returns the decoded payload, or nil if the payload is not decoded.
+	NextLayer() util.Message
+}
+
+// messagePayload returns the marshaled msg, or nil if msg is nil or fails to be marshaled.
+func messagePayload(msg util.Message) []byte {
+	if msg == nil {
+		return nil
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (e *Ethernet) Payload() []byte {
+	return messagePayload(e.Data)
+}
+
+func (e *Ethernet) NextLayer() util.Message {
+	return e.Data
+}
+
+func (i *IPv4) Payload() []byte {
+	return messagePayload(i.Data)
+}
+
+func (i *IPv4) NextLayer() util.Message {
+	return i.Data
+}
+
+func (i *IPv6) Payload() []byte {
+	return messagePayload(i.Data)
+}
+
+func (i *IPv6) NextLayer() util.Message {
+	return i.Data
+}
+
+// Payload returns Data after the TCP options, or nil if HdrLen is invalid.
+func (t *TCP) Payload() []byte {
+	if t.validateHdrLen() != nil {
+		return nil
+	}
+	return t.Data[int(t.HdrLen)*4-20:]
+}
+
+// NextLayer returns nil as the TCP payload is not decoded.
+func (t *TCP) NextLayer() util.Message {
+	return nil
+}
+
+func (u *UDP) Payload() []byte {
+	return u.Data
+}
+
+// NextLayer returns the Application decoded by the parser registered with RegisterL7Parser.
+func (u *UDP) NextLayer() util.Message {
+	return u.Application
+}
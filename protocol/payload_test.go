@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util"
+)
+
+func TestPayloaderWalk(t *testing.T) {
+	// UDP is decoded natively by IPv4, so the walk doesn't depend on the parser registry.
+	udp := NewUDP()
+	udp.PortSrc = 34567
+	udp.PortDst = 5353
+	udp.Data = []byte("payload")
+	udp.Length = udp.Len()
+	ip := NewIPv4()
+	ip.Version = 4
+	ip.Protocol = Type_UDP
+	ip.NWSrc = net.ParseIP("10.0.0.1").To4()
+	ip.NWDst = net.ParseIP("10.0.0.2").To4()
+	ip.Data = udp
+	ip.Length = ip.Len()
+	eth := NewEthernet()
+	eth.Ethertype = IPv4_MSG
+	eth.Data = ip
+	data, err := eth.MarshalBinary()
+	require.NoError(t, err)
+
+	newEth := new(Ethernet)
+	require.NoError(t, newEth.UnmarshalBinary(data))
+	var layers int
+	var payload []byte
+	for layer := util.Message(newEth); layer != nil; {
+		p, ok := layer.(Payloader)
+		if !ok {
+			break
+		}
+		layers++
+		payload = p.Payload()
+		layer = p.NextLayer()
+	}
+	assert.Equal(t, 3, layers)
+	assert.Equal(t, udp.Data, payload)
+	ipPayload, err := udp.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, ipPayload, newEth.Data.(*IPv4).Payload())
+
+	// The TCP payload follows the options.
+	tcp := NewTCP()
+	tcp.PortSrc = 34567
+	tcp.PortDst = 80
+	tcp.HdrLen = 6
+	tcp.Data = append([]byte{1, 1, 1, 0}, "GET / HTTP/1.1\r\n"...)
+	tcpData, err := tcp.MarshalBinary()
+	require.NoError(t, err)
+	newTCP := NewTCP()
+	require.NoError(t, newTCP.UnmarshalBinary(tcpData))
+	assert.Equal(t, []byte("GET / HTTP/1.1\r\n"), newTCP.Payload())
+	assert.Nil(t, newTCP.NextLayer())
+}
@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util"
+)
+
+func TestMessageLen(t *testing.T) {
+	ipv4Addr := net.ParseIP("10.0.0.1")
+	ipv6Addr := net.ParseIP("ff02::1")
+	arp, _ := NewARP(Type_Request)
+	ipv4 := NewIPv4()
+	ipv4.Data = NewUDP()
+	eth := NewEthernet()
+	eth.Data = ipv4
+	tcp := NewTCP()
+	tcp.Data = []byte{1, 2, 3}
+	for name, msg := range map[string]util.Message{
+		"arp":           arp,
+		"ethernet":      eth,
+		"vlan":          NewVLAN(),
+		"icmp":          NewICMP(),
+		"tcp":           tcp,
+		"udp":           NewUDP(),
+		"ipv6":          &IPv6{NextHeader: Type_UDP, Data: NewUDP()},
+		"fragment":      NewFragmentHeader(),
+		"icmpv6_echo":   NewICMPv6EchoRequest(1, 1),
+		"mld_report":    NewMLDReport(ipv6Addr),
+		"mld_query":     NewMLDQuery(1, ipv6Addr),
+		"mldv2_query":   NewMLDv2Query(1, ipv6Addr, 1, []net.IP{ipv6Addr}),
+		"mldv2_report":  NewMLDv2Report([]MLDv2Record{*NewMLDv2Record(1, ipv6Addr, []net.IP{ipv6Addr})}),
+		"igmpv1_query":  NewIGMPv1Query(ipv4Addr),
+		"igmpv2_report": NewIGMPv2Report(ipv4Addr),
+		"igmpv3_query":  NewIGMPv3Query(ipv4Addr, 1, 1, []net.IP{ipv4Addr}),
+		"igmpv3_report": NewIGMPv3Report([]IGMPv3GroupRecord{NewGroupRecord(1, ipv4Addr, []net.IP{ipv4Addr})}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := msg.MarshalBinary()
+			require.NoError(t, err)
+			assert.Equal(t, int(msg.Len()), len(data))
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"sync"
+
+	"antrea.io/libOpenflow/util"
+)
+
+var (
+	parsersLock sync.RWMutex
+	// l4Parsers is map to find the factory of the Message used to decode an IP payload by the IP protocol number.
+	l4Parsers = map[uint8]func() util.Message{}
+	// l7Parsers is map to find the factory of the Message used to decode a UDP payload by the UDP port.
+	l7Parsers = map[uint16]func() util.Message{}
+)
+
+// RegisterL4Parser registers the factory of the Message used to decode the payload of IPv4 and IPv6
+// packets with protocol ipProto. The protocols natively supported by this package are not affected,
+// and the payload of an unknown protocol is decoded as raw bytes.
+func RegisterL4Parser(ipProto uint8, factory func() util.Message) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+	l4Parsers[ipProto] = factory
+}
+
+// RegisterL7Parser registers the factory of the Message used to decode the payload of UDP packets
+// sent to or from port. The decoded Message is stored in UDP.Application, and UDP.Data always keeps
+// the raw bytes.
+func RegisterL7Parser(port uint16, factory func() util.Message) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+	l7Parsers[port] = factory
+}
+
+func newL4Message(ipProto uint8) util.Message {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+	if factory, ok := l4Parsers[ipProto]; ok {
+		return factory()
+	}
+	return new(util.Buffer)
+}
+
+// newL7Message returns a Message to decode the payload using the parser registered for the
+// destination port, or else the source port. nil is returned if no parser is registered.
+func newL7Message(portSrc, portDst uint16) util.Message {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+	if factory, ok := l7Parsers[portDst]; ok {
+		return factory()
+	}
+	if factory, ok := l7Parsers[portSrc]; ok {
+		return factory()
+	}
+	return nil
+}
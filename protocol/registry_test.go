@@ -0,0 +1,190 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util"
+)
+
+// dummyL7 is a fake application protocol carrying a single 32-bit ID.
+type dummyL7 struct {
+	ID uint32
+}
+
+func (d *dummyL7) Len() uint16 {
+	return 4
+}
+
+func (d *dummyL7) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, d.ID)
+	return data, nil
+}
+
+func (d *dummyL7) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("the []byte is too short to unmarshal a dummyL7 message")
+	}
+	d.ID = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+// restoreParsers restores the parsers registered for ipProtos and ports, or their absence, when the
+// test completes, so that the parsers registered by the test don't affect the other tests.
+func restoreParsers(t *testing.T, ipProtos []uint8, ports []uint16) {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+	for _, ipProto := range ipProtos {
+		ipProto := ipProto
+		factory, ok := l4Parsers[ipProto]
+		t.Cleanup(func() {
+			parsersLock.Lock()
+			defer parsersLock.Unlock()
+			if ok {
+				l4Parsers[ipProto] = factory
+			} else {
+				delete(l4Parsers, ipProto)
+			}
+		})
+	}
+	for _, port := range ports {
+		port := port
+		factory, ok := l7Parsers[port]
+		t.Cleanup(func() {
+			parsersLock.Lock()
+			defer parsersLock.Unlock()
+			if ok {
+				l7Parsers[port] = factory
+			} else {
+				delete(l7Parsers, port)
+			}
+		})
+	}
+}
+
+func TestRegisterL7Parser(t *testing.T) {
+	restoreParsers(t, nil, []uint16{9999})
+	RegisterL7Parser(9999, func() util.Message { return new(dummyL7) })
+
+	udp := NewUDP()
+	udp.PortSrc = 12345
+	udp.PortDst = 9999
+	udp.Data = []byte{0x12, 0x34, 0x56, 0x78}
+	udp.Length = udp.Len()
+	ip := NewIPv4()
+	ip.Version = 4
+	ip.Protocol = Type_UDP
+	ip.NWSrc = net.ParseIP("10.0.0.1").To4()
+	ip.NWDst = net.ParseIP("10.0.0.2").To4()
+	ip.Data = udp
+	ip.Length = ip.Len()
+	eth := NewEthernet()
+	eth.Ethertype = IPv4_MSG
+	eth.Data = ip
+
+	data, err := eth.MarshalBinary()
+	require.NoError(t, err)
+	newEth := new(Ethernet)
+	require.NoError(t, newEth.UnmarshalBinary(data))
+	newUDP, ok := newEth.Data.(*IPv4).Data.(*UDP)
+	require.True(t, ok)
+	assert.Equal(t, udp.Data, newUDP.Data)
+	assert.Equal(t, &dummyL7{ID: 0x12345678}, newUDP.Application)
+
+	// Payload of other ports is kept as raw bytes only.
+	udp.PortDst = 9998
+	data, err = eth.MarshalBinary()
+	require.NoError(t, err)
+	newEth = new(Ethernet)
+	require.NoError(t, newEth.UnmarshalBinary(data))
+	newUDP = newEth.Data.(*IPv4).Data.(*UDP)
+	assert.Equal(t, udp.Data, newUDP.Data)
+	assert.Nil(t, newUDP.Application)
+
+	// A payload the parser doesn't accept is kept as raw bytes only, rather than failing the packet.
+	udp.PortDst = 9999
+	udp.Data = []byte{0x12, 0x34}
+	udp.Length = udp.Len()
+	ip.Length = ip.Len()
+	data, err = eth.MarshalBinary()
+	require.NoError(t, err)
+	newEth = new(Ethernet)
+	require.NoError(t, newEth.UnmarshalBinary(data))
+	newUDP = newEth.Data.(*IPv4).Data.(*UDP)
+	assert.Equal(t, udp.Data, newUDP.Data)
+	assert.Nil(t, newUDP.Application)
+}
+
+func TestUDPReuseResetsApplication(t *testing.T) {
+	restoreParsers(t, nil, []uint16{9999})
+	RegisterL7Parser(9999, func() util.Message { return new(dummyL7) })
+
+	udp := new(UDP)
+	require.NoError(t, udp.UnmarshalBinary([]byte{0x30, 0x39, 0x27, 0x0f, 0x00, 0x0c, 0x00, 0x00, 0x12, 0x34, 0x56, 0x78}))
+	assert.Equal(t, &dummyL7{ID: 0x12345678}, udp.Application)
+
+	// The same UDP is reused for a packet between ports without a parser.
+	require.NoError(t, udp.UnmarshalBinary([]byte{0x30, 0x39, 0x27, 0x0e, 0x00, 0x0c, 0x00, 0x00, 0x9a, 0xbc, 0xde, 0xf0}))
+	assert.Equal(t, uint16(9998), udp.PortDst)
+	assert.Nil(t, udp.Application)
+}
+
+func TestRegisterL4Parser(t *testing.T) {
+	const customProto = 253
+	ip := NewIPv4()
+	ip.Version = 4
+	ip.Protocol = customProto
+	ip.Data = &dummyL7{ID: 0xabcdef01}
+	ip.Length = ip.Len()
+	data, err := ip.MarshalBinary()
+	require.NoError(t, err)
+
+	newIP := new(IPv4)
+	require.NoError(t, newIP.UnmarshalBinary(data))
+	assert.IsType(t, new(util.Buffer), newIP.Data)
+
+	restoreParsers(t, []uint8{customProto}, nil)
+	RegisterL4Parser(customProto, func() util.Message { return new(dummyL7) })
+	newIP = new(IPv4)
+	require.NoError(t, newIP.UnmarshalBinary(data))
+	assert.Equal(t, &dummyL7{ID: 0xabcdef01}, newIP.Data)
+}
+
+// TestRegistryConcurrency registers and looks up parsers concurrently, it is meaningful when run
+// with -race.
+func TestRegistryConcurrency(t *testing.T) {
+	var ipProtos []uint8
+	var ports []uint16
+	for i := 0; i < 10; i++ {
+		ipProtos = append(ipProtos, uint8(200+i))
+		ports = append(ports, uint16(20000+i))
+	}
+	restoreParsers(t, ipProtos, ports)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			RegisterL4Parser(uint8(200+i), func() util.Message { return new(dummyL7) })
+			RegisterL7Parser(uint16(20000+i), func() util.Message { return new(dummyL7) })
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			newL4Message(uint8(200 + i))
+			newL7Message(12345, uint16(20000+i))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		assert.IsType(t, new(dummyL7), newL4Message(uint8(200+i)))
+		assert.IsType(t, new(dummyL7), newL7Message(12345, uint16(20000+i)))
+	}
+}
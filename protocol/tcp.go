@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 )
 
 type TCP struct {
@@ -77,3 +78,30 @@ func (t *TCP) UnmarshalBinary(data []byte) error {
 	return nil
 
 }
+
+// validateHdrLen checks HdrLen, which is the TCP header length in 32-bit words, is at least 5 and
+// the options it implies are in Data.
+func (t *TCP) validateHdrLen() error {
+	if t.HdrLen < 5 {
+		return fmt.Errorf("invalid TCP header length %d, it should be at least 5", t.HdrLen)
+	}
+	if optionsLen := int(t.HdrLen)*4 - 20; optionsLen > len(t.Data) {
+		return fmt.Errorf("TCP header length %d implies %d bytes of options, but there are only %d bytes", t.HdrLen, optionsLen, len(t.Data))
+	}
+	return nil
+}
+
+// PayloadLen returns the length of the TCP payload, which is Data after the TCP options. 0 is
+// returned if HdrLen is invalid.
+func (t *TCP) PayloadLen() int {
+	if t.validateHdrLen() != nil {
+		return 0
+	}
+	return len(t.Data) - (int(t.HdrLen)*4 - 20)
+}
+
+// IsDataSegment returns whether the TCP segment carries payload, e.g. false for a pure ACK. As a
+// PacketIn carries a single segment, the payload could be a part of a larger stream.
+func (t *TCP) IsDataSegment() bool {
+	return t.PayloadLen() > 0
+}
@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+func TestTCPPayloadLen(t *testing.T) {
+	// A pure ACK with 12 bytes of options (NOP, NOP, timestamps).
+	ack := testutil.DecodeHex(t, "87070050"+"00000001"+"00000002"+"80100200"+"00000000"+
+		"0101080a0000000100000002")
+	tcp := NewTCP()
+	require.NoError(t, tcp.UnmarshalBinary(ack))
+	assert.Equal(t, 0, tcp.PayloadLen())
+	assert.False(t, tcp.IsDataSegment())
+	assert.Empty(t, tcp.Payload())
+
+	// The same segment with 5 bytes of payload.
+	segment := append(ack, []byte("hello")...)
+	tcp = NewTCP()
+	require.NoError(t, tcp.UnmarshalBinary(segment))
+	assert.Equal(t, 5, tcp.PayloadLen())
+	assert.True(t, tcp.IsDataSegment())
+	assert.Equal(t, []byte("hello"), tcp.Payload())
+
+	// HdrLen implying more options than the segment carries.
+	tcp.HdrLen = 15
+	assert.Equal(t, 0, tcp.PayloadLen())
+	assert.False(t, tcp.IsDataSegment())
+	assert.Nil(t, tcp.Payload())
+}
@@ -3,6 +3,8 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+
+	"antrea.io/libOpenflow/util"
 )
 
 type UDP struct {
@@ -11,6 +13,9 @@ type UDP struct {
 	Length   uint16
 	Checksum uint16
 	Data     []byte
+	// Application is the payload decoded by the parser registered with RegisterL7Parser, it is
+	// nil if no parser is registered for the ports, or the parser fails to decode the payload.
+	Application util.Message
 }
 
 func NewUDP() *UDP {
@@ -38,13 +43,19 @@ func (u *UDP) MarshalBinary() (data []byte, err error) {
 
 func (u *UDP) UnmarshalBinary(data []byte) error {
 	if len(data) < 8 {
-		return errors.New("The []byte is too short to unmarshal a full ARP message.")
+		return errors.New("The []byte is too short to unmarshal a full UDP message.")
 	}
 	u.PortSrc = binary.BigEndian.Uint16(data[:2])
 	u.PortDst = binary.BigEndian.Uint16(data[2:4])
 	u.Length = binary.BigEndian.Uint16(data[4:6])
 	u.Checksum = binary.BigEndian.Uint16(data[6:8])
-	u.Data = append(u.Data, data[8:]...)
+	u.Data = append([]byte{}, data[8:]...)
 
+	// A payload which the registered parser doesn't accept is kept as raw bytes only, so that the
+	// packet is still decoded. Application is reset first, as u may be reused for another packet.
+	u.Application = nil
+	if app := newL7Message(u.PortSrc, u.PortDst); app != nil && app.UnmarshalBinary(u.Data) == nil {
+		u.Application = app
+	}
 	return nil
 }
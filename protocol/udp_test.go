@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+func TestUDPDNSQuery(t *testing.T) {
+	// A DNS query of the A record of example.com from port 53211.
+	data := testutil.DecodeHex(t, "cfdb00350025d6a4"+
+		"1a2b01000001000000000000"+
+		"076578616d706c6503636f6d0000010001")
+
+	udp := NewUDP()
+	require.NoError(t, udp.UnmarshalBinary(data))
+	assert.Equal(t, uint16(53211), udp.PortSrc)
+	assert.Equal(t, uint16(53), udp.PortDst)
+	assert.Equal(t, uint16(37), udp.Length)
+	assert.Equal(t, uint16(0xd6a4), udp.Checksum)
+	assert.Equal(t, data[8:], udp.Data)
+	assert.Equal(t, udp.Length, udp.Len())
+
+	out, err := udp.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+
+	// The data is replaced when the UDP is reused.
+	require.NoError(t, udp.UnmarshalBinary(data))
+	assert.Equal(t, data[8:], udp.Data)
+
+	assert.Error(t, udp.UnmarshalBinary(data[:7]))
+}
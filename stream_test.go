@@ -1,14 +1,20 @@
 package libOpenflow
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"io"
 	"net"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
 
 	"antrea.io/libOpenflow/common"
 	"antrea.io/libOpenflow/openflow13"
@@ -157,3 +163,534 @@ func TestStreamInbound(t *testing.T) {
 		}
 	}
 }
+
+// BenchmarkStreamLargeMessage feeds a PacketIn of the maximum OpenFlow message size, since the
+// message length is a 16-bit field, to the MessageStream in chunks of 2KB.
+func BenchmarkStreamLargeMessage(b *testing.B) {
+	pktIn := openflow15.NewPacketIn()
+	pktIn.Data = util.NewBuffer(make([]byte, 0xffff-pktIn.Len()))
+	msgBytes, err := pktIn.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var chunks [][]byte
+	for i := 0; i < len(msgBytes); i += 2048 {
+		chunks = append(chunks, msgBytes[i:min(i+2048, len(msgBytes))])
+	}
+	count := 0
+	c := newFakeConn(b.N*len(chunks), func() []byte {
+		chunk := chunks[count%len(chunks)]
+		count++
+		return chunk
+	})
+	logrus.SetLevel(logrus.PanicLevel)
+	stream := util.NewMessageStream(c, parserIntf{})
+	go func() {
+		<-stream.Error
+	}()
+	b.SetBytes(int64(len(msgBytes)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		<-stream.Inbound
+	}
+}
+
+func TestStreamUnacceptedVersion(t *testing.T) {
+	// An OF 1.0 echo request with 4 bytes of data, followed by an OF 1.5 hello in the same Read.
+	of10Echo := []byte{0x01, 0x02, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x01, 0xde, 0xad, 0xbe, 0xef}
+	hello, _ := common.NewHello(openflow15.VERSION)
+	helloBytes, _ := hello.MarshalBinary()
+	data := append(of10Echo, helloBytes...)
+	c := &blockingConn{
+		fakeConn: fakeConn{max: 1, bytesGenerator: func() []byte {
+			return data
+		}},
+		closed: make(chan struct{}),
+	}
+	stream := util.NewMessageStreamWithVersions(c, parserIntf{}, []uint8{openflow15.VERSION})
+	defer func() {
+		stream.Shutdown <- true
+	}()
+
+	select {
+	case err := <-stream.ParseError:
+		assert.ErrorContains(t, err, "version 1")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unaccepted version is not reported")
+	}
+	msg := <-stream.Inbound
+	assert.Equal(t, hello, msg)
+}
+
+func TestStreamParseError(t *testing.T) {
+	// An OF 1.5 experimenter message with xid 0x1234 and a truncated body, followed by a hello.
+	experimenter := []byte{0x06, 0x04, 0x00, 0x0c, 0x00, 0x00, 0x12, 0x34, 0xde, 0xad, 0xbe, 0xef}
+	hello, _ := common.NewHello(openflow15.VERSION)
+	helloBytes, _ := hello.MarshalBinary()
+	data := append(experimenter, helloBytes...)
+	c := &blockingConn{
+		fakeConn: fakeConn{max: 1, bytesGenerator: func() []byte {
+			return data
+		}},
+		closed: make(chan struct{}),
+	}
+	stream := util.NewMessageStream(c, parserIntf{})
+	defer func() {
+		stream.Shutdown <- true
+	}()
+
+	select {
+	case err := <-stream.ParseError:
+		assert.ErrorContains(t, err, "version 6, type 4, xid 4660")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Parse error is not reported")
+	}
+	msg := <-stream.Inbound
+	assert.Equal(t, hello, msg)
+	// The parse error is not a connection error.
+	select {
+	case err := <-stream.Error:
+		t.Errorf("Unexpected connection error: %v", err)
+	default:
+	}
+}
+
+// recordingParser parses messages with parserIntf, and records the xids of the messages it parses.
+type recordingParser struct {
+	mutex sync.Mutex
+	xids  []uint32
+}
+
+func (p *recordingParser) Parse(b []byte) (util.Message, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.xids = append(p.xids, binary.BigEndian.Uint32(b[4:8]))
+	return parserIntf{}.Parse(b)
+}
+
+func (p *recordingParser) parsedXids() []uint32 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]uint32(nil), p.xids...)
+}
+
+func TestStreamParserRegistry(t *testing.T) {
+	// Interleaved OF 1.3 and OF 1.5 echo requests, the xids of the OF 1.3 ones are odd.
+	var data []byte
+	for xid := uint32(1); xid <= 6; xid++ {
+		var echo *common.Header
+		if xid%2 == 1 {
+			echo = openflow13.NewEchoRequest()
+		} else {
+			echo = openflow15.NewEchoRequest()
+		}
+		echo.Xid = xid
+		b, _ := echo.MarshalBinary()
+		data = append(data, b...)
+	}
+	c := newFakeConn(1, func() []byte {
+		return data
+	})
+	of13Parser, of15Parser := &recordingParser{}, &recordingParser{}
+	registry := util.NewParserRegistry()
+	registry.Register(openflow13.VERSION, of13Parser)
+	registry.Register(openflow15.VERSION, of15Parser)
+	stream := util.NewMessageStream(c, registry)
+
+	received := map[uint32]uint8{}
+	for i := 0; i < 6; i++ {
+		msg := (<-stream.Inbound).(*common.Header)
+		received[msg.Xid] = msg.Version
+	}
+	assert.Equal(t, map[uint32]uint8{1: 4, 2: 6, 3: 4, 4: 6, 5: 4, 6: 6}, received)
+	assert.ElementsMatch(t, []uint32{1, 3, 5}, of13Parser.parsedXids())
+	assert.ElementsMatch(t, []uint32{2, 4, 6}, of15Parser.parsedXids())
+
+	// No parser is registered for OF 1.0.
+	_, err := registry.Parse([]byte{0x01, 0x02, 0x00, 0x08, 0x00, 0x00, 0x00, 0x07})
+	assert.ErrorContains(t, err, "no parser registered for OpenFlow version 1")
+}
+
+// blockingConn is a fakeConn which blocks on Read after max messages until it is closed.
+type blockingConn struct {
+	fakeConn
+	closed chan struct{}
+}
+
+func (b *blockingConn) Read(p []byte) (int, error) {
+	if b.count == b.max {
+		<-b.closed
+		return 0, errors.New("use of closed network connection")
+	}
+	return b.fakeConn.Read(p)
+}
+
+func (b *blockingConn) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestStreamMessages(t *testing.T) {
+	c := &blockingConn{
+		fakeConn: fakeConn{max: 3, bytesGenerator: regenerateMessage},
+		closed:   make(chan struct{}),
+	}
+	stream := util.NewMessageStream(c, parserIntf{})
+
+	count := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range stream.Messages() {
+			assert.IsType(t, new(common.Hello), msg)
+			count++
+			if count == 3 {
+				stream.Shutdown <- true
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Iterator is not terminated after the stream is shut down")
+	}
+	assert.Equal(t, 3, count)
+}
+
+// countingConn is a blockingConn which records the data and the number of calls of Write.
+type countingConn struct {
+	blockingConn
+	mutex      sync.Mutex
+	writeCount int
+	written    []byte
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeCount++
+	c.written = append(c.written, b...)
+	return len(b), nil
+}
+
+func TestStreamWriteCoalescing(t *testing.T) {
+	c := &countingConn{
+		blockingConn: blockingConn{closed: make(chan struct{})},
+	}
+	stream := util.NewMessageStreamWithConfig(c, parserIntf{}, util.MessageStreamConfig{
+		WriteBatchBytes:    256,
+		WriteFlushInterval: 10 * time.Millisecond,
+	})
+	defer func() {
+		stream.Shutdown <- true
+	}()
+
+	var expected []byte
+	for i := 0; i < 50; i++ {
+		echo := openflow15.NewEchoRequest()
+		echo.Xid = uint32(i)
+		data, _ := echo.MarshalBinary()
+		expected = append(expected, data...)
+		stream.Outbound <- echo
+	}
+	assert.Eventually(t, func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return len(c.written) == len(expected)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	assert.Equal(t, expected, c.written)
+	assert.Less(t, c.writeCount, 50)
+}
+
+func TestStreamOnRawMessage(t *testing.T) {
+	hello, _ := common.NewHello(openflow15.VERSION)
+	helloBytes, _ := hello.MarshalBinary()
+	echo := openflow15.NewEchoRequest()
+	echo.Xid = 1
+	echoBytes, _ := echo.MarshalBinary()
+	data := append(append([]byte{}, helloBytes...), echoBytes...)
+	c := &blockingConn{
+		fakeConn: fakeConn{max: 1, bytesGenerator: func() []byte { return data }},
+		closed:   make(chan struct{}),
+	}
+	var mutex sync.Mutex
+	var raw [][]byte
+	stream := util.NewMessageStreamWithConfig(c, parserIntf{}, util.MessageStreamConfig{
+		OnRawMessage: func(b []byte) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			raw = append(raw, b)
+		},
+	})
+	defer func() {
+		stream.Shutdown <- true
+	}()
+
+	for i := 0; i < 2; i++ {
+		<-stream.Inbound
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, [][]byte{helloBytes, echoBytes}, raw)
+}
+
+func TestStreamNumParsers(t *testing.T) {
+	var data []byte
+	var expected []uint8
+	for i := 0; i < 20; i++ {
+		msg := openflow15.NewEchoRequest()
+		if i%3 == 0 {
+			msg = openflow15.NewEchoReply()
+		}
+		msg.Xid = 7
+		b, _ := msg.MarshalBinary()
+		data = append(data, b...)
+		expected = append(expected, msg.Type)
+	}
+	c := &blockingConn{
+		fakeConn: fakeConn{max: 1, bytesGenerator: func() []byte { return data }},
+		closed:   make(chan struct{}),
+	}
+	stream := util.NewMessageStreamWithConfig(c, parserIntf{}, util.MessageStreamConfig{NumParsers: 1})
+	defer func() {
+		stream.Shutdown <- true
+	}()
+
+	var received []uint8
+	for range expected {
+		msg := <-stream.Inbound
+		received = append(received, msg.(*common.Header).Type)
+	}
+	assert.Equal(t, expected, received)
+}
+
+// repeatingConn is a blockingConn which serves total bytes of data repeatedly, at most chunk bytes
+// per Read if chunk is positive.
+type repeatingConn struct {
+	blockingConn
+	data      []byte
+	offset    int
+	remaining int
+	chunk     int
+}
+
+func (c *repeatingConn) Read(p []byte) (int, error) {
+	if c.remaining == 0 {
+		<-c.closed
+		return 0, errors.New("use of closed network connection")
+	}
+	if c.chunk > 0 && len(p) > c.chunk {
+		p = p[:c.chunk]
+	}
+	if len(p) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], c.data[c.offset:])
+		n += copied
+		c.offset = (c.offset + copied) % len(c.data)
+	}
+	c.remaining -= n
+	return n, nil
+}
+
+func newRepeatingConn(data []byte, total int, chunk int) *repeatingConn {
+	return &repeatingConn{
+		blockingConn: blockingConn{closed: make(chan struct{})},
+		data:         data,
+		remaining:    total,
+		chunk:        chunk,
+	}
+}
+
+// rawParser is a parser which returns a copy of the bytes as a Buffer.
+type rawParser struct{}
+
+func (p rawParser) Parse(b []byte) (util.Message, error) {
+	return util.NewBuffer(append([]byte{}, b...)), nil
+}
+
+// newRawMessage returns an OpenFlow 1.5 message of size bytes with xid 0.
+func newRawMessage(size int) []byte {
+	msg := make([]byte, size)
+	msg[0] = openflow15.VERSION
+	msg[1] = openflow15.Type_Experimenter
+	binary.BigEndian.PutUint16(msg[2:], uint16(size))
+	for i := 8; i < size; i++ {
+		msg[i] = byte(i)
+	}
+	return msg
+}
+
+func TestStreamReadBufferSize(t *testing.T) {
+	var messages [][]byte
+	var data []byte
+	for _, size := range []int{8, 1500, 5000, 100, 60000} {
+		msg := newRawMessage(size)
+		messages = append(messages, msg)
+		data = append(data, msg...)
+	}
+	for _, tc := range []struct {
+		name           string
+		readBufferSize int
+		chunk          int
+	}{
+		{"default buffer with messages spanning many reads", 0, 7},
+		{"reads returning a single byte", 0, 1},
+		{"small buffer", 16, 0},
+		{"buffer larger than the data", 65536 * 2, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newRepeatingConn(data, len(data), tc.chunk)
+			stream := util.NewMessageStreamWithConfig(c, rawParser{}, util.MessageStreamConfig{ReadBufferSize: tc.readBufferSize})
+			defer func() {
+				stream.Shutdown <- true
+			}()
+			for i := range messages {
+				msg := <-stream.Inbound
+				assert.Equal(t, messages[i], msg.(*util.Buffer).Bytes(), "message %d", i)
+			}
+		})
+	}
+}
+
+func benchmarkStreamReadBufferSize(b *testing.B, readBufferSize int) {
+	msg := newRawMessage(1500)
+	c := newRepeatingConn(msg, len(msg)*b.N, 0)
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	stream := util.NewMessageStreamWithConfig(c, fixedParser{util.NewBuffer(nil)}, util.MessageStreamConfig{ReadBufferSize: readBufferSize})
+	for i := 0; i < b.N; i++ {
+		<-stream.Inbound
+	}
+	b.StopTimer()
+	stream.Shutdown <- true
+}
+
+func BenchmarkStreamReadBufferSize2048(b *testing.B) {
+	benchmarkStreamReadBufferSize(b, 2048)
+}
+
+func BenchmarkStreamReadBufferSize65536(b *testing.B) {
+	benchmarkStreamReadBufferSize(b, 65536)
+}
+
+func TestStreamContextCancel(t *testing.T) {
+	ignoreCurrent := goleak.IgnoreCurrent()
+	ctx, cancel := context.WithCancel(context.Background())
+	client, server := net.Pipe()
+	defer server.Close()
+	stream := util.NewMessageStreamContext(ctx, client, parserIntf{})
+
+	for xid := uint32(1); xid <= 3; xid++ {
+		echo := openflow15.NewEchoRequest()
+		echo.Xid = xid
+		data, _ := echo.MarshalBinary()
+		_, err := server.Write(data)
+		require.NoError(t, err)
+	}
+	// Only two messages are consumed, the worker parsing the other is blocked on publishing it until
+	// the context is cancelled. The messages are parsed by different workers, so their order is not
+	// defined.
+	received := map[uint32]bool{}
+	for i := 0; i < 2; i++ {
+		msg := <-stream.Inbound
+		received[msg.(*common.Header).Xid] = true
+	}
+	assert.Len(t, received, 2)
+	for xid := range received {
+		assert.Contains(t, []uint32{1, 2, 3}, xid)
+	}
+
+	cancel()
+	_, err := server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	goleak.VerifyNone(t, ignoreCurrent)
+}
+
+func TestStreamContextWithConfig(t *testing.T) {
+	ignoreCurrent := goleak.IgnoreCurrent()
+	ctx, cancel := context.WithCancel(context.Background())
+	client, server := net.Pipe()
+	defer server.Close()
+	// With a single parser the messages are published in the order they are received.
+	stream := util.NewMessageStreamContextWithConfig(ctx, client, parserIntf{}, util.MessageStreamConfig{NumParsers: 1})
+
+	for xid := uint32(1); xid <= 3; xid++ {
+		echo := openflow15.NewEchoRequest()
+		echo.Xid = xid
+		data, _ := echo.MarshalBinary()
+		_, err := server.Write(data)
+		require.NoError(t, err)
+	}
+	for xid := uint32(1); xid <= 2; xid++ {
+		msg := <-stream.Inbound
+		assert.Equal(t, xid, msg.(*common.Header).Xid)
+	}
+
+	cancel()
+	_, err := server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	goleak.VerifyNone(t, ignoreCurrent)
+}
+
+func TestStreamCancelledContext(t *testing.T) {
+	ignoreCurrent := goleak.IgnoreCurrent()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client, server := net.Pipe()
+	defer server.Close()
+	util.NewMessageStreamContext(ctx, client, parserIntf{})
+	goleak.VerifyNone(t, ignoreCurrent)
+}
+
+func TestDialOpenFlow(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := util.DialOpenFlow(ctx, "tcp", listener.Addr().String(), parserIntf{})
+	require.NoError(t, err)
+	defer func() {
+		stream.Shutdown <- true
+	}()
+	conn := <-accepted
+	defer conn.Close()
+
+	echo := openflow15.NewEchoRequest()
+	echo.Xid = 5
+	data, _ := echo.MarshalBinary()
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+	msg := <-stream.Inbound
+	assert.Equal(t, uint32(5), msg.(*common.Header).Xid)
+
+	stream.Outbound <- openflow15.NewEchoReply()
+	reply := make([]byte, 8)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(openflow15.Type_EchoReply), reply[1])
+}
+
+func TestDialOpenFlowCancelled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = util.DialOpenFlow(ctx, "tcp", listener.Addr().String(), parserIntf{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
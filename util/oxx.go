@@ -0,0 +1,87 @@
+package util
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OXXFieldKey returns the key of an OXM or NXM field header made of its class and field number.
+func OXXFieldKey(class uint16, field uint8) uint32 {
+	return uint32(class)<<8 | uint32(field)
+}
+
+// OXXFieldNames returns a map of the keys of the headers in headers, see OXXFieldKey, to the OVS
+// known OXX field names, e.g. "NXM_NX_REG0". headers maps the names to the headers, and classField
+// returns the class and field number of a header.
+func OXXFieldNames[H any](headers map[string]H, classField func(H) (uint16, uint8)) map[uint32]string {
+	names := make(map[uint32]string, len(headers))
+	for name, header := range headers {
+		names[OXXFieldKey(classField(header))] = name
+	}
+	return names
+}
+
+// semanticFieldNames maps the OXX field names to the names of the fields with the same semantic
+// when the lower-cased name without the class prefix is not enough, e.g. NXM_OF_IP_SRC and
+// OXM_OF_IPV4_SRC are both "ipv4_src".
+var semanticFieldNames = map[string]string{
+	"NXM_OF_IP_SRC":    "ipv4_src",
+	"NXM_OF_IP_DST":    "ipv4_dst",
+	"NXM_OF_ICMP_TYPE": "icmpv4_type",
+	"NXM_OF_ICMP_CODE": "icmpv4_code",
+	"NXM_NX_ND_TARGET": "ipv6_nd_target",
+	"NXM_NX_ND_SLL":    "ipv6_nd_sll",
+	"NXM_NX_ND_TLL":    "ipv6_nd_tll",
+	"NXM_NX_TUN_ID":    "tunnel_id",
+}
+
+// SemanticFieldName returns a name of the field which doesn't depend on the class used to encode
+// it, e.g. "ipv4_src" for both NXM_OF_IP_SRC and OXM_OF_IPV4_SRC. names is the map returned by
+// OXXFieldNames for the OpenFlow version of the field.
+func SemanticFieldName(names map[uint32]string, class uint16, field uint8) string {
+	name, found := names[OXXFieldKey(class, field)]
+	if !found {
+		return fmt.Sprintf("class%d_field%d", class, field)
+	}
+	if semanticName, ok := semanticFieldNames[name]; ok {
+		return semanticName
+	}
+	for _, prefix := range []string{"OXM_OF_", "NXM_OF_", "NXM_NX_"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	return strings.ToLower(name)
+}
+
+// MessageHex returns the marshaled msg in hex, or an empty string if msg is nil or can't be
+// marshaled.
+func MessageHex(msg Message) string {
+	if msg == nil {
+		return ""
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(data)
+}
+
+// FieldFingerprint returns a match field as "name=value[/mask]" with the value and mask in hex, in
+// the form used by Fingerprint. mask is nil if the field is not masked.
+func FieldFingerprint(name string, value, mask Message) string {
+	field := name + "=" + MessageHex(value)
+	if mask != nil {
+		field += "/" + MessageHex(mask)
+	}
+	return field
+}
+
+// Fingerprint returns a representation of a match which is stable across OpenFlow versions, made
+// of the fingerprints of its fields returned by FieldFingerprint. The fields are sorted so that the
+// result doesn't depend on their order.
+func Fingerprint(fieldFingerprints []string) string {
+	fields := append([]string(nil), fieldFingerprints...)
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
@@ -2,14 +2,29 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
+	"iter"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"k8s.io/klog/v2"
 )
 
-const numParserGoroutines = 25
+// defaultNumParsers is the number of goroutines parsing the messages received from the connection if
+// it is not configured.
+const defaultNumParsers = 25
+
+// defaultReadBufferSize is the size of the buffer to read from the connection if it is not
+// configured.
+const defaultReadBufferSize = 2048
+
+// defaultWriteFlushInterval is the interval to flush the coalesced outbound messages if it is not
+// configured.
+const defaultWriteFlushInterval = time.Millisecond
 
 type BufferPool struct {
 	Empty chan *bytes.Buffer
@@ -30,20 +45,73 @@ type Parser interface {
 	Parse(b []byte) (message Message, err error)
 }
 
+// ParserRegistry is a Parser which parses each message with the Parser registered for the OpenFlow
+// version in its header, so that a MessageStream can receive the messages of the versions
+// negotiated per connection. It is safe to register a Parser while the messages are being parsed.
+type ParserRegistry struct {
+	mutex   sync.RWMutex
+	parsers map[uint8]Parser
+}
+
+// NewParserRegistry returns a new ParserRegistry without any Parser registered.
+func NewParserRegistry() *ParserRegistry {
+	return &ParserRegistry{parsers: make(map[uint8]Parser)}
+}
+
+// Register registers parser to parse the messages of OpenFlow version, replacing the Parser
+// registered for version before.
+func (r *ParserRegistry) Register(version uint8, parser Parser) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.parsers[version] = parser
+}
+
+// Parse parses b with the Parser registered for the version in b[0]. It returns an error if no
+// Parser is registered for the version.
+func (r *ParserRegistry) Parse(b []byte) (Message, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty OpenFlow message")
+	}
+	r.mutex.RLock()
+	parser, ok := r.parsers[b[0]]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no parser registered for OpenFlow version %d", b[0])
+	}
+	return parser.Parse(b)
+}
+
 type streamWorker struct {
 	Full chan *bytes.Buffer
 }
 
-func (w *streamWorker) parse(stopCh chan bool, parser Parser, inbound chan Message, empty chan *bytes.Buffer) {
+// parse parses the messages sent to w until stopCh is closed. A parsed message is dropped if done is
+// closed while it is being published on inbound. The parsing errors are published on parseErrCh if
+// it is not full.
+func (w *streamWorker) parse(stopCh chan bool, done <-chan struct{}, parser Parser, inbound chan Message, parseErrCh chan error, empty chan *bytes.Buffer) {
 	for {
 		select {
 		case b := <-w.Full:
 			msg, err := parser.Parse(b.Bytes())
 			// Log all message parsing errors.
 			if err != nil {
-				klog.ErrorS(err, "Failed to parse received message", "bytes", b.Bytes())
+				// The buffer always holds a complete OpenFlow header, as shorter messages are not
+				// dispatched.
+				hdr := b.Bytes()
+				version, msgType, xid := hdr[0], hdr[1], binary.BigEndian.Uint32(hdr[4:8])
+				klog.ErrorS(err, "Failed to parse received message", "version", version, "type", msgType, "xid", xid, "bytes", hdr)
+				// Don't block parsing the following messages if nobody is consuming the ParseError
+				// channel.
+				select {
+				case parseErrCh <- fmt.Errorf("failed to parse OpenFlow message of version %d, type %d, xid %d: %w", version, msgType, xid, err):
+				default:
+				}
 			} else {
-				inbound <- msg
+				select {
+				case inbound <- msg:
+				case <-done:
+					return
+				}
 			}
 			b.Reset()
 			empty <- b
@@ -64,6 +132,10 @@ type MessageStream struct {
 	Version uint8
 	// Channel on which to publish connection errors
 	Error chan error
+	// Channel on which to publish the errors of parsing inbound messages, including the messages
+	// with an unaccepted version, which don't affect the connection. An error is dropped if the
+	// channel is full.
+	ParseError chan error
 	// Channel on which to publish inbound messages
 	Inbound chan Message
 	// Channel on which to receive outbound messages
@@ -72,11 +144,96 @@ type MessageStream struct {
 	Shutdown chan bool
 	// Worker to parse the message received from the connection
 	workers []streamWorker
+	// OpenFlow versions of the messages accepted from the connection, all versions are accepted
+	// if it is empty
+	acceptedVersions map[uint8]bool
+	// Outbound messages are coalesced into one write until the size reaches writeBatchBytes or
+	// writeFlushInterval passes, coalescing is disabled if writeBatchBytes is 0
+	writeBatchBytes    int
+	writeFlushInterval time.Duration
+	// Callback invoked with a copy of every message received from the connection before parsing
+	onRawMessage func([]byte)
+	// Size of the buffer to read from the connection
+	readBufferSize int
+	// Context the lifetime of the MessageStream is bound to
+	ctx context.Context
+}
+
+// MessageStreamConfig is the optional configuration of a MessageStream.
+type MessageStreamConfig struct {
+	// AcceptedVersions are the OpenFlow versions of the messages parsed from the connection. A
+	// message with another version is skipped, and an error with the version is published on the
+	// ParseError channel. All versions are accepted if it is empty.
+	AcceptedVersions []uint8
+	// WriteBatchBytes enables coalescing outbound messages into one write to the connection if it
+	// is positive. The queued messages are written once their size reaches WriteBatchBytes, or
+	// WriteFlushInterval passes after the first of them is queued.
+	WriteBatchBytes int
+	// WriteFlushInterval is the max time an outbound message is delayed when WriteBatchBytes is
+	// positive, it is 1ms if not set.
+	WriteFlushInterval time.Duration
+	// OnRawMessage is invoked with a copy of the bytes of every message received from the
+	// connection, before it is dispatched to be parsed. It is called in the goroutine reading from
+	// the connection, in the order of the messages, so it must return quickly to not block reading.
+	OnRawMessage func([]byte)
+	// NumParsers is the number of goroutines parsing the messages received from the connection,
+	// it is 25 if it is less than 1. The messages with the same xid are parsed by the same
+	// goroutine, so they are published on the Inbound channel in the order they are received.
+	NumParsers int
+	// ReadBufferSize is the max number of bytes read from the connection at a time, it is 2048 if
+	// it is less than 1. A larger buffer reduces the reads for bulk messages like flow dumps. It
+	// doesn't limit the size of a message, which could span multiple reads.
+	ReadBufferSize int
 }
 
 // Returns a pointer to a new MessageStream. Used to parse
 // OpenFlow messages from conn.
 func NewMessageStream(conn net.Conn, parser Parser) *MessageStream {
+	return NewMessageStreamWithConfig(conn, parser, MessageStreamConfig{})
+}
+
+// NewMessageStreamWithVersions returns a pointer to a new MessageStream which only parses the
+// OpenFlow messages from conn with a version in versions. A message with another version is
+// skipped, and an error with the version is published on the ParseError channel. All versions are
+// accepted if versions is empty.
+func NewMessageStreamWithVersions(conn net.Conn, parser Parser, versions []uint8) *MessageStream {
+	return NewMessageStreamWithConfig(conn, parser, MessageStreamConfig{AcceptedVersions: versions})
+}
+
+// NewMessageStreamContext returns a pointer to a new MessageStream, the lifetime of which is bound
+// to ctx. When ctx is done, the MessageStream is shut down in the same way as by the Shutdown
+// channel: conn is closed, and all the goroutines of the MessageStream terminate. The parsed
+// messages which are not received from the Inbound channel yet are dropped.
+func NewMessageStreamContext(ctx context.Context, conn net.Conn, parser Parser) *MessageStream {
+	return NewMessageStreamContextWithConfig(ctx, conn, parser, MessageStreamConfig{})
+}
+
+// NewMessageStreamContextWithConfig returns a pointer to a new MessageStream configured by cfg, the
+// lifetime of which is bound to ctx as in NewMessageStreamContext.
+func NewMessageStreamContextWithConfig(ctx context.Context, conn net.Conn, parser Parser, cfg MessageStreamConfig) *MessageStream {
+	return newMessageStream(ctx, conn, parser, cfg)
+}
+
+// DialOpenFlow connects to the OpenFlow switch at addr on network, e.g. "tcp" or "unix", and returns
+// a new MessageStream parsing the messages from the connection with parser. Dialing is bound to
+// ctx, but the returned MessageStream is not, it's shut down by the Shutdown channel as usual. The
+// Hello exchange is left to the caller.
+func DialOpenFlow(ctx context.Context, network, addr string, parser Parser) (*MessageStream, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s %s: %w", network, addr, err)
+	}
+	return NewMessageStream(conn, parser), nil
+}
+
+// NewMessageStreamWithConfig returns a pointer to a new MessageStream configured by cfg. Used to
+// parse OpenFlow messages from conn.
+func NewMessageStreamWithConfig(conn net.Conn, parser Parser, cfg MessageStreamConfig) *MessageStream {
+	return newMessageStream(context.Background(), conn, parser, cfg)
+}
+
+func newMessageStream(ctx context.Context, conn net.Conn, parser Parser, cfg MessageStreamConfig) *MessageStream {
 	m := &MessageStream{
 		conn,
 		NewBufferPool(),
@@ -84,18 +241,39 @@ func NewMessageStream(conn net.Conn, parser Parser) *MessageStream {
 		make(chan bool, 1),
 		0,
 		make(chan error, 1),   // Error
+		make(chan error, 1),   // ParseError
 		make(chan Message, 1), // Inbound
 		make(chan Message, 1), // Outbound
 		make(chan bool, 1),    // Shutdown
-		make([]streamWorker, numParserGoroutines),
+		nil,
+		make(map[uint8]bool, len(cfg.AcceptedVersions)),
+		cfg.WriteBatchBytes,
+		cfg.WriteFlushInterval,
+		cfg.OnRawMessage,
+		cfg.ReadBufferSize,
+		ctx,
+	}
+	for _, v := range cfg.AcceptedVersions {
+		m.acceptedVersions[v] = true
+	}
+	if m.writeFlushInterval <= 0 {
+		m.writeFlushInterval = defaultWriteFlushInterval
+	}
+	numParsers := cfg.NumParsers
+	if numParsers < 1 {
+		numParsers = defaultNumParsers
+	}
+	m.workers = make([]streamWorker, numParsers)
+	if m.readBufferSize < 1 {
+		m.readBufferSize = defaultReadBufferSize
 	}
 
-	for i := 0; i < numParserGoroutines; i++ {
+	for i := range m.workers {
 		worker := streamWorker{
 			Full: make(chan *bytes.Buffer),
 		}
 		m.workers[i] = worker
-		go worker.parse(m.parserShutdown, m.parser, m.Inbound, m.pool.Empty)
+		go worker.parse(m.parserShutdown, m.ctx.Done(), m.parser, m.Inbound, m.ParseError, m.pool.Empty)
 	}
 	go m.outbound()
 	go m.inbound()
@@ -103,51 +281,121 @@ func NewMessageStream(conn net.Conn, parser Parser) *MessageStream {
 	return m
 }
 
+// Messages returns an iterator over the inbound messages, which terminates when the stream is
+// shut down. It consumes the Inbound channel, so it should not be used together with receiving
+// from Inbound directly.
+func (m *MessageStream) Messages() iter.Seq[Message] {
+	return func(yield func(Message) bool) {
+		for {
+			select {
+			case msg := <-m.Inbound:
+				if !yield(msg) {
+					return
+				}
+			case <-m.parserShutdown:
+				return
+			}
+		}
+	}
+}
+
 func (m *MessageStream) GetAddr() net.Addr {
 	return m.conn.RemoteAddr()
 }
 
 // Listen for a Shutdown signal or Outbound messages.
 func (m *MessageStream) outbound() {
+	// pending are the coalesced outbound messages, which are written when flushCh fires.
+	var pending []byte
+	var flushTimer *time.Timer
+	var flushCh <-chan time.Time
+	flush := func() {
+		if flushTimer != nil {
+			flushTimer.Stop()
+			flushTimer, flushCh = nil, nil
+		}
+		if len(pending) > 0 {
+			m.write(pending)
+			pending = nil
+		}
+	}
+	shutdown := func() {
+		flush()
+		klog.Infof("Closing OpenFlow message stream.")
+		// parserShutdown is closed before conn, rather than after it, so that inbound sees the stream
+		// is shut down when its Read fails on the closed conn. Not every net.Conn fails with "use of
+		// closed network connection", e.g. net.Pipe returns io.ErrClosedPipe, and inbound would
+		// otherwise report the error as a connection error and block on the Error channel if nobody
+		// is receiving from it. The workers stop parsing at the same time as before, as dispatching
+		// to them stops once conn is closed.
+		close(m.parserShutdown)
+		m.conn.Close()
+	}
 	for {
 		select {
 		case <-m.Shutdown:
-			klog.Infof("Closing OpenFlow message stream.")
-			m.conn.Close()
-			close(m.parserShutdown)
+			shutdown()
+			return
+		case <-m.ctx.Done():
+			shutdown()
 			return
 		case msg := <-m.Outbound:
 			// Forward outbound messages to conn
 			data, _ := msg.MarshalBinary()
-			if _, err := m.conn.Write(data); err != nil {
-				klog.ErrorS(err, "OutboundError")
-				m.Error <- err
-				m.Shutdown <- true
+			if m.writeBatchBytes <= 0 {
+				m.write(data)
+				continue
 			}
-
-			// Only log the data with loglevel >= 7.
-			if klogV := klog.V(7); klogV.Enabled() {
-				klogV.InfoS("Sent outbound message", "dataLength", len(data), "data", data)
-			} else {
-				klog.V(4).InfoS("Sent outbound message", "dataLength", len(data))
+			pending = append(pending, data...)
+			if len(pending) >= m.writeBatchBytes {
+				flush()
+			} else if flushTimer == nil {
+				flushTimer = time.NewTimer(m.writeFlushInterval)
+				flushCh = flushTimer.C
 			}
+		case <-flushCh:
+			flushTimer, flushCh = nil, nil
+			flush()
 		}
 	}
 }
 
+// write writes data to conn, and shuts down the stream if it fails.
+func (m *MessageStream) write(data []byte) {
+	if _, err := m.conn.Write(data); err != nil {
+		klog.ErrorS(err, "OutboundError")
+		m.Error <- err
+		m.Shutdown <- true
+	}
+
+	// Only log the data with loglevel >= 7.
+	if klogV := klog.V(7); klogV.Enabled() {
+		klogV.InfoS("Sent outbound message", "dataLength", len(data), "data", data)
+	} else {
+		klog.V(4).InfoS("Sent outbound message", "dataLength", len(data))
+	}
+}
+
 // Handle inbound messages
 func (m *MessageStream) inbound() {
 	msgLen := 0
 	hdr := 0
 	hdrBuf := make([]byte, 4)
+	// discard is set if the message being received has a version which is not accepted.
+	discard := false
 
-	tmpBuf := make([]byte, 2048)
-	buf := <-m.pool.Empty
+	tmpBuf := make([]byte, m.readBufferSize)
+	buf, ok := m.emptyBuffer()
+	if !ok {
+		return
+	}
+	// The messages are assembled from the bytes read from conn by the length in their headers, so a
+	// Read returning fewer bytes than a message, or parts of multiple messages, is handled.
 	for {
 		n, err := m.conn.Read(tmpBuf)
 		if err != nil {
 			// Handle explicitly disconnecting by closing connection
-			if strings.Contains(err.Error(), "use of closed network connection") {
+			if strings.Contains(err.Error(), "use of closed network connection") || m.isShutdown() {
 				return
 			}
 			klog.ErrorS(err, "InboundError")
@@ -165,6 +413,29 @@ func (m *MessageStream) inbound() {
 					// MessageStream is not protocol agnostic. Reading length based
 					// on OpenFlow header field.
 					msgLen = int(binary.BigEndian.Uint16(hdrBuf[2:])) - 4
+					if !m.isVersionAccepted(hdrBuf[0]) {
+						m.reportUnacceptedVersion(hdrBuf[0], msgLen+4)
+						buf.Reset()
+						discard = true
+						if msgLen <= 0 {
+							hdr = 0
+							discard = false
+						}
+						continue
+					}
+					// Grow the buffer once for the whole message, so that a large message
+					// received across many Reads is not reallocated repeatedly.
+					if msgLen > 0 {
+						buf.Grow(msgLen)
+					}
+				}
+				continue
+			}
+			if msgLen > 0 && discard {
+				msgLen = msgLen - 1
+				if msgLen == 0 {
+					hdr = 0
+					discard = false
 				}
 				continue
 			}
@@ -173,8 +444,12 @@ func (m *MessageStream) inbound() {
 				msgLen = msgLen - 1
 				if msgLen == 0 {
 					hdr = 0
-					m.dispatchMessage(buf)
-					buf = <-m.pool.Empty
+					if !m.dispatchMessage(buf) {
+						return
+					}
+					if buf, ok = m.emptyBuffer(); !ok {
+						return
+					}
 				}
 				continue
 			}
@@ -182,14 +457,58 @@ func (m *MessageStream) inbound() {
 	}
 }
 
-// Dispatch the message to streamWorker according to Xid in the message Header
-func (m *MessageStream) dispatchMessage(b *bytes.Buffer) {
+func (m *MessageStream) isVersionAccepted(version uint8) bool {
+	return len(m.acceptedVersions) == 0 || m.acceptedVersions[version]
+}
+
+func (m *MessageStream) reportUnacceptedVersion(version uint8, length int) {
+	err := fmt.Errorf("unaccepted OpenFlow version %d, skipped message of %d bytes", version, length)
+	klog.ErrorS(err, "InboundError")
+	// Don't block receiving the following messages if nobody is consuming the ParseError channel.
+	select {
+	case m.ParseError <- err:
+	default:
+	}
+}
+
+// dispatchMessage sends the message in b to the worker parsing the messages with its xid. It
+// returns false if the MessageStream is shut down.
+func (m *MessageStream) dispatchMessage(b *bytes.Buffer) bool {
 	msgBytes := b.Bytes()
 	if len(msgBytes) < 8 {
 		klog.Error("Buffer too small to parse OpenFlow messages")
-		return
+		return true
+	}
+	if m.onRawMessage != nil {
+		m.onRawMessage(bytes.Clone(msgBytes))
 	}
 	xid := binary.BigEndian.Uint32(msgBytes[4:])
 	workerKey := int(xid % uint32(len(m.workers)))
-	m.workers[workerKey].Full <- b
+	select {
+	case m.workers[workerKey].Full <- b:
+		return true
+	case <-m.parserShutdown:
+		return false
+	}
+}
+
+// emptyBuffer returns a buffer from the pool to receive a message, or false if the MessageStream
+// is shut down.
+func (m *MessageStream) emptyBuffer() (*bytes.Buffer, bool) {
+	select {
+	case b := <-m.pool.Empty:
+		return b, true
+	case <-m.parserShutdown:
+		return nil, false
+	}
+}
+
+// isShutdown returns whether the MessageStream is shut down.
+func (m *MessageStream) isShutdown() bool {
+	select {
+	case <-m.parserShutdown:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,109 @@
+// Package testutil provides helpers shared by the tests of the libOpenflow packages.
+package testutil
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"antrea.io/libOpenflow/util"
+)
+
+// DecodeHex decodes hexString, in which whitespaces are ignored. The test fails if hexString is
+// not valid.
+func DecodeHex(tb testing.TB, hexString string) []byte {
+	tb.Helper()
+	data, err := hex.DecodeString(strings.Join(strings.Fields(hexString), ""))
+	if err != nil {
+		tb.Fatalf("Failed to decode the hex string: %v", err)
+	}
+	return data
+}
+
+// ParseHexMessage decodes hexString like DecodeHex and parses it to a Message with parser, which is
+// usually a util.ParserRegistry or a parser dispatching by the OpenFlow version in the header. The
+// test fails if the message can't be decoded or parsed.
+func ParseHexMessage(tb testing.TB, parser util.Parser, hexString string) util.Message {
+	tb.Helper()
+	data := DecodeHex(tb, hexString)
+	if len(data) < 8 {
+		tb.Fatalf("The message is too short for an OpenFlow header: %d bytes", len(data))
+	}
+	msg, err := parser.Parse(data)
+	if err != nil {
+		tb.Fatalf("Failed to parse the message: %v", err)
+	}
+	if msg == nil {
+		tb.Fatalf("No parser for OpenFlow version %d", data[0])
+	}
+	return msg
+}
+
+// The OXM matches below are encoded in the same way in OpenFlow 1.3 and 1.5, so that the tests of
+// both versions could share them. The hex strings could be decoded with DecodeHex.
+const (
+	// MatchHex is a match of eth_type=0x0800, ip_proto=6 and tcp_dst=80. Its Length is 21, i.e. 4
+	// bytes of header, 6 bytes of eth_type, 5 bytes of ip_proto and 6 bytes of tcp_dst, and it's
+	// padded to 24 bytes.
+	MatchHex = "00010015 80000a020800 8000140106 80001c020050 000000"
+	// TruncatedMatchHex is MatchHex with a Length of 20, which cuts the value of tcp_dst.
+	TruncatedMatchHex = "00010014 80000a020800 8000140106 80001c0200"
+)
+
+// MalformedMatchHexes maps the descriptions of malformed OXM matches to their hex strings. Every
+// match must be rejected rather than make the decoder panic.
+var MalformedMatchHexes = map[string]string{
+	"short header":             "0001",
+	"length less than header":  "00010003",
+	"length beyond data":       "00010015 80000a020800",
+	"field header cut":         "00010006 8000",
+	"next field header cut":    "0001000b 80000a020800 80",
+	"field value cut":          TruncatedMatchHex,
+	"field length beyond data": "0001000a 80000a080800",
+}
+
+// UnmarshalNoPanic unmarshals data into msg and returns the error. The test fails if unmarshaling
+// panics, which is used to check that malformed data is rejected gracefully.
+func UnmarshalNoPanic(tb testing.TB, msg encoding.BinaryUnmarshaler, data []byte) error {
+	tb.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			tb.Fatalf("Unmarshaling %x panics: %v", data, r)
+		}
+	}()
+	return msg.UnmarshalBinary(data)
+}
+
+// Truncations returns copies of data cut at every length shorter than data. Each copy is returned
+// twice: as it is, so that the length at lengthOffset exceeds the data, and with the length set
+// to the cut length, so that the last element could be cut, if the copy includes the length.
+func Truncations(data []byte, lengthOffset int) [][]byte {
+	var truncations [][]byte
+	for i := 0; i < len(data); i++ {
+		truncations = append(truncations, append([]byte{}, data[:i]...))
+		if i >= lengthOffset+2 {
+			truncated := append([]byte{}, data[:i]...)
+			binary.BigEndian.PutUint16(truncated[lengthOffset:], uint16(i))
+			truncations = append(truncations, truncated)
+		}
+	}
+	return truncations
+}
+
+// Corruptions returns n copies of data, in each of which 3 random bytes are overwritten with
+// random values and which is cut at a random length. The copies are the same for the same seed.
+func Corruptions(data []byte, n int, seed int64) [][]byte {
+	rng := rand.New(rand.NewSource(seed))
+	corruptions := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		corrupted := append([]byte{}, data...)
+		for j := 0; j < 3; j++ {
+			corrupted[rng.Intn(len(corrupted))] = byte(rng.Intn(256))
+		}
+		corruptions = append(corruptions, corrupted[:rng.Intn(len(corrupted)+1)])
+	}
+	return corruptions
+}
@@ -2,6 +2,9 @@ package util
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
 )
 
 type Message interface {
@@ -34,3 +37,93 @@ func (b *Buffer) UnmarshalBinary(data []byte) error {
 	_, err := b.Buffer.Write(data)
 	return err
 }
+
+// maxPooledBufferSize is the max capacity of a buffer returned to bufferPool, so that the pool
+// doesn't hold on to the buffers of a few huge messages.
+const maxPooledBufferSize = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// GetBuffer returns an empty buffer with a capacity of at least n bytes from a pool. It's meant to
+// serialize a message without allocating the intermediate bytes, the result must be copied out of
+// the buffer before the buffer is returned with PutBuffer.
+func GetBuffer(n int) *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Grow(n)
+	return buf
+}
+
+// PutBuffer returns buf got from GetBuffer to the pool. buf and the bytes got from it must not be
+// used after it is returned.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// ValidateMessage checks that the length in the OpenFlow header of msg and the length returned by
+// msg.Len() both equal the length of the data marshaled from msg. It is used to find the message
+// types which under-report or over-report their lengths.
+func ValidateMessage(msg Message) error {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal %T: %w", msg, err)
+	}
+	if len(data) < 4 {
+		return fmt.Errorf("%T is marshaled to %d bytes, which is too short for an OpenFlow header", msg, len(data))
+	}
+	if headerLen := int(binary.BigEndian.Uint16(data[2:4])); headerLen != len(data) {
+		return fmt.Errorf("%T has length %d in header, but is marshaled to %d bytes", msg, headerLen, len(data))
+	}
+	if int(msg.Len()) != len(data) {
+		return fmt.Errorf("%T has Len() %d, but is marshaled to %d bytes", msg, msg.Len(), len(data))
+	}
+	return nil
+}
+
+// RoundTripStream splits data into OpenFlow messages by the length in their headers, parses each
+// message with parser, marshals it again and compares the result with the original bytes. It
+// returns an error for the first message which can't be framed or parsed, or which isn't marshaled
+// to identical bytes, with the offset of the message in data. It is used to find the message types
+// whose MarshalBinary and UnmarshalBinary are asymmetric.
+func RoundTripStream(data []byte, parser Parser) error {
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 8 {
+			return fmt.Errorf("truncated OpenFlow header at offset %d", offset)
+		}
+		msgType := data[offset+1]
+		msgLen := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if msgLen < 8 || offset+msgLen > len(data) {
+			return fmt.Errorf("invalid length %d of message type %d at offset %d", msgLen, msgType, offset)
+		}
+		// Parse a copy in case the parser keeps a reference to the bytes.
+		msgBytes := bytes.Clone(data[offset : offset+msgLen])
+		msg, err := parser.Parse(msgBytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse message type %d at offset %d: %w", msgType, offset, err)
+		}
+		if msg == nil {
+			return fmt.Errorf("no message parsed from message type %d at offset %d", msgType, offset)
+		}
+		out, err := msg.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("failed to marshal %T parsed at offset %d: %w", msg, offset, err)
+		}
+		if !bytes.Equal(out, data[offset:offset+msgLen]) {
+			i := 0
+			for i < len(out) && i < msgLen && out[i] == data[offset+i] {
+				i++
+			}
+			return fmt.Errorf("%T at offset %d is marshaled to %d bytes differing from the %d parsed bytes at byte %d (offset %d)",
+				msg, offset, len(out), msgLen, i, offset+i)
+		}
+		offset += msgLen
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package libOpenflow
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"antrea.io/libOpenflow/common"
+	"antrea.io/libOpenflow/openflow15"
+	"antrea.io/libOpenflow/util"
+	"antrea.io/libOpenflow/util/testutil"
+)
+
+// brokenMessage is a message which reports a header length different from its marshaled data.
+type brokenMessage struct {
+	common.Header
+}
+
+func (b *brokenMessage) MarshalBinary() ([]byte, error) {
+	data, err := b.Header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(data, 0, 0, 0, 0), nil
+}
+
+func TestValidateMessage(t *testing.T) {
+	assert.NoError(t, util.ValidateMessage(openflow15.NewEchoRequest()))
+	flowMod := openflow15.NewFlowMod()
+	flowMod.Match.AddField(*openflow15.NewEthTypeField(0x0800))
+	assert.NoError(t, util.ValidateMessage(flowMod))
+
+	broken := &brokenMessage{Header: *openflow15.NewBarrierRequest()}
+	assert.ErrorContains(t, util.ValidateMessage(broken), "has length 8 in header, but is marshaled to 12 bytes")
+}
+
+func TestBufferPool(t *testing.T) {
+	buf := util.GetBuffer(16)
+	assert.Equal(t, 0, buf.Len())
+	assert.GreaterOrEqual(t, buf.Cap(), 16)
+	buf.WriteString("stale")
+	util.PutBuffer(buf)
+
+	// A buffer from the pool is always empty, even if it has been used.
+	buf = util.GetBuffer(4)
+	assert.Equal(t, 0, buf.Len())
+	util.PutBuffer(buf)
+
+	// Marshaling a Match with pooled buffers doesn't share the bytes among the results.
+	m := openflow15.NewMatch()
+	m.AddField(*openflow15.NewEthTypeField(0x0800))
+	data1, err := m.MarshalBinary()
+	require.NoError(t, err)
+	expected := append([]byte(nil), data1...)
+	m.AddField(*openflow15.NewIpProtoField(6))
+	_, err = m.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, expected, data1)
+}
+
+// fixedParser is a parser which returns the same message for any bytes.
+type fixedParser struct {
+	msg util.Message
+}
+
+func (p fixedParser) Parse(b []byte) (util.Message, error) {
+	return p.msg, nil
+}
+
+func TestRoundTripStream(t *testing.T) {
+	var data []byte
+	hello, _ := common.NewHello(openflow15.VERSION)
+	echo := openflow15.NewEchoRequest()
+	echo.Xid = 1
+	flowMod := openflow15.NewFlowMod()
+	flowMod.Xid = 2
+	flowMod.Match.AddField(*openflow15.NewEthTypeField(0x0800))
+	for _, msg := range []util.Message{hello, echo, flowMod} {
+		msgBytes, err := msg.MarshalBinary()
+		require.NoError(t, err)
+		data = append(data, msgBytes...)
+	}
+	assert.NoError(t, util.RoundTripStream(data, parserIntf{}))
+
+	// An echo request declaring 4 more bytes than the message carries.
+	truncated := append([]byte{}, data...)
+	truncated = append(truncated, openflow15.VERSION, openflow15.Type_EchoRequest, 0, 12, 0, 0, 0, 3)
+	assert.ErrorContains(t, util.RoundTripStream(truncated, parserIntf{}), "invalid length 12")
+
+	// Every message is parsed as the hello, so the echo request isn't marshaled to the same bytes.
+	helloBytes, _ := hello.MarshalBinary()
+	assert.ErrorContains(t, util.RoundTripStream(data, fixedParser{hello}), fmt.Sprintf("at offset %d", len(helloBytes)))
+}
+
+func TestParseHexMessage(t *testing.T) {
+	// A flow mod adding "table=2,priority=100,cookie=0x1234,ip,nw_dst=10.0.0.1,actions=output:2".
+	msg := testutil.ParseHexMessage(t, parserIntf{}, `
+		060e0060 00000010 00000000 00001234 00000000 00000000 02000000 00000064
+		ffffffff ffffffff ffffffff 00000000
+		00010012 80000a02 0800 80001804 0a000001 000000000000
+		00040018 00000000 00000010 00000002 ffff0000 00000000`)
+
+	require.IsType(t, &openflow15.FlowMod{}, msg)
+	flowMod := msg.(*openflow15.FlowMod)
+	assert.Equal(t, uint32(0x10), flowMod.Xid)
+	assert.Equal(t, uint64(0x1234), flowMod.Cookie)
+	assert.Equal(t, uint8(2), flowMod.TableId)
+	assert.Equal(t, uint16(100), flowMod.Priority)
+	require.Len(t, flowMod.Match.Fields, 2)
+	assert.True(t, net.IP{10, 0, 0, 1}.Equal(flowMod.Match.Fields[1].Value.(*openflow15.Ipv4DstField).Ipv4Dst))
+	require.Len(t, flowMod.Instructions, 1)
+	actions := flowMod.Instructions[0].(*openflow15.InstrActions).Actions
+	require.Len(t, actions, 1)
+	assert.Equal(t, uint32(2), actions[0].(*openflow15.ActionOutput).Port)
+}
+
+func TestSemanticFieldName(t *testing.T) {
+	names := util.OXXFieldNames(map[string][2]uint16{
+		"NXM_OF_IP_SRC":   {0x0000, 7},
+		"OXM_OF_IPV4_SRC": {0x8000, 11},
+		"NXM_NX_REG0":     {0x0001, 0},
+	}, func(header [2]uint16) (uint16, uint8) {
+		return header[0], uint8(header[1])
+	})
+	assert.Equal(t, "ipv4_src", util.SemanticFieldName(names, 0x0000, 7))
+	assert.Equal(t, "ipv4_src", util.SemanticFieldName(names, 0x8000, 11))
+	assert.Equal(t, "reg0", util.SemanticFieldName(names, 0x0001, 0))
+	assert.Equal(t, "class1_field1", util.SemanticFieldName(names, 0x0001, 1))
+}